@@ -1,32 +1,24 @@
 package main
 
 import (
-	"bytes"
-	"compress/gzip"
-	"compress/zlib"
+	"context"
 	"fmt"
 	"io"
 	// "io/ioutil"
-	"math/rand"
 	"net/http"
-	"net/url"
 	"os"
 	"path/filepath"
-	"reflect"
 	"regexp"
 	"strings"
 	"time"
 
 	// "github.com/abadojack/whatlanggo"
-	"github.com/beevik/etree"
 	"github.com/gin-gonic/gin"
 	"github.com/gocolly/colly/v2"
 	cproxy "github.com/gocolly/colly/v2/proxy"
 	"github.com/gocolly/colly/v2/queue"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/k0kubun/pp"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/nozzle/throttler"
 	cmap "github.com/orcaman/concurrent-map"
 	"github.com/qor/admin"
@@ -36,25 +28,29 @@ import (
 	"github.com/qor/validations"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
-	"golang.org/x/net/proxy"
 
 	// padmin "github.com/lucmichalski/dmoz-utils/pkg/admin"
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
 	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+	"github.com/lucmichalski/dmoz-utils/pkg/jobqueue"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+	"github.com/lucmichalski/dmoz-utils/pkg/sitemap"
 )
 
 var (
 	// store          *badger.DB
 	isHelp       bool
+	configFile   string
 	isVerbose    bool
 	isAdmin      bool
 	isDataset    bool
 	isProxy      bool
+	isSitemap    bool
 	parallelJobs int
-	queueMaxSize = 100000000
 	cachePath    = "./data/cache"
 	// storagePath    = "./data/badger"
-	// sitemapRootURL = "https://s3.amazonaws.com/com.alexa.sitemap/sitemap_index.xml"
-	pageTestURL = "https://www.alexa.com/topsites/category/Top/Health/Medicine"
+	sitemapRootURL = "https://s3.amazonaws.com/com.alexa.sitemap/sitemap_index.xml"
+	pageTestURL    = "https://www.alexa.com/topsites/category/Top/Health/Medicine"
 )
 
 const (
@@ -62,29 +58,13 @@ const (
 	torPrivoxyAddress = "socks5://51.91.21.67:8119"
 )
 
-type website struct {
-	gorm.Model
-	Site                       string `gorm:"size:255;unique"`
-	DailyTimeOnSite            string
-	DailyPageviewsPerVisitor   string
-	PercentOfTrafficFromSearch string
-	TotalSitesLinkingIn        float64
-	DetectLang                 string
-	DetectLangConfidence       float64
-	Categories                 []category `gorm:"many2many:feed_categories;"`
-	CategoryPath               string
-}
-
-type category struct {
-	gorm.Model
-	Name string
-}
-
 func main() {
 	pflag.IntVarP(&parallelJobs, "parallel-jobs", "j", 3, "parallel jobs.")
 	pflag.BoolVarP(&isDataset, "dataset", "d", false, "dump dataset.")
+	pflag.BoolVarP(&isSitemap, "sitemap", "", false, "queue the topsites pages listed by the alexa sitemaps.")
 	pflag.BoolVarP(&isAdmin, "admin", "a", false, "launch web admin.")
 	pflag.BoolVarP(&isVerbose, "verbose", "v", false, "verbose mode.")
+	pflag.StringVarP(&configFile, "config", "", "config.json", "configuration file, overridden by the ND_* environment variables.")
 	pflag.BoolVarP(&isHelp, "help", "h", false, "help info.")
 	pflag.Parse()
 	if isHelp {
@@ -92,11 +72,11 @@ func main() {
 		os.Exit(1)
 	}
 
-	// pp.Println(fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?charset=utf8mb4,utf8&parseTime=True", os.Getenv("ND_MYSQL_USER"), os.Getenv("ND_MYSQL_PASSWORD"), os.Getenv("ND_MYSQL_HOST"), os.Getenv("ND_MYSQL_PORT"), "dataset_news"))
-
-	// Instanciate the mysql client
-	DB, err := gorm.Open("sqlite3", "alexa.db")
-	// DB, err := gorm.Open("mysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?charset=utf8mb4,utf8&parseTime=True", os.Getenv("ND_MYSQL_USER"), os.Getenv("ND_MYSQL_PASSWORD"), os.Getenv("ND_MYSQL_HOST"), os.Getenv("ND_MYSQL_PORT"), "dataset_news"))
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	DB, err := cfg.Database.Open()
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -106,8 +86,9 @@ func main() {
 	validations.RegisterCallbacks(DB)
 	media.RegisterCallbacks(DB)
 
-	DB.AutoMigrate(&category{})
-	DB.AutoMigrate(&website{})
+	if err := models.Migrate(DB); err != nil {
+		log.Fatal(err)
+	}
 
 	if isDataset {
 
@@ -139,7 +120,7 @@ func main() {
 		}
 
 		var count cnt
-		DB.Raw("select count(id) as count FROM websites WHERE categories_path!=''").Scan(&count)
+		DB.Raw("select count(id) as count FROM websites WHERE source=? AND path!=''", models.SourceAlexa).Scan(&count)
 
 		// instanciate throttler
 		t := throttler.New(48, count.Count)
@@ -148,7 +129,7 @@ func main() {
 		imgCounter := 0
 
 		var results []res
-		DB.Raw("select site, category_path, daily_time_on_site, daily_pageviews_per_visitor, percent_of_traffic_from_search, total_sites_linking_in, detect_lang, detect_lang_confidence FROM websites WHERE categories_path!=''").Scan(&results)
+		DB.Raw("select w.link as site, w.path as category_path, r.daily_time_on_site, r.daily_pageviews_per_visitor, r.percent_of_traffic_from_search, r.total_sites_linking_in, w.language as detect_lang, w.lang_confidence as detect_lang_confidence FROM websites w LEFT JOIN ranks r ON r.website_id=w.id WHERE w.source=? AND w.path!=''", models.SourceAlexa).Scan(&results)
 		for _, result := range results {
 
 			go func(r res) error {
@@ -192,8 +173,8 @@ func main() {
 
 		// padmin.SetupDashboard(DB, Admin)
 
-		Admin.AddResource(&category{})
-		Admin.AddResource(&website{})
+		Admin.AddResource(&models.Category{})
+		Admin.AddResource(&models.Website{})
 
 		// initalize an HTTP request multiplexer
 		mux := http.NewServeMux()
@@ -236,7 +217,7 @@ func main() {
 	m := cmap.New()
 
 	// medium user regex pattern
-	topAlexaPatternRegexp, err := regexp.Compile(`https://alexa\\.com/topsites/(.*)`)
+	topAlexaPatternRegexp, err := regexp.Compile(`https://(www\.)?alexa\.com/topsites/(.*)`)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -259,34 +240,19 @@ func main() {
 	}
 
 	// create a request queue with 1 consumer thread
-	q, _ := queue.New(
+	storage := jobqueue.New(cfg.Queue, "alexa")
+	q, err := queue.New(
 		parallelJobs, // Number of consumer threads set to 1 to avoid dead lock on database
-		&queue.InMemoryQueueStorage{
-			MaxSize: queueMaxSize,
-		}, // Use default queue storage
+		storage,      // persisted in data/queue.db, resumed after a restart
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer storage.Close()
+	storage.Track(c)
 
 	c.DisableCookies()
 
-	// Create a callback on the XPath query searching for the URLs
-	c.OnXML("//sitemap/loc", func(e *colly.XMLElement) {
-		q.AddURL(e.Text)
-		log.Println("//sitemap/loc", e.Text)
-	})
-
-	// https://s3.amazonaws.com/com.alexa.sitemap/sitemap.xml.gz
-
-	// Create a callback on the XPath query searching for the URLs
-	c.OnXML("//urlset/url/loc", func(e *colly.XMLElement) {
-		match := topAlexaPatternRegexp.FindString(e.Text)
-		if match != "" {
-			log.Println("match", match)
-			m.Set(match, true)
-		} else {
-			log.Println("skipping", e.Text)
-		}
-	})
-
 	c.OnHTML(`body`, func(e *colly.HTMLElement) {
 		fmt.Println("hello world !")
 	})
@@ -340,36 +306,37 @@ func main() {
 		r.Ctx.Put("url", r.URL.String())
 	})
 
-	/*
-		// Start scraping on https://www.autosphere.fr
-		log.Infoln("extractSitemapIndex...")
-		sitemaps, err := extractSitemapIndex(sitemapRootURL)
+	if isSitemap {
+		client, err := sitemap.NewClient(torProxyAddress)
 		if err != nil {
-			log.Fatal("ExtractSitemapIndex:", err)
+			log.Fatal(err)
 		}
-
-		shuffle(sitemaps)
-		for _, sitemap := range sitemaps {
-			sitemap = strings.Replace(sitemap, "https://www.alexa.com/", "https://s3.amazonaws.com/com.alexa.sitemap/", -1)
-			log.Infoln("processing ", sitemap)
-			if strings.Contains(sitemap, ".gz") {
-				log.Infoln("extract sitemap gz compressed...")
-				// rename url parts
-				// q.AddURL(sitemap)
-				locs, err := extractSitemapGZ(sitemap)
-				if err != nil {
-					log.Warnln("ExtractSitemapGZ", err)
-				}
-				shuffle(locs)
-				for _, loc := range locs {
-					q.AddURL(loc)
-				}
-			} else {
-				q.AddURL(sitemap)
+		// the sitemaps listed by the index are served from the s3 bucket
+		sitemaps := sitemap.New(context.Background(), sitemapRootURL)
+		sitemaps.Client = client
+		sitemaps.Rewrite = func(loc string) string {
+			return strings.Replace(loc, "https://www.alexa.com/", "https://s3.amazonaws.com/com.alexa.sitemap/", -1)
+		}
+		for {
+			entry, err := sitemaps.Next()
+			if err == io.EOF {
+				break
 			}
+			if err != nil {
+				log.Warnln(err)
+				continue
+			}
+			match := topAlexaPatternRegexp.FindString(entry.Loc)
+			if match == "" {
+				log.Debugln("skipping", entry.Loc)
+				continue
+			}
+			m.Set(match, true)
+			q.AddURL(match)
 		}
-	*/
-	q.AddURL(pageTestURL)
+	} else {
+		q.AddURL(pageTestURL)
+	}
 
 	// Consume URLs
 	q.Run(c)
@@ -410,8 +377,8 @@ func main() {
 
 }
 
-func createOrUpdateCategory(db *gorm.DB, cat *category) (*category, error) {
-	var existingCategory category
+func createOrUpdateCategory(db *gorm.DB, cat *models.Category) (*models.Category, error) {
+	var existingCategory models.Category
 	if db.Where("name = ?", cat.Name).First(&existingCategory).RecordNotFound() {
 		err := db.Create(cat).Error
 		return cat, err
@@ -421,165 +388,6 @@ func createOrUpdateCategory(db *gorm.DB, cat *category) (*category, error) {
 	return cat, nil
 }
 
-func shuffle(slice interface{}) {
-	rv := reflect.ValueOf(slice)
-	swap := reflect.Swapper(slice)
-	length := rv.Len()
-	for i := length - 1; i > 0; i-- {
-		j := rand.Intn(i + 1)
-		swap(i, j)
-	}
-}
-
-func extractSitemapIndex(rawUrl string) ([]string, error) {
-	client := &http.Client{
-		Timeout: 40 * time.Second,
-	}
-
-	tbProxyURL, err := url.Parse(torProxyAddress)
-	if err != nil {
-		return nil, err
-	}
-
-	tbDialer, err := proxy.FromURL(tbProxyURL, proxy.Direct)
-	if err != nil {
-		return nil, err
-	}
-	tbTransport := &http.Transport{
-		Dial: tbDialer.Dial,
-	}
-	client.Transport = tbTransport
-
-	request, err := http.NewRequest("GET", rawUrl, nil)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	response, err := client.Do(request)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	defer response.Body.Close()
-
-	doc := etree.NewDocument()
-	if _, err := doc.ReadFrom(response.Body); err != nil {
-		return nil, err
-	}
-	var urls []string
-	index := doc.SelectElement("sitemapindex")
-	sitemaps := index.SelectElements("sitemap")
-	for _, sitemap := range sitemaps {
-		loc := sitemap.SelectElement("loc")
-		log.Infoln("loc:", loc.Text())
-		urls = append(urls, loc.Text())
-	}
-	return urls, nil
-}
-
-func extractSitemapGZ(rawUrl string) ([]string, error) {
-	client := &http.Client{
-		Timeout: 40 * time.Second,
-	}
-
-	tbProxyURL, err := url.Parse(torProxyAddress)
-	if err != nil {
-		return nil, err
-	}
-
-	tbDialer, err := proxy.FromURL(tbProxyURL, proxy.Direct)
-	if err != nil {
-		return nil, err
-	}
-	tbTransport := &http.Transport{
-		Dial:               tbDialer.Dial,
-		DisableCompression: true,
-	}
-	client.Transport = tbTransport
-
-	request, err := http.NewRequest("GET", rawUrl, nil)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	response, err := client.Do(request)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	defer response.Body.Close()
-
-	var reader io.ReadCloser
-	reader, err = gzip.NewReader(response.Body)
-	if err != nil {
-		fmt.Println(err)
-		return nil, err
-	}
-	defer reader.Close()
-
-	/*
-		s, err := ioutil.ReadAll(reader)
-		if err != nil {
-			panic(err)
-		}
-
-		fmt.Println("decompressed:\t", string(s))
-	*/
-
-	doc := etree.NewDocument()
-	if _, err := doc.ReadFrom(reader); err != nil {
-		panic(err)
-	}
-	var urls []string
-	urlset := doc.SelectElement("urlset")
-	entries := urlset.SelectElements("url")
-	for _, entry := range entries {
-		loc := entry.SelectElement("loc")
-		log.Infoln("loc:", loc.Text())
-		urls = append(urls, loc.Text())
-	}
-	return urls, err
-}
-
-func readGzip(content []byte) error {
-	var buf *bytes.Buffer = bytes.NewBuffer(content)
-
-	gRead, err := zlib.NewReader(buf)
-	if err != nil {
-		return err
-	}
-
-	if t, err := io.Copy(os.Stdout, gRead); err != nil {
-		fmt.Println(t)
-		return err
-	}
-
-	if err := gRead.Close(); err != nil {
-		return err
-	}
-	return nil
-}
-
-func removeDuplicates(elements []string) []string {
-	// Use map to record duplicates as we find them.
-	encountered := map[string]bool{}
-	result := []string{}
-
-	for v := range elements {
-		elements[v] = strings.ToLower(elements[v])
-		if encountered[elements[v]] == true {
-			// Do not add duplicate.
-		} else {
-			// Record this element as an encountered element.
-			encountered[elements[v]] = true
-			// Append to result slice.
-			result = append(result, elements[v])
-		}
-	}
-	// Return the new slice.
-	return result
-}
-
 func check(e error) {
 	if e != nil {
 		panic(e)
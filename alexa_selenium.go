@@ -12,9 +12,7 @@ import (
 	"time"
 
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
 	// "github.com/k0kubun/pp"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/nozzle/throttler"
 	"github.com/qor/validations"
 	log "github.com/sirupsen/logrus"
@@ -22,6 +20,9 @@ import (
 	"github.com/tebeka/selenium"
 	"github.com/tebeka/selenium/chrome"
 	slog "github.com/tebeka/selenium/log"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
 )
 
 var (
@@ -31,6 +32,7 @@ var (
 	isLimit      int
 	isVerbose    bool
 	isHelp       bool
+	configFile   string
 	parallelJobs int
 )
 
@@ -39,6 +41,7 @@ func main() {
 	pflag.IntVarP(&isLimit, "limit", "", 500000, "limit the number of results returned.")
 	pflag.BoolVarP(&isUnescape, "unescape", "u", false, "unescape path characters")
 	pflag.BoolVarP(&isVerbose, "verbose", "v", false, "verbose mode.")
+	pflag.StringVarP(&configFile, "config", "", "config.json", "configuration file, overridden by the ND_* environment variables.")
 	pflag.BoolVarP(&isHelp, "help", "h", false, "help info.")
 	pflag.Parse()
 	if isHelp {
@@ -51,17 +54,18 @@ func main() {
 
 	// init database
 	var err error
-	DB, err = gorm.Open("mysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?charset=utf8mb4&collation=utf8mb4_unicode_ci&parseTime=True&loc=Local", "root", "megaweb", "localhost", "3308", "dataset_dmoz"))
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	DB, err = cfg.Database.Open()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	DB.Set("gorm:table_options", "ENGINE=InnoDB CHARSET=utf8mb4")
-	DB.AutoMigrate(&AlexaWebsite{})
-	DB.AutoMigrate(&Category{})
-	DB.AutoMigrate(&Rss{})
-	DB.AutoMigrate(&Rank{})
-	DB.AutoMigrate(&Sitemap{})
+	if err := models.Migrate(DB); err != nil {
+		log.Fatal(err)
+	}
 	validations.RegisterCallbacks(DB)
 
 	// fix path's escaping
@@ -151,8 +155,8 @@ func main() {
 		}
 
 		// check if exists
-		var categoryExists AlexaWebsite
-		if !DB.Where("path = ?", decodedPath).First(&categoryExists).RecordNotFound() {
+		var categoryExists models.Website
+		if !DB.Where("path = ? AND source = ?", decodedPath, models.SourceAlexa).First(&categoryExists).RecordNotFound() {
 			fmt.Println("Skipping path:", decodedPath)
 			continue
 		}
@@ -199,9 +203,10 @@ func main() {
 			}
 			log.Println("Site: ", output)
 
-			entry := &AlexaWebsite{
-				Link: output,
-				Path: decodedPath,
+			entry := &models.Website{
+				Link:   output,
+				Source: models.SourceAlexa,
+				Path:   decodedPath,
 			}
 
 			err = createOrUpdateWebsite(DB, entry)
@@ -263,7 +268,7 @@ func scanPaths(db *gorm.DB) {
 	}
 
 	var results []result
-	query := fmt.Sprintf("select link, path FROM alexa_websites ORDER BY RAND() LIMIT %d,%d", offset, isLimit)
+	query := fmt.Sprintf("select link, path FROM websites WHERE source='%s' ORDER BY %s LIMIT %d OFFSET %d", models.SourceAlexa, models.RandomOrder(DB), isLimit, offset)
 	fmt.Println("query:", query)
 
 	t := throttler.New(12, 100000000)
@@ -274,7 +279,7 @@ func scanPaths(db *gorm.DB) {
 		go func(entry result) error {
 			defer t.Done(nil)
 			fmt.Println("entry.Link:", entry.Link, "entry.Path:", entry.Path)
-			website := &AlexaWebsite{}
+			website := &models.Website{}
 			if !DB.Where("link = ?", entry.Link).First(&website).RecordNotFound() {
 				decodedPath, err := url.QueryUnescape(entry.Path)
 				if err != nil {
@@ -304,85 +309,20 @@ func scanPaths(db *gorm.DB) {
 
 }
 
-func createOrUpdateWebsite(db *gorm.DB, website *AlexaWebsite) error {
-	var existingWebsite AlexaWebsite
+// createOrUpdateWebsite creates the website, or updates its path when it was
+// already collected from the same source. Websites listed by another source
+// are left untouched.
+func createOrUpdateWebsite(db *gorm.DB, website *models.Website) error {
+	var existingWebsite models.Website
 	if db.Where("link = ?", website.Link).First(&existingWebsite).RecordNotFound() {
 		return db.Create(website).Error
 	}
 	website.ID = existingWebsite.ID
 	website.CreatedAt = existingWebsite.CreatedAt
-	return db.Save(website).Error
-}
-
-type AlexaWebsite struct {
-	gorm.Model
-	Link           string   `gorm:"size:255;unique"`
-	Alive          bool     `gorm:"index:alive"`
-	StatusCode     int      `gorm:"index:status_code"`
-	Name           string   `gorm:"index:name; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Path           string   `gorm:"index:path; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Title          string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Description    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	CategoryID     uint     `l10n:"sync"`
-	Category       Category `l10n:"sync"`
-	Wap            string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Analyzed       int      `gorm:"type:tinyint" sql:"type:tinyint`
-	TextExtract    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	ArticleText    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	RobotsTxt      string   `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	Host           string
-	Scheme         string
-	Domain         string
-	Tld            string
-	Language       string
-	LangConfidence float64
-	Ranking        Rank
-	Rss            []Rss
-	Sitemaps       []Sitemap
-}
-
-type Sitemap struct {
-	gorm.Model
-	Href      string `sql:"type:longtext"`
-	Index     bool
-	Gziped    bool
-	WebsiteID uint
-}
-
-type Rss struct {
-	gorm.Model
-	Href               string `sql:"type:longtext"`
-	Language           string
-	LanguageConfidence float64
-	WebsiteID          uint
-}
-
-type Rank struct {
-	gorm.Model
-	Alexa    int
-	Quancast int
-	Majestic int
-}
-
-type Category struct {
-	gorm.Model
-	Name       string `gorm:"index:name"`
-	Code       string `gorm:"index:code"`
-	Categories []Category
-	CategoryID uint `gorm:"index:category_id"`
-}
-
-func (category Category) Validate(db *gorm.DB) {
-	if strings.TrimSpace(category.Name) == "" {
-		db.AddError(validations.NewError(category, "Name", "Name can not be empty"))
-	}
-}
-
-func (category Category) DefaultPath() string {
-	if len(category.Code) > 0 {
-		return fmt.Sprintf("/category/%s", category.Code)
+	if existingWebsite.Source != website.Source {
+		return nil
 	}
-	return "/"
+	return db.Model(&existingWebsite).Update("path", website.Path).Error
 }
 
 func shuffle(slice interface{}) {
@@ -1,11 +1,12 @@
 package main
 
 import (
-	"io/ioutil"
+	"encoding/json"
 	"log"
+	"os"
 	"time"
 
-	"github.com/beevik/etree"
+	"github.com/lucmichalski/dmoz-utils/pkg/rdf"
 )
 
 type Dmoz struct {
@@ -15,57 +16,40 @@ type Dmoz struct {
 }
 
 func main() {
-	var dmozs []Dmoz
 	starttime := time.Now()
-	dat, err := ioutil.ReadFile("./shared/dataset/content.rdf.u8")
+	file, err := rdf.Open("./shared/dataset/content.rdf.u8")
 	if err != nil {
 		panic(err)
 	}
+	defer file.Close()
 
-	doc := etree.NewDocument()
-	err = doc.ReadFromBytes(dat)
+	// stream one json document per topic instead of keeping them all in memory
+	enc := json.NewEncoder(os.Stdout)
 
-	if err != nil {
-		log.Println(err, string(dat))
-	}
+	err = rdf.Parse(file, rdf.Handler{
+		OnTopic: func(entry *rdf.Topic) error {
+			d := Dmoz{}
+
+			//catid
+			if entry.CatID == "" {
+				return nil
+			}
+			log.Println("catid is:", entry.CatID)
+			d.Cateid = entry.CatID
+
+			log.Println("topic is:", entry.ID)
+			d.Topic = entry.ID
 
-	root := doc.SelectElement("RDF")
-
-	for _, entry := range root.SelectElements("Topic") {
-		d := Dmoz{}
-
-		//catid
-		catId := entry.SelectElement("catid")
-		if catId != nil {
-			log.Println("catid is:", catId.Text())
-			d.Cateid = catId.Text()
-		} else {
-			continue
-		}
-
-		topic := entry.SelectAttr("r:id").Value
-		log.Println("topic is:", topic)
-		d.Topic = topic
-
-		//link1
-		var linkarr []string
-		link1 := entry.SelectElement("link1")
-		if link1 != nil {
-			url := link1.SelectAttr("r:resource").Value
-			log.Println("url is:", url)
-			linkarr = append(linkarr, url)
-		}
-		//link
-		links := entry.SelectElements("link")
-		if links != nil {
-			for _, url := range links {
-				urltxt := url.SelectAttr("r:resource").Value
-				log.Println("url is:", urltxt)
-				linkarr = append(linkarr, urltxt)
+			//link1 and link
+			for _, url := range entry.URLs() {
+				log.Println("url is:", url)
+				d.Link = append(d.Link, url)
 			}
-			d.Link = linkarr
-		}
-		dmozs = append(dmozs, d)
+			return enc.Encode(d)
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	log.Println("Finish Parsing RDF xml, time period:", time.Now().Sub(starttime))
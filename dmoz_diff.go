@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/pflag"
+
+	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+	"github.com/lucmichalski/dmoz-utils/pkg/rdf"
+)
+
+var (
+	isHelp     bool
+	oldDump    string
+	newDump    string
+	changesCsv string
+	churnCsv   string
+	churnDepth int
+)
+
+type churn struct {
+	Added    int
+	Removed  int
+	MovedIn  int
+	MovedOut int
+}
+
+func main() {
+	pflag.StringVarP(&oldDump, "old", "", "./shared/dataset/content.rdf.u8", "older content dump, indexed in memory (about 130 bytes per url, twice that while diffing).")
+	pflag.StringVarP(&newDump, "new", "", "", "newer content dump.")
+	pflag.StringVarP(&changesCsv, "output", "o", "dmoz_diff.csv", "csv file listing the changes per url.")
+	pflag.StringVarP(&churnCsv, "churn", "c", "dmoz_churn.csv", "csv file with the churn counts per category.")
+	pflag.IntVarP(&churnDepth, "depth", "", 0, "category depth the churn is aggregated at, 0 for the full path.")
+	pflag.BoolVarP(&isHelp, "help", "h", false, "help info.")
+	pflag.Parse()
+	if isHelp || newDump == "" {
+		pflag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	starttime := time.Now()
+
+	file, err := rdf.Open(oldDump)
+	if err != nil {
+		log.Fatal(err)
+	}
+	old, err := rdf.Index(file)
+	file.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("indexed", len(old), "urls from", oldDump)
+
+	csvChanges, err := ccsv.NewCsvWriter(changesCsv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer csvChanges.Close()
+	csvChanges.Write([]string{"url", "change", "old_path", "new_path"})
+
+	counts := make(map[string]*churn)
+	count := func(topic string) *churn {
+		category := truncateTopic(topic, churnDepth)
+		c, ok := counts[category]
+		if !ok {
+			c = &churn{}
+			counts[category] = c
+		}
+		return c
+	}
+
+	file, err = rdf.Open(newDump)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	err = rdf.Diff(old, file, func(change rdf.Change) error {
+		switch change.Kind {
+		case rdf.Added:
+			count(change.NewTopic).Added++
+		case rdf.Removed:
+			count(change.OldTopic).Removed++
+		case rdf.Moved:
+			// a url leaving or joining a topic may stay in its others
+			if change.OldTopic != "" {
+				count(change.OldTopic).MovedOut++
+			}
+			if change.NewTopic != "" {
+				count(change.NewTopic).MovedIn++
+			}
+		}
+		return csvChanges.Write([]string{change.URL, change.Kind, change.OldTopic, change.NewTopic})
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	csvChurn, err := ccsv.NewCsvWriter(churnCsv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer csvChurn.Close()
+	csvChurn.Write([]string{"path", "added", "removed", "moved_in", "moved_out"})
+
+	categories := make([]string, 0, len(counts))
+	for category := range counts {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		c := counts[category]
+		csvChurn.Write([]string{category, strconv.Itoa(c.Added), strconv.Itoa(c.Removed), strconv.Itoa(c.MovedIn), strconv.Itoa(c.MovedOut)})
+	}
+
+	log.Println("Finish diffing RDF dumps, categories:", len(counts), "time period:", time.Now().Sub(starttime))
+}
+
+// truncateTopic keeps the first depth levels of a topic path
+func truncateTopic(topic string, depth int) string {
+	if depth <= 0 {
+		return topic
+	}
+	parts := strings.SplitN(topic, "/", depth+1)
+	if len(parts) > depth {
+		parts = parts[:depth]
+	}
+	return strings.Join(parts, "/")
+}
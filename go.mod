@@ -4,13 +4,14 @@ go 1.14
 
 require (
 	github.com/PuerkitoBio/goquery v1.5.0
-	github.com/abadojack/whatlanggo v1.0.1 // indirect
+	github.com/abadojack/whatlanggo v1.0.1
 	github.com/asaskevich/govalidator v0.0.0-20200428143746-21a406dcc535 // indirect
-	github.com/beevik/etree v1.1.0 // indirect
+	github.com/beevik/etree v1.1.0
+	github.com/codegangsta/cli v1.20.0
 	github.com/disintegration/imaging v1.6.2 // indirect
-	github.com/emiruz/textextract v0.0.0-20181015085145-068c72fa09f3 // indirect
-	github.com/gelembjuk/articletext v0.0.0-20160728042224-1fb1f5fd32d6 // indirect
-	github.com/gin-gonic/gin v1.6.3 // indirect
+	github.com/emiruz/textextract v0.0.0-20181015085145-068c72fa09f3
+	github.com/gelembjuk/articletext v0.0.0-20160728042224-1fb1f5fd32d6
+	github.com/gin-gonic/gin v1.6.3
 	github.com/gocolly/colly/v2 v2.0.1
 	github.com/gorilla/context v1.1.1 // indirect
 	github.com/gorilla/sessions v1.2.0 // indirect
@@ -19,29 +20,35 @@ require (
 	github.com/jinzhu/gorm v1.9.12
 	github.com/jinzhu/now v1.0.1
 	github.com/joeguo/tldextract v0.0.0-20180214020933-b623e0574407 // indirect
-	github.com/jpillora/go-tld v1.0.0 // indirect
+	github.com/jpillora/go-tld v1.0.0
 	github.com/k0kubun/pp v3.0.1+incompatible
 	github.com/mattn/go-colorable v0.1.6 // indirect
 	github.com/mattn/go-sqlite3 v2.0.3+incompatible // indirect
 	github.com/microcosm-cc/bluemonday v1.0.2 // indirect
-	github.com/nozzle/throttler v0.0.0-20180817012639-2ea982251481 // indirect
+	github.com/mmcdole/goxpp v1.1.1
+	github.com/nozzle/throttler v0.0.0-20180817012639-2ea982251481
 	github.com/olekukonko/tablewriter v0.0.4 // indirect
-	github.com/orcaman/concurrent-map v0.0.0-20190826125027-8c72a8bb44f6 // indirect
+	github.com/orcaman/concurrent-map v0.0.0-20190826125027-8c72a8bb44f6
 	github.com/qor/admin v0.0.0-20200315024928-877b98a68a6f
-	github.com/qor/assetfs v0.0.0-20170713023933-ff57fdc13a14 // indirect
-	github.com/qor/media v0.0.0-20191022071353-19cf289e17d4 // indirect
+	github.com/qor/assetfs v0.0.0-20170713023933-ff57fdc13a14
+	github.com/qor/media v0.0.0-20191022071353-19cf289e17d4
 	github.com/qor/middlewares v0.0.0-20170822143614-781378b69454 // indirect
-	github.com/qor/qor v0.0.0-20200224122013-457d2e3f50e1 // indirect
+	github.com/qor/qor v0.0.0-20200224122013-457d2e3f50e1
 	github.com/qor/responder v0.0.0-20171031032654-b6def473574f // indirect
 	github.com/qor/roles v0.0.0-20171127035124-d6375609fe3e // indirect
 	github.com/qor/serializable_meta v0.0.0-20180510060738-5fd8542db417 // indirect
 	github.com/qor/session v0.0.0-20170907035918-8206b0adab70 // indirect
-	github.com/qor/validations v0.0.0-20171228122639-f364bca61b46 // indirect
+	github.com/qor/validations v0.0.0-20171228122639-f364bca61b46
+	github.com/saintfish/chardet v0.0.0-20120816061221-3af4cd4741ca
 	github.com/sirupsen/logrus v1.6.0
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/pflag v1.0.5
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf // indirect
-	github.com/tebeka/selenium v0.9.9 // indirect
+	github.com/stretchr/testify v1.8.1
+	github.com/tebeka/selenium v0.9.9
 	github.com/theplant/cldr v0.0.0-20190423050709-9f76f7ce4ee8 // indirect
+	github.com/xitongsys/parquet-go v1.6.2
+	github.com/xitongsys/parquet-go-source v0.0.0-20200817004010-026bad9b25d0
 	golang.org/x/net v0.0.0-20200324143707-d3edc9973b7e
+	golang.org/x/text v0.3.2
 	gopkg.in/neurosnap/sentences.v1 v1.0.6
 )
@@ -4,7 +4,11 @@ import (
 	"encoding/json"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
 	"github.com/lucmichalski/dmoz-utils/pkg/gowap"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+	"github.com/lucmichalski/dmoz-utils/pkg/politeness"
+	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
 )
 
 func main() {
@@ -13,6 +17,29 @@ func main() {
 	if err != nil {
 		log.Errorln(err)
 	}
+
+	// the robots.txt files are the ones stored by the other commands
+	cfg, err := config.Load("config.json")
+	if err != nil {
+		log.Fatal(err)
+	}
+	DB, err := cfg.Database.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer DB.Close()
+	if err := models.Migrate(DB); err != nil {
+		log.Fatal(err)
+	}
+	userAgent := cfg.Politeness.UserAgent
+	if userAgent == "" {
+		userAgent = politeness.DefaultUserAgent
+	}
+	wapp.Robots = &robotstxt.Checker{
+		UserAgent: userAgent,
+		TTL:       cfg.Politeness.RobotsTTL.Duration,
+		Store:     models.RobotsStore{DB: DB},
+	}
 	res, err := wapp.Analyze(url)
 	if err != nil {
 		log.Errorln(err)
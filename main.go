@@ -1,26 +1,27 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/abadojack/whatlanggo"
-	"github.com/beevik/etree"
 	"github.com/gin-gonic/gin"
-	"github.com/go-sql-driver/mysql"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/proxy"
 	"github.com/gocolly/colly/v2/queue"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/nozzle/throttler"
 	"github.com/qor/admin"
 	"github.com/qor/assetfs"
@@ -30,9 +31,21 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/lucmichalski/dmoz-utils/pkg/articletext"
+	"github.com/lucmichalski/dmoz-utils/pkg/bulk"
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
 	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+	"github.com/lucmichalski/dmoz-utils/pkg/export"
+	"github.com/lucmichalski/dmoz-utils/pkg/fetch"
+	"github.com/lucmichalski/dmoz-utils/pkg/jobqueue"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+	"github.com/lucmichalski/dmoz-utils/pkg/politeness"
+	"github.com/lucmichalski/dmoz-utils/pkg/rdf"
+	"github.com/lucmichalski/dmoz-utils/pkg/recheck"
+	"github.com/lucmichalski/dmoz-utils/pkg/split"
 	"github.com/lucmichalski/dmoz-utils/pkg/textextract"
 	"github.com/lucmichalski/dmoz-utils/pkg/tldparser"
+	"github.com/lucmichalski/dmoz-utils/pkg/warc"
+	"github.com/lucmichalski/dmoz-utils/pkg/work"
 	// tld "github.com/lucmichalski/dmoz-utils/pkg/go-tld"
 	// "github.com/joeguo/tldextract"
 	"github.com/lucmichalski/dmoz-utils/pkg/gowap"
@@ -40,40 +53,109 @@ import (
 )
 
 var (
-	isHelp       bool
-	isVerbose    bool
-	isAdmin      bool
-	isDataset    bool
-	isImport     bool
-	isDump       bool
-	isLoadData   bool
-	isScanFeeds  bool
-	isImportRDF  bool
-	isTorProxy   bool
-	isSitemap    bool
-	isHostUpdate bool
-	isLangDetect bool
-	isLoadDmoz   bool
-	isScanHome   bool
-	isDmozDump   bool
-	isOffset     int
-	isLimit      int
-	parallelJobs int
-	queueMaxSize = 100000000
-	cachePath    = "./data/cache"
-	DB           *gorm.DB
+	isHelp              bool
+	configFile          string
+	isVerbose           bool
+	isAdmin             bool
+	isDataset           bool
+	isImport            bool
+	isDump              bool
+	isLoadData          bool
+	isScanFeeds         bool
+	isImportRDF         bool
+	isStructure         bool
+	isResume            bool
+	isBatchSize         int
+	rdfFile             string
+	rdfStructure        string
+	isTorProxy          bool
+	isSitemap           bool
+	isScanRobots        bool
+	isRecheck           bool
+	isFromCache         bool
+	textMinScore        int
+	isHostUpdate        bool
+	isLangDetect        bool
+	isLoadDmoz          bool
+	isScanHome          bool
+	isDmozDump          bool
+	isKidsDump          bool
+	isExportGzip        bool
+	isExportAlive       bool
+	isExportContent     bool
+	exportFile          string
+	exportFormat        string
+	exportLanguages     []string
+	exportMinConfidence float64
+	exportPathPrefix    string
+	exportStatusCode    int
+	isSplit             bool
+	isSplitLanguage     bool
+	splitInput          string
+	splitOutput         string
+	splitLevel          int
+	splitValidation     float64
+	splitTest           float64
+	splitSeed           int64
+	splitMaxPerClass    int
+	splitMinPerClass    int
+	isOffset            int
+	isLimit             int
+	claimSize           int
+	claimTTL            time.Duration
+	parallelJobs        int
+	cachePath           = "./data/cache"
+	cfg                 *config.Config
+	scheduler           *politeness.Scheduler
+	fetcher             *fetch.Fetcher
+	archive             *warc.Writer
+	schedule            recheck.Schedule
+	DB                  *gorm.DB
 )
 
 func main() {
 	pflag.IntVarP(&isOffset, "offset", "", 0, "offset x times the limit")
 	pflag.IntVarP(&isLimit, "limit", "", 500000, "limit the number of results returned.")
+	pflag.IntVarP(&claimSize, "claim-size", "", work.DefaultBatchSize, "number of websites a scan claims at once.")
+	pflag.DurationVarP(&claimTTL, "claim-ttl", "", work.DefaultTTL, "how long the websites claimed by a scan are reserved, before other scans can pick the unfinished ones.")
+	pflag.CommandLine.MarkDeprecated("offset", "scans claim their websites, parallel ones no longer need an offset")
 	pflag.IntVarP(&parallelJobs, "parallel-jobs", "j", 64, "parallel jobs.")
 	pflag.BoolVarP(&isDmozDump, "dmoz-dump", "", false, "dump dmoz dataset to csv file.")
+	pflag.StringVarP(&exportFile, "export-file", "", "export/dmoz_toplevel_lang26_conf_0.8.csv", "file the dmoz dataset is dumped to, .jsonl, .parquet and .gz extensions select the format.")
+	pflag.StringVarP(&exportFormat, "export-format", "", "", "format of the dmoz dump, tsv, jsonl or parquet, guessed from the file extension by default.")
+	pflag.BoolVarP(&isExportGzip, "export-gzip", "", false, "gzip the dmoz dump.")
+	pflag.StringSliceVarP(&exportLanguages, "export-languages", "", []string{"English", "German", "French", "Mandarin", "Italian", "Spanish", "Russian", "Japanese", "Turkish", "Polish", "Dutch", "Romanian", "Czech", "Swedish", "Portuguese", "Hungarian", "Ukrainian", "Danish", "Finnish", "Hebrew", "Greek", "Arabic", "Bulgarian", "Thai", "Lithuanian", "Croatian"}, "languages kept in the dmoz dump, empty for all.")
+	pflag.Float64VarP(&exportMinConfidence, "export-min-confidence", "", 0.8, "minimum language detection confidence of the dmoz dump.")
+	pflag.StringVarP(&exportPathPrefix, "export-path", "", "", "category path prefix of the dmoz dump, eg. Top/Arts.")
+	pflag.IntVarP(&exportStatusCode, "export-status-code", "", 0, "status code of the websites kept in the dmoz dump, 0 for any.")
+	pflag.BoolVarP(&isExportAlive, "export-alive", "", false, "only keep the websites found alive in the dmoz dump.")
+	pflag.BoolVarP(&isExportContent, "export-content", "", false, "add the extracted text and the tech stack of the websites to the jsonl dump, always on for parquet.")
+	pflag.BoolVarP(&isSplit, "split", "", false, "split the dmoz dataset into train, validation and test sets, stratified by category.")
+	pflag.StringVarP(&splitInput, "split-input", "", "", "export file to split, the dmoz dump filters are applied to the database otherwise.")
+	pflag.StringVarP(&splitOutput, "split-output", "", "export/splits", "directory the sets are written to, in the --export-format format.")
+	pflag.IntVarP(&splitLevel, "split-level", "", 1, "category level the sets are stratified by, 1 for the top-level categories, 0 for the full path.")
+	pflag.BoolVarP(&isSplitLanguage, "split-language", "", false, "also stratify the sets by language.")
+	pflag.Float64VarP(&splitValidation, "split-validation", "", 0.1, "share of every class in the validation set.")
+	pflag.Float64VarP(&splitTest, "split-test", "", 0.1, "share of every class in the test set.")
+	pflag.Int64VarP(&splitSeed, "split-seed", "", 1, "seed of the split, the same seed always gives the same sets.")
+	pflag.IntVarP(&splitMaxPerClass, "split-max-per-class", "", 0, "maximum number of listings per class, 0 for no limit.")
+	pflag.IntVarP(&splitMinPerClass, "split-min-per-class", "", 0, "drop the classes with fewer listings.")
+	pflag.BoolVarP(&isKidsDump, "kids-dump", "", false, "dump the kids and teens dmoz subset to csv file.")
 	pflag.BoolVarP(&isScanHome, "scan-home", "", false, "scan home page.")
 	pflag.BoolVarP(&isLangDetect, "lang-detect", "", false, "language detection")
 	pflag.BoolVarP(&isHostUpdate, "host-update", "", false, "update database with host and scheme")
 	pflag.BoolVarP(&isSitemap, "sitemap", "", false, "extract sitemaps from robots.txt files")
+	pflag.BoolVarP(&isScanRobots, "scan-robots", "", false, "summarize the robots.txt files of the websites, their groups, rules and non-standard directives.")
+	pflag.BoolVarP(&isRecheck, "recheck", "", false, "revisit the analyzed websites due for a check, re-extracting the changed ones.")
+	pflag.BoolVarP(&isFromCache, "from-cache", "", false, "extract again every website of --scan, --scan-home and --sitemap from its stored page, in the archive when configured or in --cache-dir, without network access.")
+	pflag.StringVarP(&cachePath, "cache-dir", "", cachePath, "colly cache directory the pages are read from by --from-cache, without an archive.")
+	pflag.IntVarP(&textMinScore, "min-score", "", textextract.MinScore, "minimum score of the blocks of text extracted from the home pages.")
 	pflag.BoolVarP(&isImportRDF, "rdf", "r", false, "import rdf file 'content.rdf.u8'.")
+	pflag.BoolVarP(&isStructure, "structure", "", false, "import rdf file 'structure.rdf.u8'.")
+	pflag.StringVarP(&rdfFile, "rdf-file", "", "./shared/dataset/content.rdf.u8", "rdf content dump to import, eg. kt-content.rdf.u8 for kids and teens.")
+	pflag.StringVarP(&rdfStructure, "structure-file", "", "./shared/dataset/structure.rdf.u8", "rdf structure dump to import.")
+	pflag.BoolVarP(&isResume, "resume", "", false, "resume the rdf import from its last checkpoint.")
+	pflag.IntVarP(&isBatchSize, "batch-size", "", 1000, "number of rdf records or csv rows written per transaction.")
 	pflag.BoolVarP(&isLoadDmoz, "load-dmoz", "z", false, "load data dmoz content into db.")
 	pflag.BoolVarP(&isLoadData, "load", "l", false, "load data into file.")
 	pflag.BoolVarP(&isTorProxy, "proxy", "x", false, "use tor proxy.")
@@ -83,28 +165,66 @@ func main() {
 	pflag.BoolVarP(&isImport, "import", "i", false, "import rdf file to database.")
 	pflag.BoolVarP(&isAdmin, "admin", "a", false, "launch web admin.")
 	pflag.BoolVarP(&isVerbose, "verbose", "v", false, "verbose mode.")
+	pflag.StringVarP(&configFile, "config", "", "config.json", "configuration file, overridden by the ND_* environment variables.")
 	pflag.BoolVarP(&isHelp, "help", "h", false, "help info.")
 	pflag.Parse()
 	if isHelp {
 		pflag.PrintDefaults()
 		os.Exit(1)
 	}
+	if isFromCache && isRecheck {
+		log.Fatal("--recheck checks the live websites, it cannot run --from-cache")
+	}
+	textextract.MinScore = textMinScore
 
-	var err error
 	if !isDump {
-		DB, err = gorm.Open("mysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?charset=utf8mb4&collation=utf8mb4_unicode_ci&parseTime=True&loc=Local", "root", "megaweb", "localhost", "3308", "dataset_dmoz"))
+		var err error
+		cfg, err = config.Load(configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		// every crawler goes through the same per host limits
+		scheduler = politeness.New(cfg.Politeness)
+		if !isFromCache {
+			// the pages fetched are archived when an archive is configured
+			archive = warc.New(cfg.Archive)
+			if archive != nil {
+				defer archive.Close()
+			}
+		}
+		fetcher = fetch.New(cfg.Fetch, scheduler.Transport(nil), archive)
+		if isFromCache {
+			// the pages are read back instead of fetched. Nothing writes a
+			// colly cache of the crawled pages, only the archive stores them.
+			switch {
+			case cfg.Archive.Dir != "":
+				pages, err := warc.Open(cfg.Archive.Dir)
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer pages.Close()
+				fetcher.Store = pages
+			case pflag.CommandLine.Changed("cache-dir"):
+				fetcher.Store = fetch.CacheDir(cachePath)
+			default:
+				log.Fatal("--from-cache reads the pages of the archive: set the dir of the archive section, or ND_ARCHIVE_DIR, or --cache-dir to read a colly cache")
+			}
+		}
+		schedule = recheck.New(cfg.Recheck)
+		DB, err = cfg.Database.Open()
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		DB.Set("gorm:table_options", "ENGINE=InnoDB CHARSET=utf8mb4")
-		DB.AutoMigrate(&AlexaWebsite{})
-		DB.AutoMigrate(&Website{})
-		DB.AutoMigrate(&Category{})
-		DB.AutoMigrate(&Rss{})
-		DB.AutoMigrate(&Rank{})
-		DB.AutoMigrate(&Sitemap{})
-		DB.AutoMigrate(&Dmoz{})
+		if err := models.Migrate(DB); err != nil {
+			log.Fatal(err)
+		}
+		// the robots.txt files are shared by the runs, and by the crawlers
+		// through the scheduler
+		scheduler.Robots.Store = models.RobotsStore{DB: DB}
+		scheduler.Robots.Offline = isFromCache
+		// websites imported before sources were tracked all come from dmoz
+		DB.Model(&models.Website{}).Where("source IS NULL OR source = ''").Update("source", models.SourceDmoz)
 		validations.RegisterCallbacks(DB)
 	}
 
@@ -126,14 +246,14 @@ func main() {
 		})
 
 		// Allow to use Admin to manage User, Product
-		website := Admin.AddResource(&Website{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -1})
-		website.IndexAttrs("ID", "Link", "Path", "Domain", "Tld")
+		website := Admin.AddResource(&models.Website{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -1})
+		website.IndexAttrs("ID", "Link", "Source", "Path", "Domain", "Tld")
 
-		category := Admin.AddResource(&Category{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -3})
+		category := Admin.AddResource(&models.Category{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -3})
 		category.Meta(&admin.Meta{Name: "Categories", Type: "select_many"})
 
-		alexaWebsite := Admin.AddResource(&AlexaWebsite{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -1})
-		alexaWebsite.IndexAttrs("ID", "Link", "Path", "Domain", "Tld")
+		categoryLink := Admin.AddResource(&models.CategoryLink{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -2})
+		categoryLink.IndexAttrs("ID", "CategoryID", "Kind", "Label", "Target", "TargetID")
 
 		// initalize an HTTP request multiplexer
 		mux := http.NewServeMux()
@@ -164,7 +284,10 @@ func main() {
 
 	// import data
 	if isImportRDF {
-		importRdf("./shared/dataset/content.rdf.u8", DB)
+		importRdf(rdfFile, DB)
+	}
+	if isStructure {
+		importStructure(rdfStructure, DB)
 	}
 	if isLoadDmoz {
 		loadDmoz("../gdrive/dmoz/dmoz_toplevel_lang.csv", DB)
@@ -180,6 +303,14 @@ func main() {
 		scanSitemap(DB)
 	}
 
+	if isScanRobots {
+		scanRobots(DB)
+	}
+
+	if isRecheck {
+		recheckWebsites(DB)
+	}
+
 	if isHostUpdate {
 		scanHost(DB)
 	}
@@ -193,49 +324,210 @@ func main() {
 	}
 
 	if isDmozDump {
-		dmozDump("dmoz_toplevel_lang26_conf_0.8.csv", DB)
+		dmozDump(exportFile, DB)
+	}
+
+	if isSplit {
+		splitDataset(DB)
+	}
+
+	if isKidsDump {
+		kidsDump("dmoz_kids_and_teens.csv", DB)
 	}
 
 }
 
+// dmozDump streams the dmoz entries matching the export filters into a
+// local file
 func dmozDump(outputFile string, DB *gorm.DB) {
-	query := `SELECT link,title,description,path,language,lang_script,lang_iso6391,lang_iso6393,lang_confidence,path_full,path_parent 
-				INTO OUTFILE 'export/` + outputFile + `' FIELDS TERMINATED BY '\t' OPTIONALLY ENCLOSED BY '"' LINES TERMINATED BY '\n' 
-  				FROM dmozs
-  				WHERE lang_confidence>=0.8 AND language in ("English","German","French","Mandarin","Italian","Spanish","Russian","Japanese","Turkish","Polish","Dutch","Romanian","Czech","Swedish","Portuguese","Hungarian","Ukrainian","Danish","Finnish","Hebrew","Greek","Arabic","Bulgarian","Thai","Lithuanian","Croatian")`
-	fmt.Println(query)
-	err := DB.Exec(query).Error
+	starttime := time.Now()
+
+	if err := os.MkdirAll(filepath.Dir(outputFile), 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	format, compress := export.FormatOf(outputFile)
+	if exportFormat != "" {
+		format = exportFormat
+	}
+	w, err := export.Create(outputFile, format, compress || isExportGzip)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	filter := export.Filter{
+		Languages:     exportLanguages,
+		MinConfidence: exportMinConfidence,
+		PathPrefix:    exportPathPrefix,
+		StatusCode:    exportStatusCode,
+		Alive:         isExportAlive,
+		Content:       isExportContent || format == export.Parquet,
+	}
+	count, err := export.Export(DB, filter, w)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Finish exporting", count, "dmoz entries to", outputFile, "time period:", time.Now().Sub(starttime))
 }
 
-func loadDmoz(csvFile string, DB *gorm.DB) {
-	fmt.Println("loading data from file...")
-	mysql.RegisterLocalFile(csvFile)
+// splitDataset writes the train, validation and test sets of the dmoz
+// entries, read from the --split-input export file or from the database
+func splitDataset(DB *gorm.DB) {
+	starttime := time.Now()
+
+	format, compress := export.FormatOf(splitInput)
+	if splitInput == "" {
+		format, compress = exportFormat, isExportGzip
+		if format == "" {
+			format = export.TSV
+		}
+	}
+
+	var rows split.Rows
+	if splitInput != "" {
+		err := export.ReadFile(splitInput, format, compress, func(r *export.Row) error {
+			return rows.Write(r)
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		filter := export.Filter{
+			Languages:     exportLanguages,
+			MinConfidence: exportMinConfidence,
+			PathPrefix:    exportPathPrefix,
+			StatusCode:    exportStatusCode,
+			Alive:         isExportAlive,
+			Content:       isExportContent || format == export.Parquet,
+		}
+		if _, err := export.Export(DB, filter, &rows); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	result, err := split.Split(rows, split.Options{
+		Level:       splitLevel,
+		Language:    isSplitLanguage,
+		Validation:  splitValidation,
+		Test:        splitTest,
+		Seed:        splitSeed,
+		MaxPerClass: splitMaxPerClass,
+		MinPerClass: splitMinPerClass,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Split", len(rows), "dmoz entries into", result.Classes, "classes, dropped:", result.Dropped, "capped entries:", result.Capped)
+
+	if exportFormat != "" {
+		format = exportFormat
+	}
+	compress = (compress || isExportGzip) && format != export.Parquet
+	if err := os.MkdirAll(splitOutput, 0755); err != nil {
+		log.Fatal(err)
+	}
+	for set, name := range split.Names {
+		outputFile := filepath.Join(splitOutput, name+"."+format)
+		if compress {
+			outputFile += ".gz"
+		}
+		w, err := export.Create(outputFile, format, compress)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for i := range result.Sets[set] {
+			if err := w.Write(&result.Sets[set][i]); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Wrote", len(result.Sets[set]), "dmoz entries to", outputFile)
+	}
+	log.Println("Finish splitting the dmoz dataset, time period:", time.Now().Sub(starttime))
+}
+
+// kidsDump writes the entries imported from the Kids & Teens dumps, labelled
+// with their age groups
+func kidsDump(outputFile string, DB *gorm.DB) {
+	csvKids, err := ccsv.NewCsvWriter(outputFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer csvKids.Close()
+
+	columns := []string{"link", "title", "description", "path", "path_full", "path_parent", "ages", "language", "lang_confidence"}
+	csvKids.Write(columns)
 
-	query := `LOAD DATA LOCAL INFILE '` + csvFile + `' INTO TABLE dmozs CHARACTER SET 'utf8mb4' FIELDS TERMINATED BY '\t' ENCLOSED BY '"' LINES TERMINATED BY '\n' (link,title,description,path,language,lang_script,lang_iso6391,lang_iso6393,lang_confidence,path_full,path_parent) SET created_at = NOW(), updated_at = NOW();`
-	fmt.Println(query)
-	err := DB.Exec(query).Error
+	rows, err := DB.Table(DB.NewScope(&models.Dmoz{}).TableName()).Select(columns).Where("dump LIKE ?", "kt-%").Rows()
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer rows.Close()
+
+	counter := 0
+	for rows.Next() {
+		var d models.Dmoz
+		if err := rows.Scan(&d.Link, &d.Title, &d.Description, &d.Path, &d.PathFull, &d.PathParent, &d.Ages, &d.Language, &d.LangConfidence); err != nil {
+			log.Fatal(err)
+		}
+		csvKids.Write([]string{d.Link, d.Title, d.Description, d.Path, d.PathFull, d.PathParent, d.Ages, d.Language, strconv.FormatFloat(d.LangConfidence, 'f', -1, 64)})
+		counter++
+	}
+	if err := rows.Err(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("kids and teens entries:", counter)
+}
+
+func loadDmoz(csvFile string, DB *gorm.DB) {
+	now := time.Now()
+	loadFile(csvFile, DB, &bulk.Loader{
+		Table:     DB.NewScope(&models.Dmoz{}).TableName(),
+		Columns:   []string{"link", "title", "description", "path", "language", "lang_script", "lang_iso6391", "lang_iso6393", "lang_confidence", "path_full", "path_parent"},
+		Key:       "link",
+		Values:    map[string]interface{}{"created_at": now, "updated_at": now},
+		BatchSize: isBatchSize,
+	})
 }
 
-// LOAD DATA INFILE '/root/dmoz_dataset.csv' INTO TABLE websites FIELDS TERMINATED BY '\t' ENCLOSED BY '"' LINES TERMINATED BY '\r\n' IGNORE 1 LINES (link,path);
 func loadData(csvFile string, DB *gorm.DB) {
-	fmt.Println("loading data from file...")
-	mysql.RegisterLocalFile(csvFile)
-	query := `LOAD DATA LOCAL INFILE '` + csvFile + `' INTO TABLE websites CHARACTER SET 'utf8mb4' FIELDS TERMINATED BY '\t' ENCLOSED BY '"' LINES TERMINATED BY '\n' IGNORE 1 LINES (link,path) SET created_at = NOW(), updated_at = NOW();`
-	fmt.Println(query)
-	err := DB.Exec(query).Error
+	now := time.Now()
+	loadFile(csvFile, DB, &bulk.Loader{
+		Table:      DB.NewScope(&models.Website{}).TableName(),
+		Columns:    []string{"link", "path"},
+		Key:        "link",
+		Values:     map[string]interface{}{"source": models.SourceDmoz, "created_at": now, "updated_at": now},
+		SkipHeader: true,
+		BatchSize:  isBatchSize,
+	})
+}
+
+// loadFile streams a tab separated file into the database, rows already
+// present are left untouched
+func loadFile(csvFile string, DB *gorm.DB, loader *bulk.Loader) {
+	log.Println("loading data from file", csvFile, "into", loader.Table)
+	starttime := time.Now()
+
+	file, err := os.Open(csvFile)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer file.Close()
+
+	stats, err := loader.Load(DB, file)
+	if err != nil {
+		log.Fatalf("%v, after %s", err, stats)
+	}
+	log.Println("Finish loading", csvFile, stats, "time period:", time.Now().Sub(starttime))
 }
 
 func scanLang(DB *gorm.DB) {
-	offset := isOffset * isLimit
+	selector := newSelector("scan-lang", "language IS NULL AND status_code=200 AND title!=''")
 
 	type result struct {
 		Link        string
@@ -244,261 +536,528 @@ func scanLang(DB *gorm.DB) {
 		Description string
 		ArticleText string
 	}
-	var results []result
-	query := fmt.Sprintf("select link, path, title, description, article_text FROM websites WHERE language IS NULL AND status_code=200 AND title!='' ORDER BY RAND() LIMIT %d,%d", offset, isLimit)
-	fmt.Println("query:", query)
-
-	t := throttler.New(12, 100000000)
-
-	DB.Raw(query).Scan(&results)
-	for _, r := range results {
-		go func(entry result) error {
-			defer t.Done(nil)
-			fmt.Println("entry.Link:", entry.Link)
-			website := &Website{}
-			if !DB.Where("link = ? AND language IS NULL", entry.Link).First(&website).RecordNotFound() {
-				info := whatlanggo.Detect(entry.Path + " " + entry.ArticleText + " " + entry.Title + " " + entry.Description)
-				fmt.Println("Language:", info.Lang.String(), " Script:", whatlanggo.Scripts[info.Script], " Confidence: ", info.Confidence)
-				website.Language = info.Lang.String()
-				website.LangConfidence = info.Confidence
-				// save website info
-				if err := DB.Save(website).Error; err != nil {
-					return err
+
+	for {
+		var results []result
+		more, err := nextWebsites(selector, "link, path, title, description, article_text", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
+
+		// wait for the whole batch before claiming the next one
+		t := throttler.New(12, len(results))
+		for _, r := range results {
+			go func(entry result) error {
+				defer t.Done(nil)
+				fmt.Println("entry.Link:", entry.Link)
+				website := &models.Website{}
+				if !DB.Where("link = ? AND language IS NULL", entry.Link).First(&website).RecordNotFound() {
+					info := whatlanggo.Detect(entry.Path + " " + entry.ArticleText + " " + entry.Title + " " + entry.Description)
+					fmt.Println("Language:", info.Lang.String(), " Script:", whatlanggo.Scripts[info.Script], " Confidence: ", info.Confidence)
+					website.Language = info.Lang.String()
+					website.LangConfidence = info.Confidence
+					// save website info
+					if err := DB.Save(website).Error; err != nil {
+						return err
+					}
 				}
-			}
-			return nil
-		}(r)
-		t.Throttle()
-	}
+				return nil
+			}(r)
+			t.Throttle()
+		}
 
-	// throttler errors iteration
-	if t.Err() != nil {
-		// Loop through the errors to see the details
-		for i, err := range t.Errs() {
-			log.Printf("error #%d: %s", i, err)
+		// throttler errors iteration
+		if t.Err() != nil {
+			// Loop through the errors to see the details
+			for i, err := range t.Errs() {
+				log.Printf("error #%d: %s", i, err)
+			}
+			log.Fatal(t.Err())
 		}
-		log.Fatal(t.Err())
 	}
 }
 
 func scanHome(DB *gorm.DB) {
-	offset := isOffset * isLimit
+	selector := newScanSelector("scan-home", "text_extract IS NULL")
 
 	type result struct {
 		Link string
 	}
-	var results []result
-	query := fmt.Sprintf("select link FROM websites WHERE text_extract IS NULL ORDER BY RAND() LIMIT %d,%d", offset, isLimit)
-	fmt.Println("query:", query)
-
-	t := throttler.New(32, 100000000)
-
-	DB.Raw(query).Scan(&results)
-	for _, r := range results {
-		go func(entry result) error {
-			defer t.Done(nil)
-			fmt.Println("entry.Link:", entry.Link)
-			website := &Website{}
-			if !DB.Where("link = ? AND text_extract IS NULL", entry.Link).First(&website).RecordNotFound() {
-				textextract.MinScore = 5 // the default is 5.
-				content, err := downloadContent(entry.Link)
-				if err != nil {
-					return err
-				}
-				extractedText, err := textextract.ExtractFromHtml(content)
-				if err != nil {
-					return err
+
+	for {
+		var results []result
+		more, err := nextWebsites(selector, "link", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
+
+		// wait for the whole batch before claiming the next one
+		t := throttler.New(parallelJobs, len(results))
+		for _, r := range results {
+			go func(entry result) error {
+				defer t.Done(nil)
+				fmt.Println("entry.Link:", entry.Link)
+				website := &models.Website{}
+				query := DB.Where("link = ?", entry.Link)
+				if !isFromCache {
+					query = query.Where("text_extract IS NULL")
 				}
-				if extractedText != "" {
-					website.TextExtract = extractedText
-					// save website
-					if err := DB.Save(website).Error; err != nil {
+				if !query.First(&website).RecordNotFound() {
+					page, err := downloadContent(entry.Link)
+					if errors.Is(err, fetch.ErrNotStored) {
+						return nil
+					}
+					if err != nil {
 						return err
 					}
+					extractedText, err := textextract.ExtractFromHtml(page.Text())
+					if err != nil {
+						return err
+					}
+					// extracting again keeps the result of the new settings
+					if extractedText != "" || isFromCache {
+						website.TextExtract = extractedText
+						// save website
+						if err := DB.Save(website).Error; err != nil {
+							return err
+						}
+					}
 				}
-			}
-			return nil
-		}(r)
-		t.Throttle()
-	}
+				return nil
+			}(r)
+			t.Throttle()
+		}
 
-	// throttler errors iteration
-	if t.Err() != nil {
-		// Loop through the errors to see the details
-		for i, err := range t.Errs() {
-			log.Printf("error #%d: %s", i, err)
+		// throttler errors iteration
+		if t.Err() != nil {
+			// Loop through the errors to see the details
+			for i, err := range t.Errs() {
+				log.Printf("error #%d: %s", i, err)
+			}
+			log.Fatal(t.Err())
 		}
-		log.Fatal(t.Err())
 	}
 
 }
 
 func scanHost(DB *gorm.DB) {
-	offset := isOffset * isLimit
+	selector := newSelector("scan-host", "tld IS NULL")
 
 	type result struct {
 		Link string
 	}
-	var results []result
-	query := fmt.Sprintf("select link FROM websites WHERE tld IS NULL ORDER BY RAND() LIMIT %d,%d", offset, isLimit)
-	fmt.Println("query:", query)
-
-	t := throttler.New(12, 100000000)
-
 	// cache := "/tmp/tld.cache"
 	// extract, _ := tldextract.New(cache, false)
 
-	DB.Raw(query).Scan(&results)
-	for _, r := range results {
-		go func(entry result) error {
-			defer t.Done(nil)
-			fmt.Println("entry.Link:", entry.Link)
-			website := &Website{}
-			if !DB.Where("link = ? AND tld IS NULL", entry.Link).First(&website).RecordNotFound() {
-				u, err := url.Parse(entry.Link)
-				if err != nil {
-					return err
-				}
-				website.Host = u.Host
-				website.Scheme = u.Scheme
-				// result:=extract.Extract(u)
-
-				/*
-					eTLD, icann := publicsuffix.PublicSuffix(entry.Link)
-
-					// Only ICANN managed domains can have a single label. Privately
-					// managed domains must have multiple labels.
-					manager := "Unmanaged"
-					if icann {
-						manager = "ICANN Managed"
-					} else if strings.IndexByte(eTLD, '.') >= 0 {
-						manager = "Privately Managed"
+	for {
+		var results []result
+		more, err := nextWebsites(selector, "link", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
+
+		// wait for the whole batch before claiming the next one
+		t := throttler.New(12, len(results))
+		for _, r := range results {
+			go func(entry result) error {
+				defer t.Done(nil)
+				fmt.Println("entry.Link:", entry.Link)
+				website := &models.Website{}
+				if !DB.Where("link = ? AND tld IS NULL", entry.Link).First(&website).RecordNotFound() {
+					u, err := url.Parse(entry.Link)
+					if err != nil {
+						return err
 					}
+					website.Host = u.Host
+					website.Scheme = u.Scheme
+					// result:=extract.Extract(u)
+
+					/*
+						eTLD, icann := publicsuffix.PublicSuffix(entry.Link)
+
+						// Only ICANN managed domains can have a single label. Privately
+						// managed domains must have multiple labels.
+						manager := "Unmanaged"
+						if icann {
+							manager = "ICANN Managed"
+						} else if strings.IndexByte(eTLD, '.') >= 0 {
+							manager = "Privately Managed"
+						}
 
-					fmt.Printf("> %24s%16s  is  %s\n", entry.Link, eTLD, manager)
-				*/
+						fmt.Printf("> %24s%16s  is  %s\n", entry.Link, eTLD, manager)
+					*/
 
-				_, dm, tld := tldparser.ParseDomain(entry.Link)
-				// t := extract.Extract(entry.Link)
-				website.Domain = dm //t.Root
-				website.Tld = tld   //t.Tld
-				// save website info
-				if err := DB.Save(website).Error; err != nil {
-					return err
+					_, dm, tld := tldparser.ParseDomain(entry.Link)
+					// t := extract.Extract(entry.Link)
+					website.Domain = dm //t.Root
+					website.Tld = tld   //t.Tld
+					// save website info
+					if err := DB.Save(website).Error; err != nil {
+						return err
+					}
 				}
-			}
-			return nil
-		}(r)
-		t.Throttle()
-	}
+				return nil
+			}(r)
+			t.Throttle()
+		}
 
-	// throttler errors iteration
-	if t.Err() != nil {
-		// Loop through the errors to see the details
-		for i, err := range t.Errs() {
-			log.Printf("error #%d: %s", i, err)
+		// throttler errors iteration
+		if t.Err() != nil {
+			// Loop through the errors to see the details
+			for i, err := range t.Errs() {
+				log.Printf("error #%d: %s", i, err)
+			}
+			log.Fatal(t.Err())
 		}
-		log.Fatal(t.Err())
 	}
 
 }
 
 func scanSitemap(DB *gorm.DB) {
-	offset := isOffset * isLimit
+	selector := newScanSelector("scan-sitemap", "article_text IS NULL")
 
 	type result struct {
 		Link string
 	}
-	var results []result
-	query := fmt.Sprintf("select link FROM websites WHERE article_text IS NULL ORDER BY RAND() LIMIT %d,%d", offset, isLimit)
-	fmt.Println("query:", query)
-
-	t := throttler.New(36, 100000000)
-
-	DB.Raw(query).Scan(&results)
-	for _, r := range results {
-		go func(entry result) error {
-			defer t.Done(nil)
-			fmt.Println("entry.Link:", entry.Link)
-			if strings.HasPrefix(entry.Link, "http") {
-				website := &Website{}
-				if !DB.Where("link = ? AND article_text IS NULL", entry.Link).First(&website).RecordNotFound() {
-					// get summary
-					text, err := articletext.GetArticleTextFromUrl(entry.Link)
-					if err != nil {
-						return err
-					}
-					if text != "" {
-						website.ArticleText = text
+
+	for {
+		var results []result
+		more, err := nextWebsites(selector, "link", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
+
+		// wait for the whole batch before claiming the next one
+		t := throttler.New(parallelJobs, len(results))
+		for _, r := range results {
+			go func(entry result) error {
+				defer t.Done(nil)
+				fmt.Println("entry.Link:", entry.Link)
+				if strings.HasPrefix(entry.Link, "http") {
+					website := &models.Website{}
+					query := DB.Where("link = ?", entry.Link)
+					if !isFromCache {
+						query = query.Where("article_text IS NULL")
 					}
-					// check robots.txt
-					robotsTxtLink := fmt.Sprintf("%s/robots.txt", entry.Link)
-					robotsTxtLink = strings.Replace(robotsTxtLink, "//robots.txt", "/robots.txt", -1)
-					// download content
-					content, err := downloadContent(robotsTxtLink)
-					if err == nil {
-						if content != "" {
-							// parse robots.txt
-							robots, err := robotstxt.Parse(content, robotsTxtLink)
+					if !query.First(&website).RecordNotFound() {
+						// get summary
+						page, err := downloadContent(entry.Link)
+						if errors.Is(err, fetch.ErrNotStored) {
+							return nil
+						}
+						if err != nil {
+							return err
+						}
+						text, err := articletext.GetArticleTextFromResponse(page)
+						if err != nil {
+							return err
+						}
+						if text != "" || isFromCache {
+							website.ArticleText = text
+						}
+						if isFromCache {
+							return DB.Save(website).Error
+						}
+						// the robots.txt of the origin, only a file found lists sitemaps
+						robotsTxt, err := scheduler.Robots.File(context.Background(), entry.Link)
+						if err == nil && robotsTxt.StatusCode/100 == 2 && robotsTxt.Body != "" && !strings.Contains(robotsTxt.Body, "<html") {
+							robots, err := scheduler.Robots.Robots(context.Background(), entry.Link)
 							if err == nil {
-								if !strings.Contains(content, "<html") {
-									website.RobotsTxt = content
-									// strings.Join(robots.Sitemaps(), ",")
-									for _, sitemap := range robots.Sitemaps() {
-										s := Sitemap{Href: sitemap}
-										if strings.HasSuffix(sitemap, ".gz") {
-											s.Gziped = true
-										}
-										if strings.Contains(sitemap, "index") {
-											s.Index = true
-										}
-										website.Sitemaps = append(website.Sitemaps, s)
+								website.RobotsTxt = robotsTxt.Body
+								for _, sitemap := range robots.Sitemaps() {
+									s := models.Sitemap{Href: sitemap}
+									if strings.HasSuffix(sitemap, ".gz") {
+										s.Gziped = true
 									}
+									if strings.Contains(sitemap, "index") {
+										s.Index = true
+									}
+									website.Sitemaps = append(website.Sitemaps, s)
 								}
 							}
 						}
-					}
-					// save website
-					if err := DB.Save(website).Error; err != nil {
-						return err
+						// save website
+						if err := DB.Save(website).Error; err != nil {
+							return err
+						}
 					}
 				}
+				return nil
+			}(r)
+			t.Throttle()
+		}
+
+		// throttler errors iteration
+		if t.Err() != nil {
+			// Loop through the errors to see the details
+			for i, err := range t.Errs() {
+				log.Printf("error #%d: %s", i, err)
 			}
-			return nil
-		}(r)
-		t.Throttle()
+			log.Fatal(t.Err())
+		}
 	}
 
-	// throttler errors iteration
-	if t.Err() != nil {
-		// Loop through the errors to see the details
-		for i, err := range t.Errs() {
-			log.Printf("error #%d: %s", i, err)
+}
+
+// scanRobots fetches the robots.txt files of the websites not summarized
+// yet, and saves their summaries
+func scanRobots(DB *gorm.DB) {
+	selector := newScanSelector("scan-robots", fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s r WHERE r.website_id = t.id)", DB.NewScope(&models.RobotsSummary{}).TableName()))
+
+	type result struct {
+		ID   uint
+		Link string
+	}
+
+	for {
+		var results []result
+		more, err := nextWebsites(selector, "id, link", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
+
+		// wait for the whole batch before claiming the next one
+		t := throttler.New(parallelJobs, len(results))
+		for _, r := range results {
+			go func(entry result) error {
+				defer t.Done(nil)
+				robotsTxtLink, err := robotstxt.URL(entry.Link)
+				if err != nil {
+					return nil
+				}
+				// a robots.txt out of reach disallows everything
+				file, err := scheduler.Robots.File(context.Background(), entry.Link)
+				if errors.Is(err, robotstxt.ErrNotStored) {
+					return nil
+				}
+				if err != nil {
+					file = &robotstxt.File{}
+				}
+				robots, err := robotstxt.ParseResponse(file.StatusCode, file.Body, robotsTxtLink)
+				if err != nil {
+					return err
+				}
+				if isVerbose {
+					fmt.Println("robots.txt:", robotsTxtLink, file.StatusCode)
+				}
+				summary, groups := summarizeRobots(robots, robotsTxtLink)
+				summary.StatusCode = file.StatusCode
+				summary.Size = len(file.Body)
+				return models.SaveRobots(DB, entry.ID, summary, groups)
+			}(r)
+			t.Throttle()
+		}
+
+		// throttler errors iteration
+		if t.Err() != nil {
+			// Loop through the errors to see the details
+			for i, err := range t.Errs() {
+				log.Printf("error #%d: %s", i, err)
+			}
+			log.Fatal(t.Err())
 		}
-		log.Fatal(t.Err())
 	}
+}
 
+// summarizeRobots returns the summary and the groups of the robots.txt file
+// at robotsTxtLink, whether they disallow the home page telling the sites
+// blocking an agent
+func summarizeRobots(robots *robotstxt.RobotsTxt, robotsTxtLink string) (*models.RobotsSummary, []models.RobotsGroup) {
+	home := strings.TrimSuffix(robotsTxtLink, "robots.txt")
+	summary := &models.RobotsSummary{
+		URL:         robotsTxtLink,
+		Unreachable: robots.Unreachable(),
+		Sitemaps:    len(robots.Sitemaps()),
+		Host:        robots.Host(),
+	}
+	if match, err := robots.Evaluate("*", home); err == nil {
+		summary.DisallowAll = !match.Allowed
+	}
+
+	var groups []models.RobotsGroup
+	for _, g := range robots.Groups() {
+		group := models.RobotsGroup{UserAgent: g.UserAgent, CrawlDelay: g.CrawlDelay.Seconds()}
+		for _, rule := range g.Rules {
+			if rule.Directive == "allow" {
+				group.Allows++
+			} else {
+				group.Disallows++
+			}
+		}
+		if match, err := robots.Evaluate(g.UserAgent, home); err == nil {
+			group.DisallowAll = !match.Allowed
+		}
+		summary.Rules += len(g.Rules)
+		groups = append(groups, group)
+	}
+	summary.Groups = len(groups)
+
+	var names []string
+	seen := make(map[string]bool)
+	for _, directive := range robots.Directives() {
+		if !seen[directive.Name] {
+			seen[directive.Name] = true
+			names = append(names, directive.Name)
+		}
+	}
+	summary.Directives = strings.Join(names, ",")
+	return summary, groups
 }
 
-func downloadContent(rawUrl string) (string, error) {
-	// Get the data
-	var client = &http.Client{
-		Timeout: time.Second * 10,
+// markChecked records a visit of a website at now, and schedules the next
+// one after a share of the time since its page last changed
+func markChecked(website *models.Website, now time.Time, changed bool) {
+	website.LastCheckedAt = &now
+	if changed || website.LastChangedAt == nil {
+		website.LastChangedAt = &now
+	}
+	next := schedule.Next(now, *website.LastChangedAt)
+	website.NextCheckAt = &next
+}
+
+// setFinalURL records where the link of a website leads, and whether it
+// moved to another registered domain
+func setFinalURL(website *models.Website, finalURL string) {
+	website.FinalURL = finalURL
+	website.DomainChanged = fetch.DomainChanged(website.Link, finalURL)
+}
+
+// redirectsOf returns the hops of a fetch as they are saved
+func redirectsOf(hops []fetch.Hop) []models.Redirect {
+	redirects := make([]models.Redirect, len(hops))
+	for i, hop := range hops {
+		redirects[i] = models.Redirect{Kind: hop.Kind, StatusCode: hop.StatusCode, URL: hop.URL, Location: hop.Location}
+	}
+	return redirects
+}
+
+// recheckWebsites revisits the analyzed websites due for a check. Their
+// validators are sent along, so that unchanged pages cost a 304 and are not
+// extracted again.
+func recheckWebsites(DB *gorm.DB) {
+	selector := newSelector("recheck", "analyzed = 1 AND (next_check_at IS NULL OR next_check_at <= ?)", time.Now().UTC())
+
+	type result struct {
+		ID uint
+	}
+
+	for {
+		var results []result
+		more, err := nextWebsites(selector, "id", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
+
+		// wait for the whole batch before claiming the next one
+		t := throttler.New(parallelJobs, len(results))
+		for _, r := range results {
+			go func(entry result) {
+				err := recheckWebsite(DB, entry.ID)
+				if err != nil {
+					log.Warnln("recheck:", entry.ID, err)
+				}
+				t.Done(err)
+			}(r)
+			t.Throttle()
+		}
+	}
+}
+
+// recheckWebsite revisits a website, updating its liveness and, when its
+// page changed, the text extracted from it
+func recheckWebsite(DB *gorm.DB, id uint) error {
+	website := &models.Website{}
+	if DB.First(website, id).RecordNotFound() {
+		return nil
+	}
+	now := time.Now().UTC()
+	page, err := fetcher.Revalidate(context.Background(), website.Link, fetch.Validators{ETag: website.ETag, LastModified: website.LastModified})
+	if errors.Is(err, politeness.ErrDisallowed) || errors.Is(err, robotstxt.ErrUnreachable) {
+		// left due, robots.txt refusing the page tells nothing of the website
+		return nil
 	}
-	resp, err := client.Get(rawUrl)
 	if err != nil {
-		return "", err
+		website.Alive = false
+		website.StatusCode = 0
+		markChecked(website, now, false)
+		return DB.Save(website).Error
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode == 404 || resp.StatusCode == 403 || resp.StatusCode == 401 {
-		return "", fmt.Errorf("not exists")
+
+	website.Alive = page.OK() || page.NotModified()
+	website.StatusCode = page.StatusCode
+	setFinalURL(website, page.FinalURL)
+	changed := false
+	if page.OK() {
+		validators := page.Validators()
+		website.ETag = validators.ETag
+		website.LastModified = validators.LastModified
+		fingerprint := recheck.Fingerprint(page.Body)
+		if fingerprint != website.ContentHash {
+			if isVerbose {
+				fmt.Println("changed:", website.Link)
+			}
+			if text, err := textextract.ExtractFromHtml(page.Text()); err == nil && text != "" {
+				website.TextExtract = text
+			}
+			if text, err := articletext.GetArticleTextFromResponse(page); err == nil && text != "" {
+				website.ArticleText = text
+			}
+			// without a previous fingerprint, there is nothing to compare to
+			changed = website.ContentHash != ""
+			website.ContentHash = fingerprint
+		}
+	}
+	markChecked(website, now, changed)
+
+	if err := DB.Save(website).Error; err != nil {
+		return err
 	}
-	// read all
-	body, err := ioutil.ReadAll(resp.Body)
+	return models.SaveRedirects(DB, website.ID, redirectsOf(page.Redirects))
+}
+
+func downloadContent(rawUrl string) (*fetch.Response, error) {
+	// Get the data
+	resp, err := fetcher.Get(context.Background(), rawUrl)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return string(body), nil
+	if resp.StatusCode == 404 || resp.StatusCode == 403 || resp.StatusCode == 401 {
+		return nil, fmt.Errorf("not exists")
+	}
+	return resp, nil
+}
+
+// importBatch buffers the records decoded from the content dump until they
+// are written to the database in one transaction
+type importBatch struct {
+	dump    string
+	topics  []*rdf.Topic
+	pages   map[string]*rdf.ExternalPage
+	records int
+}
+
+func (b *importBatch) reset() {
+	b.topics = nil
+	b.pages = make(map[string]*rdf.ExternalPage)
+	b.records = 0
 }
 
 func importRdf(rdfFile string, DB *gorm.DB) {
@@ -516,172 +1075,503 @@ func importRdf(rdfFile string, DB *gorm.DB) {
 	csvDmoz.Flush()
 
 	starttime := time.Now()
-	dat, err := ioutil.ReadFile(rdfFile)
+
+	checkpoint := &models.ImportCheckpoint{}
+	if !isDump {
+		if DB.Where("file = ?", rdfFile).First(checkpoint).RecordNotFound() {
+			checkpoint.File = rdfFile
+		} else if !isResume || checkpoint.Done {
+			checkpoint.Offset, checkpoint.Records, checkpoint.Done = 0, 0, false
+		} else {
+			log.Println("resuming import at offset:", checkpoint.Offset, "records:", checkpoint.Records)
+		}
+	}
+
+	d, file, err := rdf.OpenDecoder(rdfFile, checkpoint.Offset)
 	if err != nil {
 		panic(err)
 	}
+	defer file.Close()
 
-	doc := etree.NewDocument()
-	err = doc.ReadFromBytes(dat)
+	batch := &importBatch{dump: rdf.DumpName(rdfFile)}
+	batch.reset()
 
-	if err != nil {
-		log.Println(err, string(dat))
+	for {
+		record, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		batch.records++
+
+		switch entry := record.(type) {
+		case *rdf.Topic:
+			//catid
+			if entry.CatID == "" {
+				continue
+			}
+			if entry.ID == "" {
+				entry.ID = "Root"
+			}
+			if isDump {
+				//link1 and link
+				for _, url := range entry.URLs() {
+					csvDmoz.Write([]string{url, entry.ID})
+				}
+				csvDmoz.Flush()
+				continue
+			}
+			batch.topics = append(batch.topics, entry)
+		case *rdf.ExternalPage:
+			if !isDump {
+				batch.pages[entry.About] = entry
+			}
+		}
+
+		if !isDump && batch.records >= isBatchSize {
+			checkpoint.Offset = d.Offset()
+			if err := flushImportBatch(DB, batch, checkpoint); err != nil {
+				log.Fatal(err)
+			}
+			log.Println("imported records:", checkpoint.Records, "offset:", checkpoint.Offset)
+		}
 	}
 
-	root := doc.SelectElement("RDF")
+	if !isDump {
+		checkpoint.Offset = d.Offset()
+		checkpoint.Done = true
+		if err := flushImportBatch(DB, batch, checkpoint); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	t := throttler.New(42, 100000000)
+	log.Println("Finish Parsing RDF xml, time period:", time.Now().Sub(starttime))
+}
 
-	for _, entry := range root.SelectElements("Topic") {
+// flushImportBatch upserts the categories, websites and dmoz entries of the
+// batch and moves the checkpoint forward within the same transaction
+func flushImportBatch(DB *gorm.DB, batch *importBatch, checkpoint *models.ImportCheckpoint) error {
+	tx := DB.Begin()
+	if err := writeImportBatch(tx, batch); err != nil {
+		tx.Rollback()
+		return err
+	}
+	checkpoint.Records += int64(batch.records)
+	if err := tx.Save(checkpoint).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+	batch.reset()
+	return tx.Commit().Error
+}
 
-		go func(entry *etree.Element) error {
-			defer t.Done(nil)
+func writeImportBatch(tx *gorm.DB, batch *importBatch) error {
+	now := time.Now()
 
-			c := &Category{}
-			//catid
-			catId := entry.SelectElement("catid")
-			if catId != nil {
-				log.Println("catid is:", catId.Text())
-				c.Code = catId.Text()
-			} else {
-				// continue
-				return nil
-			}
+	// categories, created once per topic name
+	names := make([]string, 0, len(batch.topics))
+	for _, topic := range batch.topics {
+		names = append(names, topic.ID)
+	}
+	ids, err := categoryIDs(tx, names)
+	if err != nil {
+		return err
+	}
+	var rows [][]interface{}
+	for _, topic := range batch.topics {
+		if _, ok := ids[topic.ID]; !ok {
+			rows = append(rows, []interface{}{topic.ID, topic.CatID, now, now})
+			ids[topic.ID] = 0
+		}
+	}
+	if len(rows) > 0 {
+		if err := bulk.Insert(tx, tx.NewScope(&models.Category{}).TableName(), []string{"name", "code", "created_at", "updated_at"}, rows); err != nil {
+			return err
+		}
+		if ids, err = categoryIDs(tx, names); err != nil {
+			return err
+		}
+	}
 
-			topic := entry.SelectAttr("r:id").Value
-			log.Println("topic is:", topic)
-			if topic == "" {
-				topic = "Root"
+	// websites, existing links are left untouched
+	links := make(map[string]bool)
+	rows = nil
+	for _, topic := range batch.topics {
+		for _, url := range topic.URLs() {
+			if links[url] {
+				continue
 			}
-			// topicParts := strings.Split(strings.Replace(topic, "_", " ", -1), "/")
-			/*
-				var err error
-				var cc *Category
-				for _, topicPart := range topicParts {
-					c.Name = topicPart
-					cc, err = createOrUpdateCategory(DB, c)
-					checkErr(err)
-				}
-			*/
+			links[url] = true
+			rows = append(rows, []interface{}{url, models.SourceDmoz, topic.ID, ids[topic.ID], 0, batch.dump, now, now})
+		}
+	}
+	err = bulk.Upsert(tx, tx.NewScope(&models.Website{}).TableName(), "link", []string{"link", "source", "path", "category_id", "analyzed", "dump", "created_at", "updated_at"}, nil, rows)
+	if err != nil {
+		return err
+	}
 
-			c.Name = topic
+	// dmoz entries, refreshed from the dump
+	rows = nil
+	for _, entry := range batch.pages {
+		dmoz := newDmoz(entry)
+		rows = append(rows, []interface{}{
+			dmoz.Link, dmoz.Title, dmoz.Description, dmoz.Path, dmoz.PathFull, dmoz.PathParent,
+			dmoz.Language, dmoz.LangIso6391, dmoz.LangIso6393, dmoz.LangScript, dmoz.LangConfidence,
+			dmoz.Priority, dmoz.MediaDate, strings.Join(entry.AgeGroups(), ","), batch.dump, now, now,
+		})
+	}
+	columns := []string{
+		"link", "title", "description", "path", "path_full", "path_parent",
+		"language", "lang_iso6391", "lang_iso6393", "lang_script", "lang_confidence",
+		"priority", "media_date", "ages", "dump", "created_at", "updated_at",
+	}
+	// the main dump does not overwrite the kids and teens labels
+	update := columns[1 : len(columns)-2]
+	if !rdf.IsKidsDump(batch.dump) {
+		update = columns[1 : len(columns)-4]
+	}
+	return bulk.Upsert(tx, tx.NewScope(&models.Dmoz{}).TableName(), "link", columns, update, rows)
+}
 
-			var err error
-			cc := &Category{}
-			if !isDump {
-				cc, err = createOrUpdateCategory(DB, c)
-				if err != nil {
-					return err
-				}
-			}
+// categoryIDs returns the ids of the categories with the given names
+func categoryIDs(tx *gorm.DB, names []string) (map[string]uint, error) {
+	ids := make(map[string]uint)
+	if len(names) == 0 {
+		return ids, nil
+	}
+	var categories []models.Category
+	if err := tx.Select("id, name").Where("name IN (?)", names).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+	for _, c := range categories {
+		ids[c.Name] = c.ID
+	}
+	return ids, nil
+}
 
-			//link1
-			link1 := entry.SelectElement("link1")
-			if link1 != nil {
-				url := link1.SelectAttr("r:resource").Value
-				log.Println("url is:", url)
-				website := &Website{}
-				website.Category = *cc
-				website.Link = url
-				website.Path = topic
+// newDmoz maps an ExternalPage to a dmoz entry, detecting the language of
+// its description
+func newDmoz(entry *rdf.ExternalPage) *models.Dmoz {
+	dmoz := &models.Dmoz{
+		Link:        entry.About,
+		Title:       entry.Title,
+		Description: entry.Description,
+		PathFull:    entry.Topic,
+		MediaDate:   entry.MediaDate,
+	}
+	if category, parent, ok := rdf.TopLevel(entry.Topic); ok {
+		dmoz.Path = category
+		dmoz.PathParent = parent
+	}
+	if entry.Priority != "" {
+		dmoz.Priority, _ = strconv.Atoi(entry.Priority)
+	}
+	info := whatlanggo.Detect(entry.Description)
+	dmoz.Language = info.Lang.String()
+	dmoz.LangScript = whatlanggo.Scripts[info.Script]
+	dmoz.LangIso6391 = info.Lang.Iso6391()
+	dmoz.LangIso6393 = info.Lang.Iso6393()
+	dmoz.LangConfidence = info.Confidence
+	return dmoz
+}
 
-				if !isDump {
-					_, err := createOrUpdateWebsite(DB, website)
-					if err != nil {
-						return err
-					}
-				} else {
-					csvDmoz.Write([]string{website.Link, website.Path})
-					csvDmoz.Flush()
-				}
-			}
+// importStructure builds the category tree from structure.rdf.u8. The first
+// pass creates one category per topic, the second one links children to their
+// parent through narrow edges and stores every relation as a CategoryLink.
+// Both write the topics by batches of --batch-size, a transaction each.
+func importStructure(rdfFile string, DB *gorm.DB) {
+	starttime := time.Now()
 
-			//link
-			links := entry.SelectElements("link")
-			if links != nil {
-				for _, url := range links {
-					urltxt := url.SelectAttr("r:resource").Value
-					log.Println("url is:", urltxt)
-					website := &Website{}
-					website.Category = *cc
-					website.Link = urltxt
-					website.Path = topic
-					if !isDump {
-						_, err := createOrUpdateWebsite(DB, website)
-						if err != nil {
-							return err
-						}
-					} else {
-						csvDmoz.Write([]string{website.Link, website.Path})
-						csvDmoz.Flush()
-					}
-				}
+	ids := make(map[string]uint)
+	err := importTopics(rdfFile, DB, func(tx *gorm.DB, topics []*rdf.Topic) error {
+		return writeStructureCategories(tx, topics, ids)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Println("imported categories:", len(ids))
+
+	err = importTopics(rdfFile, DB, func(tx *gorm.DB, topics []*rdf.Topic) error {
+		return writeStructureLinks(tx, topics, ids)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Finish Parsing structure RDF xml, categories:", len(ids), "time period:", time.Now().Sub(starttime))
+}
+
+// importTopics reads the topics of rdfFile and hands them to write by
+// batches of --batch-size, within a transaction per batch
+func importTopics(rdfFile string, DB *gorm.DB, write func(tx *gorm.DB, topics []*rdf.Topic) error) error {
+	file, err := rdf.Open(rdfFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var topics []*rdf.Topic
+	flush := func() error {
+		if len(topics) == 0 {
+			return nil
+		}
+		tx := DB.Begin()
+		if err := write(tx, topics); err != nil {
+			tx.Rollback()
+			return err
+		}
+		topics = nil
+		return tx.Commit().Error
+	}
+	err = rdf.Parse(file, rdf.Handler{
+		OnTopic: func(entry *rdf.Topic) error {
+			if entry.ID == "" {
+				return nil
+			}
+			topics = append(topics, entry)
+			if len(topics) >= isBatchSize {
+				return flush()
 			}
 			return nil
-		}(entry)
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// writeStructureCategories creates the categories of the topics missing
+// from the database, refreshes the others, and records their ids
+func writeStructureCategories(tx *gorm.DB, topics []*rdf.Topic, ids map[string]uint) error {
+	now := time.Now()
+	names := make([]string, 0, len(topics))
+	for _, topic := range topics {
+		names = append(names, topic.ID)
+	}
+	existing, err := categoryIDs(tx, names)
+	if err != nil {
+		return err
+	}
+
+	var inserts, updates [][]interface{}
+	created := make(map[string]bool)
+	for _, topic := range topics {
+		if id, ok := existing[topic.ID]; ok {
+			updates = append(updates, []interface{}{id, topic.CatID, topic.Title, topic.Description, now})
+		} else if !created[topic.ID] {
+			created[topic.ID] = true
+			inserts = append(inserts, []interface{}{topic.ID, topic.CatID, topic.Title, topic.Description, now, now})
+		}
+	}
+	table := tx.NewScope(&models.Category{}).TableName()
+	if err := bulk.Insert(tx, table, []string{"name", "code", "title", "description", "created_at", "updated_at"}, inserts); err != nil {
+		return err
+	}
+	// the existing rows are matched by id, the name not being unique
+	columns := []string{"id", "code", "title", "description", "updated_at"}
+	if err := bulk.Upsert(tx, table, "id", columns, columns[1:], updates); err != nil {
+		return err
+	}
+
+	if len(inserts) > 0 {
+		if existing, err = categoryIDs(tx, names); err != nil {
+			return err
+		}
+	}
+	for name, id := range existing {
+		ids[name] = id
+	}
+	return nil
+}
 
-		t.Throttle()
+// categoryLinkKey identifies a CategoryLink
+type categoryLinkKey struct {
+	categoryID uint
+	kind       string
+	target     string
+}
 
+// writeStructureLinks stores the edges of the topics as CategoryLinks, and
+// sets the parent of the categories they narrow to
+func writeStructureLinks(tx *gorm.DB, topics []*rdf.Topic, ids map[string]uint) error {
+	now := time.Now()
+	topicIDs := make([]uint, 0, len(topics))
+	for _, topic := range topics {
+		if id, ok := ids[topic.ID]; ok {
+			topicIDs = append(topicIDs, id)
+		}
+	}
+	if len(topicIDs) == 0 {
+		return nil
+	}
+	var links []models.CategoryLink
+	if err := tx.Select("id, category_id, kind, target").Where("category_id IN (?)", topicIDs).Find(&links).Error; err != nil {
+		return err
+	}
+	existing := make(map[categoryLinkKey]uint, len(links))
+	for _, link := range links {
+		existing[categoryLinkKey{link.CategoryID, link.Kind, link.Target}] = link.ID
 	}
 
-	// throttler errors iteration
-	if t.Err() != nil {
-		// Loop through the errors to see the details
-		for i, err := range t.Errs() {
-			log.Printf("error #%d: %s", i, err)
+	var inserts, updates, parents [][]interface{}
+	seen := make(map[categoryLinkKey]bool)
+	for _, topic := range topics {
+		id, ok := ids[topic.ID]
+		if !ok {
+			continue
+		}
+		for _, edge := range topic.Edges() {
+			key := categoryLinkKey{id, edge.Kind, edge.Target}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			targetID := ids[edge.Target]
+			if linkID, ok := existing[key]; ok {
+				updates = append(updates, []interface{}{linkID, edge.Label, targetID, now})
+			} else {
+				inserts = append(inserts, []interface{}{id, edge.Kind, edge.Label, edge.Target, targetID, now, now})
+			}
+			if edge.Kind == rdf.EdgeNarrow && targetID != 0 {
+				parents = append(parents, []interface{}{targetID, id, now})
+			}
 		}
-		log.Fatal(t.Err())
 	}
 
-	log.Println("Finish Parsing RDF xml, time period:", time.Now().Sub(starttime))
+	table := tx.NewScope(&models.CategoryLink{}).TableName()
+	if err := bulk.Insert(tx, table, []string{"category_id", "kind", "label", "target", "target_id", "created_at", "updated_at"}, inserts); err != nil {
+		return err
+	}
+	columns := []string{"id", "label", "target_id", "updated_at"}
+	if err := bulk.Upsert(tx, table, "id", columns, columns[1:], updates); err != nil {
+		return err
+	}
+	columns = []string{"id", "category_id", "updated_at"}
+	return bulk.Upsert(tx, tx.NewScope(&models.Category{}).TableName(), "id", columns, columns[1:], parents)
 }
 
 func scanFeeds(DB *gorm.DB) {
+	wapp, err := gowap.Init("./apps.json", false)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if isFromCache {
+		scanStoredFeeds(DB, wapp)
+		return
+	}
 
 	// Instantiate default collector
 	c := colly.NewCollector(
 	// colly.CacheDir(cachePath),
 	)
 
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
 	if isTorProxy {
 		rp, err := proxy.RoundRobinProxySwitcher("socks5://127.0.0.1:5566", "socks5://127.0.0.1:8119")
 		if err != nil {
 			log.Fatal(err)
 		}
-		c.SetProxyFunc(rp)
-	}
-
-	wapp, err := gowap.Init("./apps.json", false)
-	if err != nil {
-		log.Fatal(err)
+		transport.Proxy = rp
 	}
+	// pace the requests per host, instead of pausing after every response
+	c.WithTransport(scheduler.Transport(transport))
 
 	// create a request queue with 1 consumer thread
-	q, _ := queue.New(
+	storage := jobqueue.New(cfg.Queue, "scan-feeds")
+	q, err := queue.New(
 		parallelJobs, // Number of consumer threads set to 1 to avoid dead lock on database
-		&queue.InMemoryQueueStorage{
-			MaxSize: queueMaxSize,
-		}, // Use default queue storage
+		storage,      // persisted in data/queue.db, resumed after a restart
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer storage.Close()
+	storage.Track(c)
+
+	// the HTTP redirects of the requests in flight, by the URL they were
+	// queued with
+	var redirects sync.Map
+	c.SetRedirectHandler(func(req *http.Request, via []*http.Request) error {
+		// colly's default
+		if len(via) >= 10 {
+			return http.ErrUseLastResponse
+		}
+		hop := fetch.Hop{URL: via[len(via)-1].URL.String(), Location: req.URL.String(), Kind: fetch.HTTPRedirect}
+		if req.Response != nil {
+			hop.StatusCode = req.Response.StatusCode
+		}
+		hops, _ := redirects.Load(via[0].URL.String())
+		list, _ := hops.([]fetch.Hop)
+		redirects.Store(via[0].URL.String(), append(list, hop))
+		return nil
+	})
+	takeRedirects := func(link string) []fetch.Hop {
+		hops, _ := redirects.Load(link)
+		redirects.Delete(link)
+		list, _ := hops.([]fetch.Hop)
+		return list
+	}
+	c.OnScraped(func(r *colly.Response) {
+		redirects.Delete(r.Ctx.Get("url"))
+	})
 
 	c.OnError(func(r *colly.Response, err error) {
 		fmt.Println("error:", err, r.Request.URL, r.StatusCode)
-		website := &Website{}
-		if !DB.Where("link = ?", r.Request.URL.String()).First(&website).RecordNotFound() {
+		link := r.Ctx.Get("url")
+		hops := takeRedirects(link)
+		// robots.txt refusing the page tells nothing of the website
+		if errors.Is(err, politeness.ErrDisallowed) || errors.Is(err, robotstxt.ErrUnreachable) {
+			return
+		}
+		// a request sent again, eg. after a 503, is not dead yet
+		retrying, err := storage.Retrying(link)
+		if err != nil {
+			log.Warnln("could not read the queue: ", err)
+		}
+		if retrying {
+			return
+		}
+		website := &models.Website{}
+		if !DB.Where("link = ?", link).First(&website).RecordNotFound() {
 			website.Alive = false
 			website.StatusCode = r.StatusCode
 			website.Analyzed = 1
+			markChecked(website, time.Now().UTC(), false)
+			finalURL := link
+			if len(hops) > 0 {
+				finalURL = hops[len(hops)-1].Location
+			}
+			setFinalURL(website, finalURL)
 			if err := DB.Save(website).Error; err != nil {
 				log.Fatalln("could not update entry: ", err)
 			}
+			if err := models.SaveRedirects(DB, website.ID, redirectsOf(hops)); err != nil {
+				log.Fatalln("could not save redirects: ", err)
+			}
 		}
 	})
 
 	c.OnResponse(func(r *colly.Response) {
-		time.Sleep(1 * time.Second)
 		if isVerbose {
 			fmt.Println("OnResponse from", r.Ctx.Get("url"))
 		}
+		if archive != nil {
+			err := archive.Write(&warc.Exchange{
+				Link:          r.Ctx.Get("url"),
+				Method:        r.Request.Method,
+				URL:           r.Request.URL.String(),
+				RequestHeader: *r.Request.Headers,
+				StatusCode:    r.StatusCode,
+				Header:        *r.Headers,
+				Body:          r.Body,
+			})
+			if err != nil {
+				log.Warnln("could not archive", r.Request.URL, err)
+			}
+		}
 	})
 
 	// Before making a request print "Visiting ..."
@@ -690,54 +1580,39 @@ func scanFeeds(DB *gorm.DB) {
 		fmt.Println("Visiting", r.URL.String())
 		//}
 		r.Ctx.Put("url", r.URL.String())
+		redirects.Delete(r.URL.String())
 	})
 
 	c.OnHTML(`html`, func(e *colly.HTMLElement) {
-		website := &Website{}
+		website := &models.Website{}
 		if !DB.Where("link = ? AND analyzed=0", e.Request.Ctx.Get("url")).First(&website).RecordNotFound() {
-
-			e.ForEach(`title`, func(_ int, el *colly.HTMLElement) {
-				website.Title = el.Text
-			})
-
-			e.ForEach(`meta[name="description"]`, func(_ int, el *colly.HTMLElement) {
-				website.Description = el.Attr("content")
-			})
-
-			e.ForEach(`meta[property="og:description"]`, func(_ int, el *colly.HTMLElement) {
-				if website.Description == "" {
-					website.Description = el.Attr("content")
-				}
-			})
-
-			e.ForEach(`link[type="application/rss+xml"]`, func(_ int, el *colly.HTMLElement) {
-				rss := el.Attr("href")
-				if rss != "" {
-					if !strings.HasPrefix(rss, "http") {
-						separator := "/"
-						if strings.HasSuffix(e.Request.Ctx.Get("url"), "/") && strings.HasPrefix(rss, "/") {
-							separator = ""
-						}
-						rss = e.Request.Ctx.Get("url") + separator + rss
-					}
-					website.Rss = append(website.Rss, Rss{Href: rss})
-				}
-			})
-
-			if res, err := wapp.Analyze(e.Request.Ctx.Get("url")); err == nil {
-				prettyJSON, err := json.Marshal(res)
-				if err != nil {
-					log.Warnln("prettyJSON:", err)
-				}
-				website.Wap = string(prettyJSON)
-			}
+			describeWebsite(website, wapp, e.Request.Ctx.Get("url"), *e.Response.Headers, e.Response.Body, e.DOM)
 			website.StatusCode = 200
 			website.Analyzed = 1
+			website.ETag = e.Response.Headers.Get("ETag")
+			website.LastModified = e.Response.Headers.Get("Last-Modified")
+			markChecked(website, time.Now().UTC(), true)
+
+			// follow the meta refresh or script redirect of the page, if any
+			hops := takeRedirects(e.Request.Ctx.Get("url"))
+			finalURL := e.Request.URL.String()
+			if target, kind := fetch.ClientRedirect(e.Response.Body, finalURL); target != "" {
+				hops = append(hops, fetch.Hop{URL: finalURL, StatusCode: e.Response.StatusCode, Location: target, Kind: kind})
+				finalURL = target
+				if page, err := fetcher.Get(context.Background(), target); err == nil {
+					hops = append(hops, page.Redirects...)
+					finalURL = page.FinalURL
+				}
+			}
+			setFinalURL(website, finalURL)
 
 			// Update entry
 			if err := DB.Save(website).Error; err != nil {
 				log.Fatalln("could not update entry: msg=", err, "url=", e.Request.Ctx.Get("url"))
 			}
+			if err := models.SaveRedirects(DB, website.ID, redirectsOf(hops)); err != nil {
+				log.Fatalln("could not save redirects: msg=", err, "url=", e.Request.Ctx.Get("url"))
+			}
 		}
 
 	})
@@ -746,163 +1621,186 @@ func scanFeeds(DB *gorm.DB) {
 		Link string
 	}
 
-	// Load list of websites.
-	var results []res
-	offset := isOffset * isLimit
+	// Load the websites batch by batch, each one being consumed before the
+	// next is claimed
+	selector := newSelector("scan-feeds", "analyzed=0")
+	for {
+		var results []res
+		more, err := nextWebsites(selector, "link", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
+		for _, result := range results {
+			if strings.HasPrefix(result.Link, "http") {
+				fmt.Println("enqueuing", result.Link)
+				q.AddURL(result.Link)
+			}
+		}
+
+		// Consume URLs
+		q.Run(c)
+	}
+
+}
 
-	query := fmt.Sprintf("select link FROM websites WHERE analyzed=0 ORDER BY RAND() LIMIT %d,%d", offset, isLimit)
-	fmt.Println("query:", query)
+// scanStoredFeeds is scanFeeds extracting again the title, description,
+// feeds and tech stack of every website from its stored page. The liveness
+// and redirects found by the crawl are left as they are.
+func scanStoredFeeds(DB *gorm.DB, wapp *gowap.Wappalyzer) {
+	selector := newScanSelector("scan-feeds", "analyzed=0")
 
-	DB.Raw(query).Scan(&results)
-	for _, result := range results {
-		if strings.HasPrefix(result.Link, "http") {
-			fmt.Println("enqueuing", result.Link)
-			q.AddURL(result.Link)
-		}
+	type result struct {
+		Link string
 	}
 
-	// Consume URLs
-	q.Run(c)
+	for {
+		var results []result
+		more, err := nextWebsites(selector, "link", &results)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !more {
+			break
+		}
 
-}
+		// wait for the whole batch before claiming the next one
+		t := throttler.New(parallelJobs, len(results))
+		for _, r := range results {
+			go func(entry result) error {
+				defer t.Done(nil)
+				if !strings.HasPrefix(entry.Link, "http") {
+					return nil
+				}
+				website := &models.Website{}
+				if DB.Where("link = ?", entry.Link).First(&website).RecordNotFound() {
+					return nil
+				}
+				page, err := fetcher.Get(context.Background(), entry.Link)
+				if errors.Is(err, fetch.ErrNotStored) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+				if !page.OK() || !strings.Contains(page.MediaType, "html") {
+					return nil
+				}
+				doc, err := goquery.NewDocumentFromReader(page.Reader())
+				if err != nil {
+					return err
+				}
+				describeWebsite(website, wapp, entry.Link, page.Header, page.Body, doc.Selection)
+
+				// keep the feeds found by the previous extractions
+				var known []string
+				if err := DB.Model(&models.Rss{}).Where("website_id = ?", website.ID).Pluck("href", &known).Error; err != nil {
+					return err
+				}
+				seen := make(map[string]bool, len(known))
+				for _, href := range known {
+					seen[href] = true
+				}
+				feeds := website.Rss[:0]
+				for _, rss := range website.Rss {
+					if !seen[rss.Href] {
+						seen[rss.Href] = true
+						feeds = append(feeds, rss)
+					}
+				}
+				website.Rss = feeds
+				return DB.Save(website).Error
+			}(r)
+			t.Throttle()
+		}
 
-func createOrUpdateWebsite(db *gorm.DB, website *Website) (*Website, error) {
-	var existingWebsite Website
-	if db.Where("link = ?", website.Link).First(&existingWebsite).RecordNotFound() {
-		err := db.Create(website).Error
-		return website, err
+		// throttler errors iteration
+		if t.Err() != nil {
+			// Loop through the errors to see the details
+			for i, err := range t.Errs() {
+				log.Printf("error #%d: %s", i, err)
+			}
+			log.Fatal(t.Err())
+		}
 	}
-	website.ID = existingWebsite.ID
-	website.CreatedAt = existingWebsite.CreatedAt
-	return website, nil
 }
 
-func createOrUpdateCategory(db *gorm.DB, cat *Category) (*Category, error) {
-	var existingCategory Category
-	if db.Where("name = ?", cat.Name).First(&existingCategory).RecordNotFound() {
-		err := db.Create(cat).Error
-		return cat, err
+// describeWebsite sets the title, description, feeds and tech stack of a
+// website from its page, link resolving the relative feeds
+func describeWebsite(website *models.Website, wapp *gowap.Wappalyzer, link string, header http.Header, body []byte, doc *goquery.Selection) {
+	doc.Find(`title`).Each(func(_ int, el *goquery.Selection) {
+		website.Title = el.Text()
+	})
+
+	doc.Find(`meta[name="description"]`).Each(func(_ int, el *goquery.Selection) {
+		website.Description, _ = el.Attr("content")
+	})
+
+	doc.Find(`meta[property="og:description"]`).Each(func(_ int, el *goquery.Selection) {
+		if website.Description == "" {
+			website.Description, _ = el.Attr("content")
+		}
+	})
+
+	doc.Find(`link[type="application/rss+xml"]`).Each(func(_ int, el *goquery.Selection) {
+		rss, _ := el.Attr("href")
+		if rss != "" {
+			if !strings.HasPrefix(rss, "http") {
+				separator := "/"
+				if strings.HasSuffix(link, "/") && strings.HasPrefix(rss, "/") {
+					separator = ""
+				}
+				rss = link + separator + rss
+			}
+			website.Rss = append(website.Rss, models.Rss{Href: rss})
+		}
+	})
+
+	// the page fetched is analyzed, rather than downloaded again
+	if res, err := wapp.AnalyzePage(link, header, body); err == nil {
+		prettyJSON, err := json.Marshal(res)
+		if err != nil {
+			log.Warnln("prettyJSON:", err)
+		}
+		website.Wap = string(prettyJSON)
 	}
-	cat.ID = existingCategory.ID
-	cat.CreatedAt = existingCategory.CreatedAt
-	return cat, nil
 }
 
-func checkErr(err error) {
-	if err != nil {
-		log.Fatal(err)
+// newScanSelector is newSelector for a scan of the websites matching where.
+// With --from-cache, every website is extracted again, under claims of its
+// own. As nothing tells a website was extracted from the cache, a run only
+// leaves the next one free to start over by releasing every batch it claims.
+func newScanSelector(task, where string) *work.Selector {
+	if isFromCache {
+		return newSelector(task+"-cache", "")
 	}
+	return newSelector(task, where)
 }
 
-type Dmoz struct {
-	gorm.Model
-	Link           string `gorm:"size:255;unique"`
-	Title          string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	Description    string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	Path           string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	PathFull       string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	PathParent     string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	Language       string
-	LangIso6391    string
-	LangIso6393    string
-	LangScript     string `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	LangConfidence float64
-}
-
-type Website struct {
-	gorm.Model
-	Link           string   `gorm:"size:255;unique"`
-	Alive          bool     `gorm:"index:alive"`
-	StatusCode     int      `gorm:"index:status_code"`
-	Name           string   `gorm:"index:name; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Path           string   `gorm:"index:path; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Title          string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Description    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	CategoryID     uint     `l10n:"sync"`
-	Category       Category `l10n:"sync"`
-	Wap            string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Analyzed       int      `gorm:"type:tinyint" sql:"type:tinyint`
-	TextExtract    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	ArticleText    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	RobotsTxt      string   `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	Host           string
-	Scheme         string
-	Domain         string
-	Tld            string
-	Language       string
-	LangConfidence float64
-	Ranking        Rank
-	Rss            []Rss
-	Sitemaps       []Sitemap
-}
-
-type AlexaWebsite struct {
-	gorm.Model
-	Link           string   `gorm:"size:255;unique"`
-	Alive          bool     `gorm:"index:alive"`
-	StatusCode     int      `gorm:"index:status_code"`
-	Name           string   `gorm:"index:name; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Path           string   `gorm:"index:path; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Title          string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Description    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	CategoryID     uint     `l10n:"sync"`
-	Category       Category `l10n:"sync"`
-	Wap            string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Analyzed       int      `gorm:"type:tinyint" sql:"type:tinyint`
-	TextExtract    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	ArticleText    string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	RobotsTxt      string   `gorm:"type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longtext"`
-	Host           string
-	Scheme         string
-	Domain         string
-	Tld            string
-	Language       string
-	LangConfidence float64
-	Ranking        Rank
-	Rss            []Rss
-	Sitemaps       []Sitemap
-}
-
-type Sitemap struct {
-	gorm.Model
-	Href      string `sql:"type:longtext"`
-	Index     bool
-	Gziped    bool
-	WebsiteID uint
-}
-
-type Rss struct {
-	gorm.Model
-	Href               string `sql:"type:longtext"`
-	Language           string
-	LanguageConfidence float64
-	WebsiteID          uint
-}
-
-type Rank struct {
-	gorm.Model
-	Alexa    int
-	Quancast int
-	Majestic int
-}
-
-type Category struct {
-	gorm.Model
-	Name       string `gorm:"index:name"`
-	Code       string `gorm:"index:code"`
-	Categories []Category
-	CategoryID uint `gorm:"index:category_id"`
-}
-
-func (category Category) Validate(db *gorm.DB) {
-	if strings.TrimSpace(category.Name) == "" {
-		db.AddError(validations.NewError(category, "Name", "Name can not be empty"))
-	}
-}
-
-func (category Category) DefaultPath() string {
-	if len(category.Code) > 0 {
-		return fmt.Sprintf("/category/%s", category.Code)
-	}
-	return "/"
+// newSelector returns the selector of the websites matching where, claimed
+// for task by batches of --claim-size, at most --limit of them
+func newSelector(task, where string, args ...interface{}) *work.Selector {
+	selector := work.New(task, DB.NewScope(&models.Website{}).TableName(), where, args...)
+	selector.BatchSize = claimSize
+	selector.TTL = claimTTL
+	selector.Limit = isLimit
+	return selector
+}
+
+// nextWebsites releases the batch of websites the scan is done with, claims
+// the next one of selector and scans their columns into results. It returns
+// false once there is nothing left. The scans process every batch before
+// asking for the next.
+func nextWebsites(selector *work.Selector, columns string, results interface{}) (bool, error) {
+	if err := selector.Done(DB); err != nil {
+		return false, err
+	}
+	ids, err := selector.Next(DB)
+	if err != nil || len(ids) == 0 {
+		return false, err
+	}
+	err = DB.Table(DB.NewScope(&models.Website{}).TableName()).Select(columns).Where("id IN (?)", ids).Scan(results).Error
+	return err == nil, err
 }
@@ -10,11 +10,14 @@ Author: Roman Gelembjuk <roman@gelembjuk.com>
 */
 
 import (
+	"fmt"
 	"io"
 	"log"
 	"os"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/fetch"
 )
 
 // extracts useful text from a html file
@@ -32,16 +35,41 @@ func GetArticleTextFromFile(filepath string) (string, error) {
 
 // extracts useful text from a html page presented by an url
 func GetArticleTextFromUrl(url string) (string, error) {
-	doc, err := goquery.NewDocument(url)
+	doc, err := documentFromUrl(url)
+
+	if err != nil {
+		return "", err
+	}
+
+	return processArticle(doc, 1)
+}
+
+// extracts useful text from a html page already fetched
+func GetArticleTextFromResponse(resp *fetch.Response) (string, error) {
+	doc, err := goquery.NewDocumentFromReader(resp.Reader())
 
 	if err != nil {
-		// log.Fatal(err)
 		return "", err
 	}
 
 	return processArticle(doc, 1)
 }
 
+// fetches a html page, decoded to UTF-8
+func documentFromUrl(url string) (*goquery.Document, error) {
+	resp, err := fetch.Get(url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !resp.OK() {
+		return nil, fmt.Errorf("%s: status %d", url, resp.StatusCode)
+	}
+
+	return goquery.NewDocumentFromReader(resp.Reader())
+}
+
 // extracts useful text from a html document presented as a Reader object
 func GetArticleText(input io.Reader) (string, error) {
 
@@ -71,10 +99,9 @@ func GetArticleSignatureFromFile(filepath string) (string, error) {
 
 // extracts useful text from a html page presented by an url
 func GetArticleSignatureFromUrl(url string) (string, error) {
-	doc, err := goquery.NewDocument(url)
+	doc, err := documentFromUrl(url)
 
 	if err != nil {
-		log.Fatal(err)
 		return "", err
 	}
 
@@ -109,10 +136,9 @@ func GetArticleTextFromFileByPath(filepath string, path string) (string, error)
 
 // extracts useful text from a html page presented by an url
 func GetArticleTextFromUrlByPath(url string, path string) (string, error) {
-	doc, err := goquery.NewDocument(url)
+	doc, err := documentFromUrl(url)
 
 	if err != nil {
-		log.Fatal(err)
 		return "", err
 	}
 
@@ -0,0 +1,106 @@
+// Package bulk writes many rows at once with multi-row INSERT statements,
+// using the upsert syntax of the dialect behind a gorm connection (mysql,
+// sqlite3 or postgres).
+package bulk
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// MaxVars is the maximum number of bind variables sent in one statement,
+// sqlite being the most restrictive of the supported databases
+var MaxVars = 999
+
+// Insert inserts rows into table, each row holding one value per column
+func Insert(db *gorm.DB, table string, columns []string, rows [][]interface{}) error {
+	return Upsert(db, table, "", columns, nil, rows)
+}
+
+// Upsert inserts rows into table, each row holding one value per column.
+// When a row conflicts on the unique key column, the update columns are
+// overwritten with the new values, or the existing row is left untouched
+// when update is empty. Rows must not repeat a key within one call.
+func Upsert(db *gorm.DB, table string, key string, columns []string, update []string, rows [][]interface{}) error {
+	_, err := upsert(db, table, key, columns, update, rows)
+	return err
+}
+
+// upsert is Upsert returning the number of rows affected, as counted by the
+// database
+func upsert(db *gorm.DB, table string, key string, columns []string, update []string, rows [][]interface{}) (int64, error) {
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	size := MaxVars / len(columns)
+	if size < 1 {
+		return 0, fmt.Errorf("bulk: %d columns exceed the %d variables limit", len(columns), MaxVars)
+	}
+	var affected int64
+	for start := 0; start < len(rows); start += size {
+		end := start + size
+		if end > len(rows) {
+			end = len(rows)
+		}
+		query, vars := build(db.Dialect(), table, key, columns, update, rows[start:end])
+		result := db.Exec(query, vars...)
+		if result.Error != nil {
+			return affected, result.Error
+		}
+		affected += result.RowsAffected
+	}
+	return affected, nil
+}
+
+func build(dialect gorm.Dialect, table string, key string, columns []string, update []string, rows [][]interface{}) (string, []interface{}) {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = dialect.Quote(column)
+	}
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?,", len(columns)), ",") + ")"
+
+	var query strings.Builder
+	vars := make([]interface{}, 0, len(rows)*len(columns))
+	fmt.Fprintf(&query, "INSERT INTO %s (%s) VALUES ", dialect.Quote(table), strings.Join(quoted, ","))
+	for i, row := range rows {
+		if i > 0 {
+			query.WriteString(",")
+		}
+		query.WriteString(placeholders)
+		vars = append(vars, row...)
+	}
+
+	if key == "" {
+		return query.String(), vars
+	}
+
+	switch dialect.GetName() {
+	case "mysql":
+		query.WriteString(" ON DUPLICATE KEY UPDATE ")
+		if len(update) == 0 {
+			fmt.Fprintf(&query, "%s=%s", dialect.Quote(key), dialect.Quote(key))
+		}
+		for i, column := range update {
+			if i > 0 {
+				query.WriteString(",")
+			}
+			fmt.Fprintf(&query, "%s=VALUES(%s)", dialect.Quote(column), dialect.Quote(column))
+		}
+	default:
+		fmt.Fprintf(&query, " ON CONFLICT (%s) DO ", dialect.Quote(key))
+		if len(update) == 0 {
+			query.WriteString("NOTHING")
+		} else {
+			query.WriteString("UPDATE SET ")
+		}
+		for i, column := range update {
+			if i > 0 {
+				query.WriteString(",")
+			}
+			fmt.Fprintf(&query, "%s=excluded.%s", dialect.Quote(column), dialect.Quote(column))
+		}
+	}
+	return query.String(), vars
+}
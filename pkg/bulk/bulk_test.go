@@ -0,0 +1,91 @@
+package bulk
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+type page struct {
+	ID    uint   `gorm:"primary_key"`
+	Link  string `gorm:"unique"`
+	Title string
+	Hits  int
+}
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&page{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestUpsert(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	columns := []string{"link", "title", "hits"}
+	rows := [][]interface{}{
+		{"http://a.example.com/", "a", 1},
+		{"http://b.example.com/", "b", 1},
+	}
+	if err := Insert(db, "pages", columns, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	// untouched on conflict
+	rows = [][]interface{}{
+		{"http://a.example.com/", "a2", 2},
+		{"http://c.example.com/", "c", 1},
+	}
+	if err := Upsert(db, "pages", "link", columns, nil, rows); err != nil {
+		t.Fatal(err)
+	}
+	var a page
+	db.Where("link = ?", "http://a.example.com/").First(&a)
+	if a.Title != "a" || a.Hits != 1 {
+		t.Errorf("expected the row to be left untouched, got %+v", a)
+	}
+
+	// updated on conflict
+	if err := Upsert(db, "pages", "link", columns, []string{"title"}, rows); err != nil {
+		t.Fatal(err)
+	}
+	db.Where("link = ?", "http://a.example.com/").First(&a)
+	if a.Title != "a2" || a.Hits != 1 {
+		t.Errorf("expected only the title to be updated, got %+v", a)
+	}
+
+	var count int
+	db.Model(&page{}).Count(&count)
+	if count != 3 {
+		t.Errorf("expected 3 rows, got %d", count)
+	}
+}
+
+func TestUpsert_splitsLargeBatches(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	defer func(max int) { MaxVars = max }(MaxVars)
+	MaxVars = 7
+
+	var rows [][]interface{}
+	for _, link := range []string{"a", "b", "c", "d", "e"} {
+		rows = append(rows, []interface{}{link, link, 0})
+	}
+	if err := Upsert(db, "pages", "link", []string{"link", "title", "hits"}, []string{"title"}, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	var count int
+	db.Model(&page{}).Count(&count)
+	if count != 5 {
+		t.Errorf("expected 5 rows, got %d", count)
+	}
+}
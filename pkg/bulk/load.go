@@ -0,0 +1,153 @@
+package bulk
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Loader streams the tab separated files written by pkg/csv into a table,
+// committing one transaction per batch
+type Loader struct {
+	// Table receives the rows
+	Table string
+	// Columns maps the fields of a line to the columns of Table
+	Columns []string
+	// Key is the unique column rows are upserted on, rows are only
+	// inserted when empty
+	Key string
+	// Update lists the columns overwritten when a row already exists, the
+	// existing row is left untouched when empty
+	Update []string
+	// Values are appended to every row, eg. created_at
+	Values map[string]interface{}
+	// SkipHeader ignores the first line of the file
+	SkipHeader bool
+	// BatchSize is the number of rows written per transaction
+	BatchSize int
+}
+
+// Stats counts the lines read by a Loader. Loaded rows were written to the
+// table, skipped ones have an empty or repeated key, or already existed
+// when nothing is updated, and malformed ones could not be parsed or do not
+// have one field per column.
+type Stats struct {
+	Loaded    int64
+	Skipped   int64
+	Malformed int64
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("loaded=%d skipped=%d malformed=%d", s.Loaded, s.Skipped, s.Malformed)
+}
+
+// Load reads r until EOF and writes its rows to the table of db
+func (l *Loader) Load(db *gorm.DB, r io.Reader) (Stats, error) {
+	var stats Stats
+
+	extra := make([]string, 0, len(l.Values))
+	for column := range l.Values {
+		extra = append(extra, column)
+	}
+	sort.Strings(extra)
+	columns := append(append([]string{}, l.Columns...), extra...)
+
+	key := -1
+	for i, column := range l.Columns {
+		if column == l.Key {
+			key = i
+		}
+	}
+	if l.Key != "" && key < 0 {
+		return stats, fmt.Errorf("bulk: key %s is not one of the columns", l.Key)
+	}
+
+	size := l.BatchSize
+	if size <= 0 {
+		size = 1000
+	}
+
+	reader := csv.NewReader(r)
+	reader.Comma = '\t'
+	reader.FieldsPerRecord = -1
+
+	var rows [][]interface{}
+	keys := make(map[string]struct{})
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		tx := db.Begin()
+		affected, err := upsert(tx, l.Table, l.Key, columns, l.Update, rows)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit().Error; err != nil {
+			return err
+		}
+		if l.Key != "" && len(l.Update) == 0 {
+			stats.Loaded += affected
+			stats.Skipped += int64(len(rows)) - affected
+		} else {
+			stats.Loaded += int64(len(rows))
+		}
+		rows = rows[:0]
+		keys = make(map[string]struct{})
+		return nil
+	}
+
+	for line := 0; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			stats.Malformed++
+			continue
+		}
+		if err != nil {
+			return stats, err
+		}
+		if line == 0 && l.SkipHeader {
+			continue
+		}
+		if len(record) != len(l.Columns) {
+			stats.Malformed++
+			continue
+		}
+
+		if key >= 0 {
+			if record[key] == "" {
+				stats.Skipped++
+				continue
+			}
+			if _, ok := keys[record[key]]; ok {
+				stats.Skipped++
+				continue
+			}
+			keys[record[key]] = struct{}{}
+		}
+
+		row := make([]interface{}, 0, len(columns))
+		for _, field := range record {
+			row = append(row, field)
+		}
+		for _, column := range extra {
+			row = append(row, l.Values[column])
+		}
+		rows = append(rows, row)
+
+		if len(rows) >= size {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+	return stats, flush()
+}
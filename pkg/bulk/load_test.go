@@ -0,0 +1,49 @@
+package bulk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoader_Load(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	if err := Insert(db, "pages", []string{"link", "title"}, [][]interface{}{{"http://a.example.com/", "existing"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	input := strings.Join([]string{
+		"link\ttitle",
+		"http://a.example.com/\ta",
+		"http://b.example.com/\tb",
+		"http://b.example.com/\tb again",
+		"\tno link",
+		"http://c.example.com/",
+		"http://d.example.com/\tbare\"quote",
+		"http://e.example.com/\t\"tab\tquoted\"",
+	}, "\n")
+
+	loader := &Loader{
+		Table:      "pages",
+		Columns:    []string{"link", "title"},
+		Key:        "link",
+		Values:     map[string]interface{}{"hits": 1},
+		SkipHeader: true,
+		BatchSize:  2,
+	}
+	stats, err := loader.Load(db, strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Stats{Loaded: 2, Skipped: 3, Malformed: 2}
+	if stats != expected {
+		t.Errorf("expected %s, got %s", expected, stats)
+	}
+
+	var e page
+	db.Where("link = ?", "http://e.example.com/").First(&e)
+	if e.Title != "tab\tquoted" || e.Hits != 1 {
+		t.Errorf("unexpected row %+v", e)
+	}
+}
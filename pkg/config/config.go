@@ -0,0 +1,316 @@
+// Package config loads the settings shared by every command from an
+// optional json file, overridden by environment variables such as the
+// ND_MYSQL_* ones of docker-compose.yml.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	log "github.com/sirupsen/logrus"
+)
+
+// Database drivers, named after their gorm dialect
+const (
+	SQLite   = "sqlite3"
+	MySQL    = "mysql"
+	Postgres = "postgres"
+)
+
+// DefaultSQLitePath is the database used when nothing else is configured
+const DefaultSQLitePath = "./data/dmoz.db"
+
+// DefaultQueuePath is the sqlite file holding the crawl queues
+const DefaultQueuePath = "./data/queue.db"
+
+// Config holds the settings of the commands
+type Config struct {
+	Database   Database   `json:"database"`
+	Queue      Queue      `json:"queue"`
+	Politeness Politeness `json:"politeness"`
+	Fetch      Fetch      `json:"fetch"`
+	Recheck    Recheck    `json:"recheck"`
+	Archive    Archive    `json:"archive"`
+}
+
+// Queue configures the crawl queues persisted on disk, shared by every
+// command. Zero values select the defaults of pkg/jobqueue.
+type Queue struct {
+	// Path is the sqlite file of the queues, DefaultQueuePath when empty
+	Path string `json:"path"`
+	// VisibilityTimeout is how long a request handed to a crawler stays
+	// hidden from the others, before being handed out again
+	VisibilityTimeout Duration `json:"visibility_timeout"`
+	// MaxRetries is the number of times a request is handed out before
+	// being given up
+	MaxRetries int `json:"max_retries"`
+}
+
+// Politeness configures the fetch scheduler shared by the crawlers. Zero
+// values select the defaults of pkg/politeness.
+type Politeness struct {
+	// UserAgent is the token matched against the groups of robots.txt
+	UserAgent string `json:"user_agent"`
+	// IgnoreRobots disables robots.txt, Crawl-delay included
+	IgnoreRobots bool `json:"ignore_robots"`
+	// RobotsTTL is how long a robots.txt file is kept before being fetched
+	// again
+	RobotsTTL Duration `json:"robots_ttl"`
+	// PerDomain shares the limits of a host with the other hosts of its
+	// registered domain, eg. www.example.com and shop.example.com
+	PerDomain bool `json:"per_domain"`
+	// Concurrency and RequestsPerSecond limit the requests of the process,
+	// 0 for no limit
+	Concurrency       int     `json:"concurrency"`
+	RequestsPerSecond float64 `json:"requests_per_second"`
+	// HostConcurrency and HostDelay limit the requests of a host, HostDelay
+	// being raised to the Crawl-delay of robots.txt
+	HostConcurrency int      `json:"host_concurrency"`
+	HostDelay       Duration `json:"host_delay"`
+	// Backoff is the first pause of a host answering 429 or 503 without
+	// Retry-After, doubled while it keeps doing so
+	Backoff Duration `json:"backoff"`
+	// MaxDelay caps Crawl-delay, Retry-After and Backoff
+	MaxDelay Duration `json:"max_delay"`
+}
+
+// Fetch configures how the crawlers download pages. Zero values select the
+// defaults of pkg/fetch.
+type Fetch struct {
+	// UserAgent is the User-Agent header of the requests
+	UserAgent string `json:"user_agent"`
+	// Timeout bounds a fetch, redirects and body included
+	Timeout Duration `json:"timeout"`
+	// MaxBodySize is the number of bytes of a body kept, the rest being
+	// dropped
+	MaxBodySize int64 `json:"max_body_size"`
+	// MaxRedirects is the number of redirects followed
+	MaxRedirects int `json:"max_redirects"`
+	// MaxRefreshes is the number of meta refresh and script redirects
+	// followed
+	MaxRefreshes int `json:"max_refreshes"`
+}
+
+// Recheck configures when the websites are revisited. Zero values select
+// the defaults of pkg/recheck.
+type Recheck struct {
+	// Factor of the time since a page last changed after which it is
+	// checked again, so that stable pages are visited less often
+	Factor float64 `json:"factor"`
+	// MinInterval and MaxInterval bound the time between two checks
+	MinInterval Duration `json:"min_interval"`
+	MaxInterval Duration `json:"max_interval"`
+}
+
+// Archive configures the WARC files the fetched pages are written to, see
+// pkg/warc. Pages are only archived when Dir is set.
+type Archive struct {
+	// Dir holds the WARC files and their index
+	Dir string `json:"dir"`
+	// Prefix starts the names of the WARC files
+	Prefix string `json:"prefix"`
+	// MaxSize is the size after which a new WARC file is started
+	MaxSize int64 `json:"max_size"`
+}
+
+// Duration is a time.Duration written as a string in json, eg. "10m"
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON reads a duration such as "1m30s", or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+	case string:
+		duration, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		d.Duration = duration
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.Duration.String())
+}
+
+// Database selects the backend and how to reach it. Path is only used by
+// sqlite, Host, Port, User, Password and Name by mysql and postgres. Params
+// are appended to the generated dsn, and DSN replaces it altogether.
+type Database struct {
+	Driver   string `json:"driver"`
+	Path     string `json:"path"`
+	Host     string `json:"host"`
+	Port     string `json:"port"`
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	Params   string `json:"params"`
+	DSN      string `json:"dsn"`
+}
+
+// Load reads the json file at path, when it exists, then applies the
+// environment variables. An empty path only reads the environment. Without
+// either, the default sqlite database is used, which is warned about as the
+// deployments of the mysql days had no config file.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+	found := false
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, err
+		default:
+			if err := json.Unmarshal(data, cfg); err != nil {
+				return nil, fmt.Errorf("config: %s: %v", path, err)
+			}
+			found = true
+		}
+	}
+	if !found && !databaseEnv() {
+		log.Warnf("config: no %s nor ND_* database variables, using the sqlite database %s", orDefault(path, "config file"), DefaultSQLitePath)
+	}
+	cfg.Database.fromEnv()
+	setFromEnv(&cfg.Queue.Path, "ND_QUEUE_PATH")
+	if cfg.Queue.Path == "" {
+		cfg.Queue.Path = DefaultQueuePath
+	}
+	setFromEnv(&cfg.Archive.Dir, "ND_ARCHIVE_DIR")
+	return cfg, cfg.Database.validate()
+}
+
+// fromEnv overrides the settings with ND_DB_DRIVER, ND_DB_DSN and the
+// variables of the selected driver. Without any driver configured, mysql is
+// picked when ND_MYSQL_HOST is set and sqlite otherwise.
+func (d *Database) fromEnv() {
+	setFromEnv(&d.Driver, "ND_DB_DRIVER")
+	setFromEnv(&d.DSN, "ND_DB_DSN")
+	if d.Driver == "" {
+		d.Driver = SQLite
+		if os.Getenv("ND_MYSQL_HOST") != "" {
+			d.Driver = MySQL
+		}
+	}
+
+	switch d.Driver {
+	case SQLite:
+		setFromEnv(&d.Path, "ND_SQLITE_PATH")
+	case MySQL:
+		d.fromServerEnv("ND_MYSQL_")
+	case Postgres:
+		d.fromServerEnv("ND_POSTGRES_")
+	}
+}
+
+// databaseEnv tells if any ND_* variable selects or configures the database
+func databaseEnv() bool {
+	for _, env := range os.Environ() {
+		for _, prefix := range []string{"ND_DB_", "ND_SQLITE_", "ND_MYSQL_", "ND_POSTGRES_"} {
+			if strings.HasPrefix(env, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *Database) fromServerEnv(prefix string) {
+	setFromEnv(&d.Host, prefix+"HOST")
+	setFromEnv(&d.Port, prefix+"PORT")
+	setFromEnv(&d.User, prefix+"USER")
+	setFromEnv(&d.Password, prefix+"PASSWORD")
+	setFromEnv(&d.Name, prefix+"DATABASE")
+	setFromEnv(&d.Params, prefix+"PARAMS")
+}
+
+func setFromEnv(value *string, key string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*value = v
+	}
+}
+
+func (d *Database) validate() error {
+	switch d.Driver {
+	case SQLite, MySQL, Postgres:
+		return nil
+	}
+	return fmt.Errorf("config: unsupported database driver %q", d.Driver)
+}
+
+// DataSource returns the dsn handed to the driver
+func (d *Database) DataSource() string {
+	if d.DSN != "" {
+		return d.DSN
+	}
+	switch d.Driver {
+	case MySQL:
+		params := d.Params
+		if params == "" {
+			params = "charset=utf8mb4&collation=utf8mb4_unicode_ci&parseTime=True&loc=Local"
+		}
+		return fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?%s", d.User, d.Password, orDefault(d.Host, "localhost"), orDefault(d.Port, "3306"), d.Name, params)
+	case Postgres:
+		dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s", orDefault(d.Host, "localhost"), orDefault(d.Port, "5432"), d.User, d.Password, d.Name)
+		if d.Params != "" {
+			dsn += " " + d.Params
+		}
+		return dsn
+	default:
+		params := d.Params
+		if params == "" {
+			// let the concurrent workers wait for each other's writes
+			params = "_busy_timeout=10000&_journal_mode=WAL"
+		}
+		return orDefault(d.Path, DefaultSQLitePath) + "?" + params
+	}
+}
+
+// passwords matches the passwords of the mysql and postgres dsns
+var passwords = regexp.MustCompile(`(:)[^:@/]*(@)|(password=)\S*`)
+
+// String returns the driver and the dsn of the database, its password left
+// out
+func (d *Database) String() string {
+	return d.Driver + " " + passwords.ReplaceAllString(d.DataSource(), "$1$3***$2")
+}
+
+// Open connects to the configured database, logging which one. The directory
+// of a sqlite database is created when missing.
+func (d *Database) Open() (*gorm.DB, error) {
+	log.Infoln("database:", d)
+	if d.Driver == SQLite && d.DSN == "" {
+		if err := os.MkdirAll(filepath.Dir(orDefault(d.Path, DefaultSQLitePath)), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return gorm.Open(d.Driver, d.DataSource())
+}
+
+func orDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
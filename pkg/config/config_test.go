@@ -0,0 +1,150 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setenv sets the environment variables for the duration of a test
+func setenv(t *testing.T, env map[string]string) func() {
+	for key, value := range env {
+		if err := os.Setenv(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for key := range env {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestLoad_defaultsToSQLite(t *testing.T) {
+	cfg, err := Load(filepath.Join("testdata", "missing.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Driver != SQLite {
+		t.Errorf("expected %s, got %s", SQLite, cfg.Database.Driver)
+	}
+	if dsn := cfg.Database.DataSource(); dsn != DefaultSQLitePath+"?_busy_timeout=10000&_journal_mode=WAL" {
+		t.Errorf("unexpected dsn %s", dsn)
+	}
+}
+
+func TestLoad_envOverridesFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	data := `{"database": {"driver": "mysql", "host": "db", "user": "dmoz", "password": "secret", "name": "dataset_dmoz"}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	defer setenv(t, map[string]string{"ND_MYSQL_HOST": "mysql", "ND_MYSQL_PORT": "3307"})()
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "dmoz:secret@tcp(mysql:3307)/dataset_dmoz?charset=utf8mb4&collation=utf8mb4_unicode_ci&parseTime=True&loc=Local"
+	if dsn := cfg.Database.DataSource(); dsn != expected {
+		t.Errorf("expected %s, got %s", expected, dsn)
+	}
+}
+
+func TestLoad_mysqlFromEnv(t *testing.T) {
+	defer setenv(t, map[string]string{"ND_MYSQL_HOST": "mysql", "ND_MYSQL_DATABASE": "dataset_dmoz"})()
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Database.Driver != MySQL || cfg.Database.Name != "dataset_dmoz" {
+		t.Errorf("expected the mysql database from the environment, got %+v", cfg.Database)
+	}
+}
+
+func TestLoad_postgres(t *testing.T) {
+	defer setenv(t, map[string]string{"ND_DB_DRIVER": "postgres", "ND_POSTGRES_USER": "dmoz", "ND_POSTGRES_DATABASE": "dmoz", "ND_POSTGRES_PARAMS": "sslmode=disable"})()
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "host=localhost port=5432 user=dmoz password= dbname=dmoz sslmode=disable"
+	if dsn := cfg.Database.DataSource(); dsn != expected {
+		t.Errorf("expected %s, got %s", expected, dsn)
+	}
+}
+
+func TestDatabase_String(t *testing.T) {
+	tests := map[string]Database{
+		"mysql secret:***@tcp(mysql:3306)/dmoz?parseTime=True":                 {Driver: MySQL, Host: "mysql", User: "secret", Password: "p4ss", Name: "dmoz", Params: "parseTime=True"},
+		"postgres host=localhost port=5432 user=dmoz password=*** dbname=dmoz": {Driver: Postgres, User: "dmoz", Password: "p4ss", Name: "dmoz"},
+		"sqlite3 ./data/dmoz.db?_busy_timeout=10000&_journal_mode=WAL":         {Driver: SQLite},
+		"mysql dmoz:***@unix(/var/run/mysqld/mysqld.sock)/dmoz":                {Driver: MySQL, DSN: "dmoz:p4ss@unix(/var/run/mysqld/mysqld.sock)/dmoz"},
+	}
+	for expected, d := range tests {
+		if s := d.String(); s != expected {
+			t.Errorf("expected %s, got %s", expected, s)
+		}
+	}
+}
+
+func TestLoad_unsupportedDriver(t *testing.T) {
+	defer setenv(t, map[string]string{"ND_DB_DRIVER": "oracle"})()
+	if _, err := Load(""); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestDatabase_OpenSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := &Database{Driver: SQLite, Path: filepath.Join(dir, "data", "dmoz.db")}
+	db, err := d.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := db.DB().Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad_queue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	data := `{"queue": {"visibility_timeout": "5m", "max_retries": 5}}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Queue.Path != DefaultQueuePath || cfg.Queue.VisibilityTimeout.Duration != 5*time.Minute || cfg.Queue.MaxRetries != 5 {
+		t.Errorf("unexpected queue settings %+v", cfg.Queue)
+	}
+
+	defer setenv(t, map[string]string{"ND_QUEUE_PATH": "/tmp/queue.db"})()
+	if cfg, err = Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Queue.Path != "/tmp/queue.db" {
+		t.Errorf("expected ND_QUEUE_PATH to override the path, got %s", cfg.Queue.Path)
+	}
+}
@@ -0,0 +1,96 @@
+package ccsv
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/writer"
+)
+
+// ParquetRowGroupSize is the amount of encoded data buffered before a row
+// group is written out
+const ParquetRowGroupSize = 32 * 1024 * 1024
+
+// ParquetRow is the schema of the parquet files, one row per dmoz listing
+type ParquetRow struct {
+	Link           string   `parquet:"name=link, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Title          string   `parquet:"name=title, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Description    string   `parquet:"name=description, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Path           string   `parquet:"name=path, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PathFull       string   `parquet:"name=path_full, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	PathParent     string   `parquet:"name=path_parent, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	Language       string   `parquet:"name=language, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LangScript     string   `parquet:"name=lang_script, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LangIso6391    string   `parquet:"name=lang_iso6391, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LangIso6393    string   `parquet:"name=lang_iso6393, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
+	LangConfidence float64  `parquet:"name=lang_confidence, type=DOUBLE"`
+	TextExtract    string   `parquet:"name=text_extract, type=BYTE_ARRAY, convertedtype=UTF8"`
+	ArticleText    string   `parquet:"name=article_text, type=BYTE_ARRAY, convertedtype=UTF8"`
+	Tech           []string `parquet:"name=tech, type=LIST, valuetype=BYTE_ARRAY, valueconvertedtype=UTF8"`
+}
+
+// ParquetWriter holds pointers to a Mutex, a parquet writer and the
+// underlying file. Rows are encoded by columns and written out one row group
+// at a time, so only the current row group is kept in memory.
+type ParquetWriter struct {
+	mutex  *sync.Mutex
+	pw     *writer.ParquetWriter
+	closer io.Closer
+}
+
+// NewParquetWriter creates a parquet file and returns a ParquetWriter
+func NewParquetWriter(fileName string) (*ParquetWriter, error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewParquetStream(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewParquetStream returns a ParquetWriter writing to w. Closing it closes
+// w when w is an io.Closer.
+func NewParquetStream(w io.Writer) (*ParquetWriter, error) {
+	pw, err := writer.NewParquetWriterFromWriter(w, new(ParquetRow), 1)
+	if err != nil {
+		return nil, err
+	}
+	pw.RowGroupSize = ParquetRowGroupSize
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	closer, _ := w.(io.Closer)
+	return &ParquetWriter{pw: pw, mutex: &sync.Mutex{}, closer: closer}, nil
+}
+
+// Write a single row to a parquet file
+func (w *ParquetWriter) Write(row *ParquetRow) error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pw.Write(row)
+}
+
+// Flush writes the pending rows as a row group
+func (w *ParquetWriter) Flush() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.pw.Flush(true)
+}
+
+// Close parquet file for writing
+// Implicitly writes the last row group and the footer before
+func (w *ParquetWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	err := w.pw.WriteStop()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+// Package export streams the dmoz listings out of the database into local
+// tsv or jsonl files, optionally gzipped, or parquet files.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+)
+
+// Row is an exported dmoz listing
+type Row struct {
+	Link           string  `json:"link"`
+	Title          string  `json:"title"`
+	Description    string  `json:"description"`
+	Path           string  `json:"path"`
+	Language       string  `json:"language"`
+	LangScript     string  `json:"lang_script"`
+	LangIso6391    string  `json:"lang_iso6391"`
+	LangIso6393    string  `json:"lang_iso6393"`
+	LangConfidence float64 `json:"lang_confidence"`
+	PathFull       string  `json:"path_full"`
+	PathParent     string  `json:"path_parent"`
+	// TextExtract, ArticleText and Tech come from the crawled website and
+	// are only filled when exporting with Filter.Content
+	TextExtract string   `json:"text_extract,omitempty"`
+	ArticleText string   `json:"article_text,omitempty"`
+	Tech        []string `json:"tech,omitempty"`
+}
+
+// Columns are the dmozs columns exported, in the order of Row
+var Columns = []string{"link", "title", "description", "path", "language", "lang_script", "lang_iso6391", "lang_iso6393", "lang_confidence", "path_full", "path_parent"}
+
+// contentColumns are the websites columns exported along with Columns when
+// Filter.Content is set
+var contentColumns = []string{"text_extract", "article_text", "wap"}
+
+// Filter selects the listings to export, zero values disabling a criteria.
+// StatusCode and Alive apply to the crawled website of the listing. Content
+// also exports the text and technologies of the crawled website, when any.
+type Filter struct {
+	Languages     []string
+	MinConfidence float64
+	PathPrefix    string
+	StatusCode    int
+	Alive         bool
+	Content       bool
+}
+
+// Query returns the rows of the listings matching f
+func Query(db *gorm.DB, f Filter) *gorm.DB {
+	selected := make([]string, len(Columns))
+	for i, column := range Columns {
+		empty := "''"
+		if column == "lang_confidence" {
+			empty = "0"
+		}
+		selected[i] = fmt.Sprintf("COALESCE(d.%s, %s)", column, empty)
+	}
+	if f.Content {
+		for _, column := range contentColumns {
+			selected = append(selected, fmt.Sprintf("COALESCE(w.%s, '')", column))
+		}
+	}
+	query := db.Table(db.NewScope(&models.Dmoz{}).TableName() + " d").Select(selected)
+	websites := db.NewScope(&models.Website{}).TableName()
+	if f.StatusCode != 0 || f.Alive {
+		query = query.Joins("JOIN " + websites + " w ON w.link = d.link")
+	} else if f.Content {
+		query = query.Joins("LEFT JOIN " + websites + " w ON w.link = d.link")
+	}
+	if len(f.Languages) > 0 {
+		query = query.Where("d.language IN (?)", f.Languages)
+	}
+	if f.MinConfidence > 0 {
+		query = query.Where("d.lang_confidence >= ?", f.MinConfidence)
+	}
+	if f.PathPrefix != "" {
+		// LIKE would treat the underscores of the paths as wildcards
+		query = query.Where("SUBSTR(d.path_full, 1, ?) = ?", len(f.PathPrefix), f.PathPrefix)
+	}
+	if f.StatusCode != 0 {
+		query = query.Where("w.status_code = ?", f.StatusCode)
+	}
+	if f.Alive {
+		query = query.Where("w.alive = ?", true)
+	}
+	return query.Where("d.deleted_at IS NULL").Order("d.id")
+}
+
+// Export writes the listings matching f to w and returns how many were
+// written
+func Export(db *gorm.DB, f Filter, w Writer) (int64, error) {
+	rows, err := Query(db, f).Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	var count int64
+	for rows.Next() {
+		var r Row
+		var wap string
+		dest := []interface{}{&r.Link, &r.Title, &r.Description, &r.Path, &r.Language, &r.LangScript, &r.LangIso6391, &r.LangIso6393, &r.LangConfidence, &r.PathFull, &r.PathParent}
+		if f.Content {
+			dest = append(dest, &r.TextExtract, &r.ArticleText, &wap)
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return count, err
+		}
+		r.Tech = Technologies(wap)
+		if err := w.Write(&r); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, rows.Err()
+}
+
+// Technologies returns the names of the applications found by wappalyzer,
+// as stored in Website.Wap, sorted by name. Unparsable results are ignored.
+func Technologies(wap string) []string {
+	if wap == "" {
+		return nil
+	}
+	var apps []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(wap), &apps); err != nil {
+		return nil
+	}
+	var names []string
+	for _, app := range apps {
+		if app.Name != "" {
+			names = append(names, app.Name)
+		}
+	}
+	// wappalyzer reports the applications in no particular order
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,224 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+	"github.com/xitongsys/parquet-go-source/buffer"
+	"github.com/xitongsys/parquet-go/reader"
+
+	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+)
+
+func openTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	listings := []models.Dmoz{
+		{Link: "http://a.example.com/", Title: "a", Language: "English", LangConfidence: 0.9, PathFull: "Top/Arts/Visual_Arts"},
+		{Link: "http://b.example.com/", Title: "b", Language: "French", LangConfidence: 0.95, PathFull: "Top/Arts/Visual_Arts/Painting"},
+		{Link: "http://c.example.com/", Title: "c", Language: "English", LangConfidence: 0.5, PathFull: "Top/Arts"},
+		{Link: "http://d.example.com/", Title: "d", Language: "English", LangConfidence: 0.99, PathFull: "Top/ArtsXVisual_Arts"},
+	}
+	for i := range listings {
+		if err := db.Create(&listings[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	websites := []models.Website{
+		{Link: "http://a.example.com/", Alive: true, StatusCode: 200, TextExtract: "a\tb\nc", Wap: `[{"name":"Nginx","version":""},{"name":"jQuery","version":"3.5"}]`},
+		{Link: "http://b.example.com/", Alive: false, StatusCode: 404},
+	}
+	for i := range websites {
+		if err := db.Create(&websites[i]).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func links(t *testing.T, db *gorm.DB, f Filter) string {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, TSV, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := Export(db, f, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var result []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line != "" {
+			result = append(result, strings.SplitN(line, "\t", 2)[0])
+		}
+	}
+	if int(count) != len(result) {
+		t.Errorf("reported %d rows, wrote %d", count, len(result))
+	}
+	return strings.Join(result, " ")
+}
+
+func TestExport_filters(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	tests := []struct {
+		filter   Filter
+		expected string
+	}{
+		{Filter{}, "http://a.example.com/ http://b.example.com/ http://c.example.com/ http://d.example.com/"},
+		{Filter{Languages: []string{"English"}, MinConfidence: 0.8}, "http://a.example.com/ http://d.example.com/"},
+		{Filter{PathPrefix: "Top/Arts/Visual_Arts"}, "http://a.example.com/ http://b.example.com/"},
+		{Filter{StatusCode: 404}, "http://b.example.com/"},
+		{Filter{Alive: true}, "http://a.example.com/"},
+	}
+	for _, test := range tests {
+		if got := links(t, db, test.filter); got != test.expected {
+			t.Errorf("%+v: expected %q, got %q", test.filter, test.expected, got)
+		}
+	}
+}
+
+func TestExport_jsonlGzip(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, JSONL, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Export(db, Filter{Alive: true}, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"link":"http://a.example.com/","title":"a","description":"","path":"","language":"English","lang_script":"","lang_iso6391":"","lang_iso6393":"","lang_confidence":0.9,"path_full":"Top/Arts/Visual_Arts","path_parent":""}` + "\n"
+	if string(data) != expected {
+		t.Errorf("expected %s, got %s", expected, data)
+	}
+}
+
+func TestExport_parquet(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, Parquet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := Export(db, Filter{Content: true}, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := buffer.NewBufferFile(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr, err := reader.NewParquetReader(file, new(ccsv.ParquetRow), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.ReadStop()
+	if pr.GetNumRows() != count || count != 4 {
+		t.Fatalf("expected 4 rows, exported %d and read %d", count, pr.GetNumRows())
+	}
+	rows := make([]ccsv.ParquetRow, count)
+	if err := pr.Read(&rows); err != nil {
+		t.Fatal(err)
+	}
+
+	a := rows[0]
+	if a.Link != "http://a.example.com/" || a.PathFull != "Top/Arts/Visual_Arts" || a.LangConfidence != 0.9 {
+		t.Errorf("unexpected listing %+v", a)
+	}
+	if a.TextExtract != "a\tb\nc" {
+		t.Errorf("expected the text to keep its tabs and newlines, got %q", a.TextExtract)
+	}
+	if strings.Join(a.Tech, ",") != "Nginx,jQuery" {
+		t.Errorf("expected Nginx,jQuery, got %v", a.Tech)
+	}
+	if rows[2].Link != "http://c.example.com/" || rows[2].TextExtract != "" || len(rows[2].Tech) != 0 {
+		t.Errorf("expected a listing without website, got %+v", rows[2])
+	}
+}
+
+func TestRead_tsv(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, TSV, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Export(db, Filter{}, w); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []Row
+	err = Read(&buf, TSV, true, func(r *Row) error {
+		rows = append(rows, *r)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("expected 4 rows, got %d", len(rows))
+	}
+	if rows[1].Link != "http://b.example.com/" || rows[1].Language != "French" || rows[1].LangConfidence != 0.95 || rows[1].PathFull != "Top/Arts/Visual_Arts/Painting" {
+		t.Errorf("unexpected row %+v", rows[1])
+	}
+}
+
+func TestFormatOf(t *testing.T) {
+	tests := map[string]struct {
+		format   string
+		compress bool
+	}{
+		"dmoz.csv":      {TSV, false},
+		"dmoz.tsv.gz":   {TSV, true},
+		"dmoz.jsonl":    {JSONL, false},
+		"dmoz.jsonl.gz": {JSONL, true},
+		"dmoz.parquet":  {Parquet, false},
+	}
+	for path, expected := range tests {
+		format, compress := FormatOf(path)
+		if format != expected.format || compress != expected.compress {
+			t.Errorf("%s: expected %s %v, got %s %v", path, expected.format, expected.compress, format, compress)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package export
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+
+	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+)
+
+// parquetBatch is the number of rows read at once from parquet files
+const parquetBatch = 1000
+
+// ReadFile calls fn with every row of a file written by Create
+func ReadFile(path string, format string, compress bool, fn func(r *Row) error) error {
+	if format == Parquet {
+		if compress {
+			return errParquetGzip
+		}
+		return readParquet(path, fn)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return Read(file, format, compress, fn)
+}
+
+// Read calls fn with every row read from r, encoded in format and gzipped
+// when compress is set
+func Read(r io.Reader, format string, compress bool, fn func(r *Row) error) error {
+	if compress {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	switch format {
+	case TSV:
+		return readTSV(r, fn)
+	case JSONL:
+		decoder := json.NewDecoder(r)
+		for {
+			var row Row
+			if err := decoder.Decode(&row); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			if err := fn(&row); err != nil {
+				return err
+			}
+		}
+	case Parquet:
+		return fmt.Errorf("export: parquet files can only be read with ReadFile")
+	}
+	return fmt.Errorf("export: unknown format %q", format)
+}
+
+func readTSV(r io.Reader, fn func(r *Row) error) error {
+	tsv := csv.NewReader(r)
+	tsv.Comma = '\t'
+	tsv.FieldsPerRecord = len(Columns)
+	for {
+		record, err := tsv.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		confidence, err := strconv.ParseFloat(record[8], 64)
+		if err != nil {
+			return fmt.Errorf("export: %s: %v", Columns[8], err)
+		}
+		row := Row{
+			Link:           record[0],
+			Title:          record[1],
+			Description:    record[2],
+			Path:           record[3],
+			Language:       record[4],
+			LangScript:     record[5],
+			LangIso6391:    record[6],
+			LangIso6393:    record[7],
+			LangConfidence: confidence,
+			PathFull:       record[9],
+			PathParent:     record[10],
+		}
+		if err := fn(&row); err != nil {
+			return err
+		}
+	}
+}
+
+func readParquet(path string, fn func(r *Row) error) error {
+	file, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	pr, err := reader.NewParquetReader(file, new(ccsv.ParquetRow), 1)
+	if err != nil {
+		return err
+	}
+	defer pr.ReadStop()
+
+	for left := pr.GetNumRows(); left > 0; {
+		batch := left
+		if batch > parquetBatch {
+			batch = parquetBatch
+		}
+		rows := make([]ccsv.ParquetRow, batch)
+		if err := pr.Read(&rows); err != nil {
+			return err
+		}
+		for _, p := range rows {
+			row := Row{
+				Link:           p.Link,
+				Title:          p.Title,
+				Description:    p.Description,
+				Path:           p.Path,
+				Language:       p.Language,
+				LangScript:     p.LangScript,
+				LangIso6391:    p.LangIso6391,
+				LangIso6393:    p.LangIso6393,
+				LangConfidence: p.LangConfidence,
+				PathFull:       p.PathFull,
+				PathParent:     p.PathParent,
+				TextExtract:    p.TextExtract,
+				ArticleText:    p.ArticleText,
+				Tech:           p.Tech,
+			}
+			if err := fn(&row); err != nil {
+				return err
+			}
+		}
+		left -= batch
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package export
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+)
+
+// Output formats
+const (
+	TSV     = "tsv"
+	JSONL   = "jsonl"
+	Parquet = "parquet"
+)
+
+// Writer encodes exported rows
+type Writer interface {
+	Write(r *Row) error
+	Close() error
+}
+
+// FormatOf guesses the format and compression of a file from its
+// extension, eg. dmoz.jsonl.gz. Unknown extensions are tsv.
+func FormatOf(path string) (format string, compress bool) {
+	if strings.HasSuffix(path, ".parquet") {
+		return Parquet, false
+	}
+	if strings.HasSuffix(path, ".gz") {
+		compress = true
+		path = strings.TrimSuffix(path, ".gz")
+	}
+	if strings.HasSuffix(path, ".jsonl") || strings.HasSuffix(path, ".json") {
+		return JSONL, compress
+	}
+	return TSV, compress
+}
+
+// Create creates the file at path and returns a Writer encoding rows in
+// format, gzipped when compress is set
+func Create(path string, format string, compress bool) (Writer, error) {
+	if format != TSV && format != JSONL && format != Parquet {
+		return nil, fmt.Errorf("export: unknown format %q", format)
+	}
+	if format == Parquet && compress {
+		return nil, errParquetGzip
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(file, format, compress)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// errParquetGzip is returned when gzipping parquet files, whose columns are
+// already compressed
+var errParquetGzip = fmt.Errorf("export: parquet files can not be gzipped")
+
+// NewWriter returns a Writer encoding rows to w in format, gzipped when
+// compress is set. Closing it closes w when w is an io.Closer.
+func NewWriter(w io.Writer, format string, compress bool) (Writer, error) {
+	if format == Parquet {
+		if compress {
+			return nil, errParquetGzip
+		}
+		pw, err := ccsv.NewParquetStream(w)
+		if err != nil {
+			return nil, err
+		}
+		return &parquetWriter{pw}, nil
+	}
+	out := &writer{w: w}
+	if c, ok := w.(io.Closer); ok {
+		out.closers = append(out.closers, c)
+	}
+	if compress {
+		gz := gzip.NewWriter(w)
+		out.w = gz
+		out.closers = append([]io.Closer{gz}, out.closers...)
+	}
+	switch format {
+	case TSV:
+		out.tsv = csv.NewWriter(out.w)
+		out.tsv.Comma = '\t'
+	case JSONL:
+		out.json = json.NewEncoder(out.w)
+		out.json.SetEscapeHTML(false)
+	default:
+		return nil, fmt.Errorf("export: unknown format %q", format)
+	}
+	return out, nil
+}
+
+type writer struct {
+	w       io.Writer
+	tsv     *csv.Writer
+	json    *json.Encoder
+	closers []io.Closer
+}
+
+func (w *writer) Write(r *Row) error {
+	if w.json != nil {
+		return w.json.Encode(r)
+	}
+	return w.tsv.Write([]string{r.Link, r.Title, r.Description, r.Path, r.Language, r.LangScript, r.LangIso6391, r.LangIso6393, strconv.FormatFloat(r.LangConfidence, 'f', -1, 64), r.PathFull, r.PathParent})
+}
+
+// Close flushes the pending rows and closes the gzip stream and the file
+func (w *writer) Close() error {
+	var err error
+	if w.tsv != nil {
+		w.tsv.Flush()
+		err = w.tsv.Error()
+	}
+	for _, c := range w.closers {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// parquetWriter writes the rows as ccsv.ParquetRow, row group by row group
+type parquetWriter struct {
+	pw *ccsv.ParquetWriter
+}
+
+func (w *parquetWriter) Write(r *Row) error {
+	return w.pw.Write(&ccsv.ParquetRow{
+		Link:           r.Link,
+		Title:          r.Title,
+		Description:    r.Description,
+		Path:           r.Path,
+		PathFull:       r.PathFull,
+		PathParent:     r.PathParent,
+		Language:       r.Language,
+		LangScript:     r.LangScript,
+		LangIso6391:    r.LangIso6391,
+		LangIso6393:    r.LangIso6393,
+		LangConfidence: r.LangConfidence,
+		TextExtract:    r.TextExtract,
+		ArticleText:    r.ArticleText,
+		Tech:           r.Tech,
+	})
+}
+
+// Close writes the last row group and the footer, then closes the file
+func (w *parquetWriter) Close() error {
+	return w.pw.Close()
+}
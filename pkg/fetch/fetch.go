@@ -0,0 +1,385 @@
+// Package fetch downloads the pages of the crawlers: bodies are capped and
+// decoded to UTF-8, and the redirect chain, status, headers and timings of
+// every fetch are kept in a Response that the extractors consume.
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saintfish/chardet"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding/htmlindex"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+	"github.com/lucmichalski/dmoz-utils/pkg/warc"
+)
+
+// Defaults of a Fetcher
+const (
+	DefaultUserAgent    = "Mozilla/5.0 (compatible; dmoz-utils; +https://github.com/lucmichalski/dmoz-utils)"
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxBodySize  = 10 << 20
+	DefaultMaxRedirects = 10
+)
+
+// ErrTooManyRedirects is the error of a fetch stopped after MaxRedirects
+var ErrTooManyRedirects = errors.New("fetch: too many redirects")
+
+// Default is the Fetcher of Get
+var Default = &Fetcher{}
+
+// Hop is a redirect of a fetch, an HTTP redirect or a client side one
+type Hop struct {
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	Location   string `json:"location"`
+	Kind       string `json:"kind"`
+}
+
+// Timings of a fetch, the durations being summed over its redirects
+type Timings struct {
+	Start     time.Time     `json:"start"`
+	DNS       time.Duration `json:"dns"`
+	Connect   time.Duration `json:"connect"`
+	TLS       time.Duration `json:"tls"`
+	FirstByte time.Duration `json:"first_byte"`
+	Total     time.Duration `json:"total"`
+}
+
+// Validators identify a version of a page
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+func (v Validators) set(header http.Header) {
+	if v.ETag != "" {
+		header.Set("If-None-Match", v.ETag)
+	}
+	if v.LastModified != "" {
+		header.Set("If-Modified-Since", v.LastModified)
+	}
+}
+
+// Response is a fetched page
+type Response struct {
+	// URL is the URL requested, FinalURL the one answering after the
+	// redirects
+	URL        string
+	FinalURL   string
+	StatusCode int
+	Header     http.Header
+	Redirects  []Hop
+	// MediaType is the media type of the body, sniffed when the server
+	// does not send one
+	MediaType string
+	// Charset is the name of the encoding the body was decoded from, empty
+	// for a body left as is
+	Charset string
+	// Body is decoded to UTF-8 for text media types
+	Body []byte
+	// Truncated is set when the body was larger than MaxBodySize
+	Truncated bool
+	Timings   Timings
+}
+
+// NotModified reports whether the server answered that the page did not
+// change since the validators sent
+func (r *Response) NotModified() bool {
+	return r.StatusCode == http.StatusNotModified
+}
+
+// Validators returns the validators of the page, to revalidate it later
+func (r *Response) Validators() Validators {
+	return Validators{ETag: r.Header.Get("ETag"), LastModified: r.Header.Get("Last-Modified")}
+}
+
+// OK reports whether the page was found
+func (r *Response) OK() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// Text returns the body as a string
+func (r *Response) Text() string {
+	return string(r.Body)
+}
+
+// Reader returns a reader of the body
+func (r *Response) Reader() io.Reader {
+	return bytes.NewReader(r.Body)
+}
+
+// Fetcher downloads pages
+type Fetcher struct {
+	// Transport sends the requests, eg. through the politeness scheduler,
+	// http.DefaultTransport when nil
+	Transport http.RoundTripper
+	// UserAgent is the User-Agent header of the requests
+	UserAgent string
+	// Timeout bounds a fetch, redirects and body included
+	Timeout time.Duration
+	// MaxBodySize is the number of bytes of a body kept
+	MaxBodySize int64
+	// MaxRedirects is the number of redirects followed
+	MaxRedirects int
+	// MaxRefreshes is the number of client side redirects followed, meta
+	// refresh or script, none by default
+	MaxRefreshes int
+	// Archive, when set, gets the pages as received, but for the 304 Not
+	// Modified answers
+	Archive *warc.Writer
+	// Store, when set, replaces the network: the pages are read from it,
+	// and the ones missing fail with ErrNotStored
+	Store Store
+}
+
+// New returns a Fetcher configured by cfg, sending its requests through
+// transport
+func New(cfg config.Fetch, transport http.RoundTripper, archive *warc.Writer) *Fetcher {
+	return &Fetcher{
+		Archive:      archive,
+		Transport:    transport,
+		UserAgent:    cfg.UserAgent,
+		Timeout:      cfg.Timeout.Duration,
+		MaxBodySize:  cfg.MaxBodySize,
+		MaxRedirects: cfg.MaxRedirects,
+		MaxRefreshes: cfg.MaxRefreshes,
+	}
+}
+
+// Get fetches url with the Default fetcher
+func Get(url string) (*Response, error) {
+	return Default.Get(context.Background(), url)
+}
+
+// Get fetches url, following its redirects. Pages answering with an error
+// status are returned along with their body, only failing to get a
+// response is an error.
+func (f *Fetcher) Get(ctx context.Context, url string) (*Response, error) {
+	return f.Revalidate(ctx, url, Validators{})
+}
+
+// Revalidate is Get sending the validators of the copy of url we have, so
+// that the server answers 304 Not Modified when it did not change
+func (f *Fetcher) Revalidate(ctx context.Context, url string, validators Validators) (*Response, error) {
+	start := time.Now()
+	link := url
+	var hops []Hop
+	seen := map[string]bool{url: true}
+	for refreshes := 0; ; refreshes++ {
+		header := http.Header{}
+		if refreshes == 0 {
+			validators.set(header)
+		}
+		var response *Response
+		var err error
+		if f.Store != nil {
+			response, err = f.load(url)
+		} else {
+			response, err = f.get(ctx, link, url, header)
+		}
+		if err != nil {
+			return nil, err
+		}
+		response.Redirects = append(hops, response.Redirects...)
+
+		target, kind := "", ""
+		if refreshes < f.MaxRefreshes && response.OK() && strings.Contains(response.MediaType, "html") {
+			target, kind = ClientRedirect(response.Body, response.FinalURL)
+		}
+		if target == "" || seen[target] {
+			if len(hops) > 0 {
+				response.URL = hops[0].URL
+			}
+			response.Timings.Start = start
+			response.Timings.Total = time.Since(start)
+			return response, nil
+		}
+		seen[target] = true
+		hops = append(response.Redirects, Hop{URL: response.FinalURL, StatusCode: response.StatusCode, Location: target, Kind: kind})
+		url = target
+	}
+}
+
+// get fetches url, following its HTTP redirects, for a fetch started from
+// link
+func (f *Fetcher) get(ctx context.Context, link, url string, header http.Header) (*Response, error) {
+	timeout := f.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	response := &Response{URL: url, Timings: Timings{Start: time.Now()}}
+	ctx = httptrace.WithClientTrace(ctx, response.trace())
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := f.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	for key := range header {
+		req.Header.Set(key, header.Get(key))
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := f.client(response).Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response.FinalURL = resp.Request.URL.String()
+	response.StatusCode = resp.StatusCode
+	response.Header = resp.Header
+
+	maxBodySize := f.maxBodySize()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBodySize {
+		body = body[:maxBodySize]
+		response.Truncated = true
+	}
+	if f.Archive != nil && !response.NotModified() {
+		err := f.Archive.Write(&warc.Exchange{
+			Link:          link,
+			Method:        req.Method,
+			URL:           response.FinalURL,
+			RequestHeader: resp.Request.Header,
+			StatusCode:    resp.StatusCode,
+			Header:        resp.Header,
+			Body:          body,
+			Truncated:     response.Truncated,
+			Date:          response.Timings.Start,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	response.decode(body)
+	response.Timings.Total = time.Since(response.Timings.Start)
+	return response, nil
+}
+
+func (f *Fetcher) maxBodySize() int64 {
+	if f.MaxBodySize <= 0 {
+		return DefaultMaxBodySize
+	}
+	return f.MaxBodySize
+}
+
+// client records the redirects in response
+func (f *Fetcher) client(response *Response) *http.Client {
+	maxRedirects := f.MaxRedirects
+	if maxRedirects <= 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+	return &http.Client{
+		Transport: f.Transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			hop := Hop{URL: via[len(via)-1].URL.String(), Location: req.URL.String(), Kind: HTTPRedirect}
+			if req.Response != nil {
+				hop.StatusCode = req.Response.StatusCode
+			}
+			response.Redirects = append(response.Redirects, hop)
+			if len(via) > maxRedirects {
+				return ErrTooManyRedirects
+			}
+			// the User-Agent is carried over, see net/http
+			return nil
+		},
+	}
+}
+
+// trace sums the timings of the requests of a fetch
+func (r *Response) trace() *httptrace.ClientTrace {
+	var mutex sync.Mutex
+	var dns, connect, handshake, wrote time.Time
+	add := func(d *time.Duration, since time.Time) {
+		mutex.Lock()
+		if !since.IsZero() {
+			*d += time.Since(since)
+		}
+		mutex.Unlock()
+	}
+	set := func(t *time.Time) {
+		mutex.Lock()
+		*t = time.Now()
+		mutex.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { set(&dns) },
+		DNSDone:              func(httptrace.DNSDoneInfo) { add(&r.Timings.DNS, dns) },
+		ConnectStart:         func(string, string) { set(&connect) },
+		ConnectDone:          func(string, string, error) { add(&r.Timings.Connect, connect) },
+		TLSHandshakeStart:    func() { set(&handshake) },
+		TLSHandshakeDone:     func(tls.ConnectionState, error) { add(&r.Timings.TLS, handshake) },
+		WroteRequest:         func(httptrace.WroteRequestInfo) { set(&wrote) },
+		GotFirstResponseByte: func() { add(&r.Timings.FirstByte, wrote) },
+	}
+}
+
+// decode converts a text body to UTF-8, from the charset of Content-Type,
+// of a <meta> tag or of a byte order mark, and sniffs it without any
+func (r *Response) decode(body []byte) {
+	contentType := r.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+	}
+	r.MediaType, _, _ = mime.ParseMediaType(contentType)
+	if !isText(r.MediaType) {
+		r.Body = body
+		return
+	}
+
+	encoding, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && name == "windows-1252" {
+		// not valid UTF-8, and nothing tells which encoding it is
+		if result, err := chardet.NewHtmlDetector().DetectBest(body); err == nil {
+			if detected, err := htmlindex.Get(result.Charset); err == nil {
+				encoding = detected
+				name, _ = htmlindex.Name(detected)
+			}
+		}
+	}
+	r.Charset = name
+	if name == "utf-8" {
+		r.Body = body
+		return
+	}
+	decoded, err := encoding.NewDecoder().Bytes(body)
+	if err != nil {
+		r.Body = body
+		return
+	}
+	r.Body = decoded
+}
+
+func isText(mediaType string) bool {
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+xml"),
+		strings.HasSuffix(mediaType, "/xml"),
+		strings.HasSuffix(mediaType, "/json"),
+		strings.HasSuffix(mediaType, "/javascript"):
+		return true
+	}
+	return false
+}
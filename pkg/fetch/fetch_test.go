@@ -0,0 +1,285 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/warc"
+)
+
+func TestFetcher_charset(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/header":
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			w.Write([]byte("<html><p>caf\xe9</p></html>"))
+		case "/meta":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><head><meta charset="windows-1251"></head><p>` + "\xcf\xf0\xe8\xe2\xe5\xf2" + `</p></html>`))
+		case "/utf8":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><p>café</p></html>"))
+		case "/binary":
+			w.Header().Set("Content-Type", "application/x-gzip")
+			w.Write([]byte("\x1f\x8b\xe9"))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path, charset, contains string
+	}{
+		{"/header", "windows-1252", "café"},
+		{"/meta", "windows-1251", "Привет"},
+		{"/utf8", "utf-8", "café"},
+		{"/binary", "", "\x1f\x8b\xe9"},
+	}
+	f := &Fetcher{}
+	for _, test := range tests {
+		resp, err := f.Get(context.Background(), server.URL+test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Charset != test.charset {
+			t.Errorf("%s: expected the charset %q, got %q", test.path, test.charset, resp.Charset)
+		}
+		if !strings.Contains(resp.Text(), test.contains) {
+			t.Errorf("%s: expected %q in %q", test.path, test.contains, resp.Text())
+		}
+	}
+}
+
+func TestFetcher_redirects(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+		case "/moved":
+			http.Redirect(w, r, server.URL+"/new", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/new":
+			if r.UserAgent() != "test" {
+				t.Errorf("expected the User-Agent to be kept, got %q", r.UserAgent())
+			}
+			w.Header().Set("X-Test", "yes")
+			w.Write([]byte(strings.Repeat("a", 100)))
+		}
+	}))
+	defer server.Close()
+
+	f := &Fetcher{UserAgent: "test", MaxBodySize: 10, MaxRedirects: 3}
+	resp, err := f.Get(context.Background(), server.URL+"/old")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.FinalURL != server.URL+"/new" || resp.StatusCode != http.StatusOK || resp.Header.Get("X-Test") != "yes" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	expected := []Hop{
+		{server.URL + "/old", http.StatusMovedPermanently, server.URL + "/moved", HTTPRedirect},
+		{server.URL + "/moved", http.StatusFound, server.URL + "/new", HTTPRedirect},
+	}
+	if len(resp.Redirects) != 2 || resp.Redirects[0] != expected[0] || resp.Redirects[1] != expected[1] {
+		t.Errorf("expected the redirects %v, got %v", expected, resp.Redirects)
+	}
+	if len(resp.Body) != 10 || !resp.Truncated {
+		t.Errorf("expected the body to be truncated to 10 bytes, got %d", len(resp.Body))
+	}
+	if resp.Timings.Total <= 0 || resp.Timings.FirstByte <= 0 {
+		t.Errorf("expected timings, got %+v", resp.Timings)
+	}
+
+	if _, err := f.Get(context.Background(), server.URL+"/loop"); !errors.Is(err, ErrTooManyRedirects) {
+		t.Errorf("expected too many redirects, got %v", err)
+	}
+}
+
+func TestFetcher_refresh(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<meta http-equiv="refresh" content="0; url=/js">`))
+		case "/js":
+			w.Write([]byte(`<script>window.location.href = "/final";</script>`))
+		case "/final":
+			w.Write([]byte(`<title>final</title>`))
+		}
+	}))
+	defer server.Close()
+
+	resp, err := (&Fetcher{MaxRefreshes: 2}).Get(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Hop{
+		{server.URL + "/", http.StatusOK, server.URL + "/js", MetaRefresh},
+		{server.URL + "/js", http.StatusOK, server.URL + "/final", ScriptRedirect},
+	}
+	if resp.URL != server.URL+"/" || resp.FinalURL != server.URL+"/final" || len(resp.Redirects) != 2 || resp.Redirects[0] != expected[0] || resp.Redirects[1] != expected[1] {
+		t.Errorf("expected %v to /final, got %s %v", expected, resp.FinalURL, resp.Redirects)
+	}
+
+	resp, err = (&Fetcher{}).Get(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.FinalURL != server.URL+"/" || len(resp.Redirects) != 0 {
+		t.Errorf("expected the refreshes not to be followed by default, got %s", resp.FinalURL)
+	}
+}
+
+func TestFetcher_revalidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Wed, 01 Jan 2020 00:00:00 GMT")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("page"))
+	}))
+	defer server.Close()
+
+	f := &Fetcher{}
+	resp, err := f.Get(context.Background(), server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validators := resp.Validators()
+	if resp.NotModified() || validators.ETag != `"v1"` || validators.LastModified != "Wed, 01 Jan 2020 00:00:00 GMT" {
+		t.Fatalf("expected the page and its validators, got %d %+v", resp.StatusCode, validators)
+	}
+	resp, err = f.Revalidate(context.Background(), server.URL, validators)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.NotModified() || len(resp.Body) != 0 {
+		t.Errorf("expected 304 Not Modified, got %d", resp.StatusCode)
+	}
+}
+
+func TestFetcher_archive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			http.Redirect(w, r, "/latin1", http.StatusFound)
+		case "/latin1":
+			w.Header().Set("Content-Type", "text/html; charset=ISO-8859-1")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("<p>caf\xe9</p>"))
+		}
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := &Fetcher{Archive: &warc.Writer{Dir: dir}}
+	resp, err := f.Get(context.Background(), server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Revalidate(context.Background(), server.URL+"/", resp.Validators()); err != nil {
+		t.Fatal(err)
+	}
+	f.Archive.Close()
+
+	a, err := warc.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	archived, err := a.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archived.Body.Close()
+	body, _ := ioutil.ReadAll(archived.Body)
+	// the body is archived as received, before decoding
+	if archived.StatusCode != http.StatusOK || string(body) != "<p>caf\xe9</p>" || archived.Request.URL.String() != server.URL+"/latin1" {
+		t.Errorf("expected the latin1 page, got %d %q from %s", archived.StatusCode, body, archived.Request.URL)
+	}
+}
+
+func TestFetcher_store(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	archive := &warc.Writer{Dir: dir}
+	err = archive.Write(&warc.Exchange{
+		Link:       "http://example.com/",
+		URL:        "https://www.example.com/",
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=ISO-8859-1"}},
+		Body:       []byte("<p>caf\xe9</p>"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive.Close()
+	a, err := warc.Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	f := &Fetcher{Store: a}
+	resp, err := f.Get(context.Background(), "http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.URL != "http://example.com/" || resp.FinalURL != "https://www.example.com/" || len(resp.Redirects) != 1 || resp.Text() != "<p>café</p>" {
+		t.Errorf("expected the archived page decoded, got %s %v %q", resp.FinalURL, resp.Redirects, resp.Text())
+	}
+	if _, err := f.Get(context.Background(), "http://example.org/"); !errors.Is(err, ErrNotStored) {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+
+	// a colly cache entry
+	link := "http://example.org/cached"
+	sum := sha1.Sum([]byte(link))
+	hash := hex.EncodeToString(sum[:])
+	if err := os.MkdirAll(filepath.Join(dir, hash[:2]), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Create(filepath.Join(dir, hash[:2], hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gob.NewEncoder(file).Encode(&cached{StatusCode: http.StatusOK, Body: []byte("<title>cached</title>"), Headers: &http.Header{"Content-Type": {"text/html"}}})
+	file.Close()
+
+	f.Store = CacheDir(dir)
+	resp, err = f.Get(context.Background(), link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.FinalURL != link || resp.MediaType != "text/html" || resp.Text() != "<title>cached</title>" {
+		t.Errorf("expected the cached page, got %s %q", resp.MediaType, resp.Text())
+	}
+	if _, err := f.Get(context.Background(), "http://example.org/"); !errors.Is(err, ErrNotStored) {
+		t.Errorf("expected ErrNotStored, got %v", err)
+	}
+}
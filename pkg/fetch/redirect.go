@@ -0,0 +1,93 @@
+package fetch
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/tldparser"
+)
+
+// Kinds of Hop
+const (
+	HTTPRedirect   = "http"
+	MetaRefresh    = "meta"
+	ScriptRedirect = "js"
+)
+
+var (
+	metaTag      = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	httpEquiv    = regexp.MustCompile(`(?is)http-equiv\s*=\s*["']?\s*refresh\b`)
+	metaContent  = regexp.MustCompile(`(?is)content\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+	refreshURL   = regexp.MustCompile(`(?is)^\s*[\d.]*\s*(?:[;,]\s*(?:url\s*=\s*)?|url\s*=\s*)["']?([^"']+)`)
+	scriptTag    = regexp.MustCompile(`(?is)<script[^>]*>(.*?)</script>`)
+	locationURL  = regexp.MustCompile(`(?is)(?:^|[^\w.])(?:(?:window|document|top|self)\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']`)
+	locationCall = regexp.MustCompile(`(?is)(?:^|[^\w.])(?:(?:window|document|top|self)\.)?location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+)
+
+// ClientRedirect returns the URL a page sends the browser to with a meta
+// refresh or a script assigning its location, resolved against base, and
+// the kind of the redirect. It returns an empty URL when there is none.
+func ClientRedirect(body []byte, base string) (string, string) {
+	page := string(body)
+	for _, tag := range metaTag.FindAllString(page, -1) {
+		if !httpEquiv.MatchString(tag) {
+			continue
+		}
+		content := metaContent.FindStringSubmatch(tag)
+		if content == nil {
+			continue
+		}
+		// a refresh without URL reloads the page
+		value := html.UnescapeString(content[1] + content[2] + content[3])
+		if match := refreshURL.FindStringSubmatch(value); match != nil {
+			if target := resolve(base, match[1]); target != "" {
+				return target, MetaRefresh
+			}
+		}
+	}
+	for _, script := range scriptTag.FindAllStringSubmatch(page, -1) {
+		for _, pattern := range []*regexp.Regexp{locationURL, locationCall} {
+			if match := pattern.FindStringSubmatch(script[1]); match != nil {
+				if target := resolve(base, match[1]); target != "" {
+					return target, ScriptRedirect
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// resolve returns ref as an absolute http(s) URL, empty for the other
+// schemes, eg. javascript:
+func resolve(base, ref string) string {
+	b, err := url.Parse(base)
+	if err != nil {
+		return ""
+	}
+	r, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ""
+	}
+	u := b.ResolveReference(r)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// DomainChanged reports whether the registered domains of two URLs differ,
+// as for a site moved to another domain, unlike a move to https or to www
+func DomainChanged(from, to string) bool {
+	f, err := url.Parse(from)
+	if err != nil {
+		return false
+	}
+	t, err := url.Parse(to)
+	if err != nil || t.Host == "" {
+		return false
+	}
+	return tldparser.RegisteredDomain(f.Hostname()) != tldparser.RegisteredDomain(t.Hostname())
+}
@@ -0,0 +1,43 @@
+package fetch
+
+import "testing"
+
+func TestClientRedirect(t *testing.T) {
+	tests := []struct {
+		body, target, kind string
+	}{
+		{`<meta http-equiv="refresh" content="0; URL='http://example.org/new'">`, "http://example.org/new", MetaRefresh},
+		{`<META CONTENT="5;url=/moved" HTTP-EQUIV="Refresh">`, "http://example.com/moved", MetaRefresh},
+		{`<meta http-equiv=refresh content="0;url=new.html">`, "http://example.com/dir/new.html", MetaRefresh},
+		{`<meta http-equiv="refresh" content="30">`, "", ""},
+		{`<meta name="description" content="url=http://example.org/">`, "", ""},
+		{`<script>top.location = 'https://example.net/';</script>`, "https://example.net/", ScriptRedirect},
+		{`<script type="text/javascript">document.location.replace("/home#top")</script>`, "http://example.com/home", ScriptRedirect},
+		{`<script>mylocation = "/nope"; window.location = "javascript:void(0)"</script>`, "", ""},
+		{`<p>location = "/text"</p>`, "", ""},
+	}
+	for _, test := range tests {
+		target, kind := ClientRedirect([]byte(test.body), "http://example.com/dir/page.html")
+		if target != test.target || kind != test.kind {
+			t.Errorf("%s: expected %q %q, got %q %q", test.body, test.target, test.kind, target, kind)
+		}
+	}
+}
+
+func TestDomainChanged(t *testing.T) {
+	tests := []struct {
+		from, to string
+		changed  bool
+	}{
+		{"http://example.com/", "https://www.example.com/", false},
+		{"http://www.example.co.uk/", "http://shop.example.co.uk/", false},
+		{"http://example.com/", "http://example.org/", true},
+		{"http://example.com/", "http://parking.sedo.com/?d=example.com", true},
+		{"http://example.com/", "", false},
+	}
+	for _, test := range tests {
+		if changed := DomainChanged(test.from, test.to); changed != test.changed {
+			t.Errorf("%s to %s: expected %v, got %v", test.from, test.to, test.changed, changed)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package fetch
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/warc"
+)
+
+// ErrNotStored is the error of a page missing from the Store of a Fetcher
+var ErrNotStored = errors.New("fetch: page not stored")
+
+// Store holds the pages fetched earlier, eg. a warc.Archive or a CacheDir
+type Store interface {
+	// Get returns the response to link, its Request holding the URL that
+	// answered
+	Get(link string) (*http.Response, error)
+}
+
+// CacheDir is the Store of a colly cache directory, as filled by
+// colly.CacheDir. It does not know the redirects of its pages.
+type CacheDir string
+
+// cached is a colly.Response as gob encoded in the cache
+type cached struct {
+	StatusCode int
+	Body       []byte
+	Headers    *http.Header
+}
+
+// Get reads the response cached for link
+func (d CacheDir) Get(link string) (*http.Response, error) {
+	sum := sha1.Sum([]byte(link))
+	hash := hex.EncodeToString(sum[:])
+	file, err := os.Open(filepath.Join(string(d), hash[:2], hash))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var page cached
+	if err := gob.NewDecoder(file).Decode(&page); err != nil {
+		return nil, fmt.Errorf("fetch: bad cache entry of %s: %w", link, err)
+	}
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp := &http.Response{
+		StatusCode:    page.StatusCode,
+		Header:        http.Header{},
+		Body:          ioutil.NopCloser(bytes.NewReader(page.Body)),
+		ContentLength: int64(len(page.Body)),
+		Request:       req,
+	}
+	if page.Headers != nil {
+		resp.Header = *page.Headers
+	}
+	return resp, nil
+}
+
+// load reads url from the Store as get would fetch it
+func (f *Fetcher) load(url string) (*Response, error) {
+	response := &Response{URL: url, Timings: Timings{Start: time.Now()}}
+	resp, err := f.Store.Get(url)
+	if errors.Is(err, warc.ErrNotFound) || os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotStored, url)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	response.FinalURL = resp.Request.URL.String()
+	if response.FinalURL != url {
+		// the hops in between are not stored
+		response.Redirects = []Hop{{URL: url, Location: response.FinalURL, Kind: HTTPRedirect}}
+	}
+	response.StatusCode = resp.StatusCode
+	response.Header = resp.Header
+	maxBodySize := f.maxBodySize()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > maxBodySize {
+		body = body[:maxBodySize]
+		response.Truncated = true
+	}
+	response.decode(body)
+	response.Timings.Total = time.Since(response.Timings.Start)
+	return response, nil
+}
@@ -1,6 +1,8 @@
 package gowap
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
@@ -12,9 +14,12 @@ import (
 	"strings"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly/v2"
 	extensions "github.com/gocolly/colly/v2/extensions"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
 )
 
 type collyData struct {
@@ -58,6 +63,9 @@ type Wappalyzer struct {
 	Categories map[string]*category
 	JSON       bool
 	Transport  *http.Transport
+	// Robots refuses the pages robots.txt disallows to Analyze, nil to
+	// analyze them all. Its UserAgent is the one sent.
+	Robots *robotstxt.Checker
 }
 
 // Init initializes wappalyzer
@@ -118,38 +126,35 @@ type resultApp struct {
 
 // Analyze retrieves application stack used on the provided web-site
 func (wapp *Wappalyzer) Analyze(url string) (result interface{}, err error) {
+	if wapp.Robots != nil {
+		match, err := wapp.Robots.Evaluate(context.Background(), url)
+		if err != nil {
+			return nil, err
+		}
+		if !match.Allowed {
+			return nil, fmt.Errorf("gowap: %s disallowed by robots.txt: %s", url, match)
+		}
+	}
+
+	// robots.txt is checked by Robots, shared with the other crawlers
 	wapp.Collector = colly.NewCollector(
 		colly.IgnoreRobotsTxt(),
 	)
 	wapp.Collector.WithTransport(wapp.Transport)
 
 	extensions.Referer(wapp.Collector)
-	extensions.RandomUserAgent(wapp.Collector)
+	if wapp.Robots != nil && wapp.Robots.UserAgent != "" {
+		// the agent robots.txt was read for
+		wapp.Collector.UserAgent = wapp.Robots.UserAgent
+	} else {
+		extensions.RandomUserAgent(wapp.Collector)
+	}
 
-	detectedApplications := make(map[string]*resultApp)
 	scraped := &collyData{}
 
 	wapp.Collector.OnResponse(func(r *colly.Response) {
 		// log.Infof("Visited %s", r.Request.URL)
-		scraped.headers = make(map[string][]string)
-		for k, v := range *r.Headers {
-			lowerCaseKey := strings.ToLower(k)
-			scraped.headers[lowerCaseKey] = v
-		}
-
-		scraped.html = string(r.Body)
-
-		scraped.cookies = make(map[string]string)
-		for _, cookie := range scraped.headers["set-cookie"] {
-			keyValues := strings.Split(cookie, ";")
-			for _, keyValueString := range keyValues {
-				keyValueSlice := strings.Split(keyValueString, "=")
-				if len(keyValueSlice) > 1 {
-					key, value := keyValueSlice[0], keyValueSlice[1]
-					scraped.cookies[key] = value
-				}
-			}
-		}
+		scraped.setResponse(*r.Headers, r.Body)
 	})
 
 	wapp.Collector.OnHTML("script", func(e *colly.HTMLElement) {
@@ -160,7 +165,52 @@ func (wapp *Wappalyzer) Analyze(url string) (result interface{}, err error) {
 	if err != nil {
 		return nil, err
 	}
+	return wapp.analyze(url, scraped)
+}
 
+// AnalyzePage retrieves the application stack of a page already fetched,
+// from its headers and body, without sending any request
+func (wapp *Wappalyzer) AnalyzePage(url string, headers http.Header, body []byte) (result interface{}, err error) {
+	scraped := &collyData{}
+	scraped.setResponse(headers, body)
+
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	doc.Find("script").Each(func(_ int, s *goquery.Selection) {
+		src, _ := s.Attr("src")
+		scraped.scripts = append(scraped.scripts, src)
+	})
+	return wapp.analyze(url, scraped)
+}
+
+// setResponse keeps the headers, cookies and html of a response
+func (scraped *collyData) setResponse(headers http.Header, body []byte) {
+	scraped.headers = make(map[string][]string)
+	for k, v := range headers {
+		lowerCaseKey := strings.ToLower(k)
+		scraped.headers[lowerCaseKey] = v
+	}
+
+	scraped.html = string(body)
+
+	scraped.cookies = make(map[string]string)
+	for _, cookie := range scraped.headers["set-cookie"] {
+		keyValues := strings.Split(cookie, ";")
+		for _, keyValueString := range keyValues {
+			keyValueSlice := strings.Split(keyValueString, "=")
+			if len(keyValueSlice) > 1 {
+				key, value := keyValueSlice[0], keyValueSlice[1]
+				scraped.cookies[key] = value
+			}
+		}
+	}
+}
+
+// analyze matches the applications against the scraped page
+func (wapp *Wappalyzer) analyze(url string, scraped *collyData) (result interface{}, err error) {
+	detectedApplications := make(map[string]*resultApp)
 	for _, app := range wapp.Apps {
 		analyzeURL(app, url, &detectedApplications)
 		if app.HTML != nil {
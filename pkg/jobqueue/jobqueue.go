@@ -0,0 +1,278 @@
+// Package jobqueue implements a colly queue.Storage persisted in a sqlite
+// file, so that a crawl resumes where it stopped after a crash or a restart.
+//
+// Every command keeps its requests under its own queue name in the same
+// file. A URL is only queued once at a time per queue, and a request handed
+// to a crawler stays hidden from the others until it is acknowledged or its
+// visibility timeout expires, after which it is handed out again. Requests
+// handed out MaxRetries times are given up and kept as failed, until their
+// URL is queued again.
+package jobqueue
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+	"github.com/lucmichalski/dmoz-utils/pkg/politeness"
+	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
+)
+
+// Defaults of a Storage
+const (
+	DefaultPath              = "./data/queue.db"
+	DefaultVisibilityTimeout = 10 * time.Minute
+	DefaultMaxRetries        = 3
+)
+
+// Item is a queued request
+type Item struct {
+	ID        uint   `gorm:"primary_key"`
+	Queue     string `gorm:"size:64;unique_index:uix_queue_items_queue_url"`
+	URL       string `gorm:"unique_index:uix_queue_items_queue_url"`
+	Data      []byte
+	Attempts  int
+	Failed    bool      `gorm:"index:idx_queue_items_failed"`
+	VisibleAt time.Time `gorm:"index:idx_queue_items_visible_at"`
+	CreatedAt time.Time
+}
+
+// TableName of the queued requests
+func (Item) TableName() string {
+	return "queue_items"
+}
+
+// Storage is a queue.Storage backed by a sqlite file
+type Storage struct {
+	// Path is the sqlite file, DefaultPath when empty
+	Path string
+	// Name of the queue, commands sharing the file use their own
+	Name string
+	// VisibilityTimeout is how long a request handed out stays hidden
+	VisibilityTimeout time.Duration
+	// MaxRetries is the number of times a request is handed out before
+	// being given up
+	MaxRetries int
+
+	once sync.Once
+	db   *gorm.DB
+	err  error
+}
+
+// New returns the storage of the queue name, as configured by cfg
+func New(cfg config.Queue, name string) *Storage {
+	return &Storage{
+		Path:              cfg.Path,
+		Name:              name,
+		VisibilityTimeout: cfg.VisibilityTimeout.Duration,
+		MaxRetries:        cfg.MaxRetries,
+	}
+}
+
+// Init opens the sqlite file, creating it when missing
+func (s *Storage) Init() error {
+	s.once.Do(func() {
+		if s.Path == "" {
+			s.Path = DefaultPath
+		}
+		if s.VisibilityTimeout <= 0 {
+			s.VisibilityTimeout = DefaultVisibilityTimeout
+		}
+		if s.MaxRetries <= 0 {
+			s.MaxRetries = DefaultMaxRetries
+		}
+		if s.err = os.MkdirAll(filepath.Dir(s.Path), 0755); s.err != nil {
+			return
+		}
+		// let the concurrent crawlers wait for each other's writes
+		s.db, s.err = gorm.Open("sqlite3", s.Path+"?_busy_timeout=10000&_journal_mode=WAL")
+		if s.err != nil {
+			return
+		}
+		s.db.DB().SetMaxOpenConns(1)
+		s.err = s.db.AutoMigrate(&Item{}).Error
+	})
+	return s.err
+}
+
+// Close closes the sqlite file
+func (s *Storage) Close() error {
+	if s.db == nil {
+		return nil
+	}
+	return s.db.Close()
+}
+
+// AddRequest queues a request serialized by colly, unless its URL already
+// is in the queue. A request given up is queued again from scratch.
+func (s *Storage) AddRequest(data []byte) error {
+	var request struct {
+		URL string
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return err
+	}
+	return s.add(request.URL, data)
+}
+
+// AddURL queues a URL, for the consumers not using colly
+func (s *Storage) AddURL(url string) error {
+	if err := s.Init(); err != nil {
+		return err
+	}
+	return s.add(url, nil)
+}
+
+func (s *Storage) add(url string, data []byte) error {
+	if url == "" {
+		return errors.New("jobqueue: request without URL")
+	}
+	// the requests waiting or in flight are left as they are
+	return s.db.Exec("INSERT INTO queue_items (queue, url, data, attempts, failed, visible_at, created_at) VALUES (?, ?, ?, 0, ?, ?, ?) "+
+		"ON CONFLICT (queue, url) DO UPDATE SET data = excluded.data, attempts = 0, failed = excluded.failed, visible_at = excluded.visible_at, created_at = excluded.created_at "+
+		"WHERE queue_items.failed",
+		s.Name, url, data, false, now(), now()).Error
+}
+
+// GetRequest hands out the next visible request and hides it for the
+// visibility timeout. It returns nil when no request is visible.
+func (s *Storage) GetRequest() ([]byte, error) {
+	item, err := s.next()
+	if item == nil {
+		return nil, err
+	}
+	return item.Data, nil
+}
+
+// GetURL is GetRequest returning the URL of the request, empty when no
+// request is visible
+func (s *Storage) GetURL() (string, error) {
+	if err := s.Init(); err != nil {
+		return "", err
+	}
+	item, err := s.next()
+	if item == nil {
+		return "", err
+	}
+	return item.URL, nil
+}
+
+func (s *Storage) next() (*Item, error) {
+	for {
+		current := now()
+		// give up the requests handed out too many times
+		err := s.db.Model(&Item{}).Where("queue = ? AND failed = ? AND visible_at <= ? AND attempts >= ?", s.Name, false, current, s.MaxRetries).
+			UpdateColumn("failed", true).Error
+		if err != nil {
+			return nil, err
+		}
+
+		item := &Item{}
+		query := s.db.Where("queue = ? AND failed = ? AND visible_at <= ?", s.Name, false, current).Order("id").First(item)
+		if query.RecordNotFound() {
+			return nil, nil
+		}
+		if query.Error != nil {
+			return nil, query.Error
+		}
+
+		// the number of attempts guards against another process taking the
+		// request in the meantime
+		update := s.db.Model(&Item{}).Where("id = ? AND attempts = ?", item.ID, item.Attempts).
+			UpdateColumns(map[string]interface{}{"attempts": item.Attempts + 1, "visible_at": current.Add(s.VisibilityTimeout)})
+		if update.Error != nil {
+			return nil, update.Error
+		}
+		if update.RowsAffected == 1 {
+			return item, nil
+		}
+	}
+}
+
+// QueueSize returns the number of visible requests
+func (s *Storage) QueueSize() (int, error) {
+	var count int
+	err := s.db.Model(&Item{}).Where("queue = ? AND failed = ? AND visible_at <= ?", s.Name, false, now()).Count(&count).Error
+	return count, err
+}
+
+// Done removes a request once processed, so that its URL can be queued
+// again
+func (s *Storage) Done(url string) error {
+	return s.db.Where("queue = ? AND url = ?", s.Name, url).Delete(&Item{}).Error
+}
+
+// Retry makes a request visible again right away, or gives it up when it
+// was handed out MaxRetries times
+func (s *Storage) Retry(url string) error {
+	return s.RetryAfter(url, 0)
+}
+
+// RetryAfter is Retry making the request visible again after delay
+func (s *Storage) RetryAfter(url string, delay time.Duration) error {
+	return s.db.Model(&Item{}).Where("queue = ? AND url = ?", s.Name, url).
+		UpdateColumns(map[string]interface{}{"visible_at": now().Add(delay), "failed": gorm.Expr("attempts >= ?", s.MaxRetries)}).Error
+}
+
+// Retrying tells if the request of url is to be sent again, ie. still
+// queued, neither done nor given up. Once Track handled an error, it is only
+// true of the requests retried.
+func (s *Storage) Retrying(url string) (bool, error) {
+	var count int
+	err := s.db.Model(&Item{}).Where("queue = ? AND url = ? AND failed = ?", s.Name, url, false).Count(&count).Error
+	return count > 0, err
+}
+
+// ctxURL is where Track keeps the URL a request was queued with, as colly
+// updates the URL of a request when following redirects
+const ctxURL = "jobqueue.url"
+
+// Track acknowledges the requests of c: they are Done once scraped or
+// failed for good, disallowed by robots.txt included, and retried on network
+// errors, 429 and 5xx responses. The ones refused for a robots.txt out of
+// reach are retried once the checker asks for it again.
+// c is let revisit URLs, so that the requests retried are sent again, the
+// queue keeping a URL once at a time. Requests colly refuses to send are
+// never acknowledged and given up after MaxRetries visibility timeouts.
+func (s *Storage) Track(c *colly.Collector) {
+	c.AllowURLRevisit = true
+	c.OnRequest(func(r *colly.Request) {
+		if r.Ctx.Get(ctxURL) == "" {
+			r.Ctx.Put(ctxURL, r.URL.String())
+		}
+	})
+	c.OnScraped(func(r *colly.Response) {
+		s.Done(r.Ctx.Get(ctxURL))
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		url := r.Ctx.Get(ctxURL)
+		if errors.Is(err, politeness.ErrDisallowed) {
+			s.Done(url)
+			return
+		}
+		if errors.Is(err, robotstxt.ErrUnreachable) {
+			s.RetryAfter(url, robotstxt.DefaultRetryDelay)
+			return
+		}
+		if r.StatusCode == 0 || r.StatusCode == http.StatusTooManyRequests || r.StatusCode >= 500 {
+			s.Retry(url)
+			return
+		}
+		s.Done(url)
+	})
+}
+
+// now is in UTC, so that sqlite compares the text it stores times as in
+// chronological order
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
@@ -0,0 +1,221 @@
+package jobqueue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/gocolly/colly/v2/queue"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/politeness"
+)
+
+func newStorage(t *testing.T) (*Storage, func()) {
+	dir, err := ioutil.TempDir("", "jobqueue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Storage{Path: filepath.Join(dir, "queue.db"), Name: "test", MaxRetries: 2}
+	if err := s.Init(); err != nil {
+		t.Fatal(err)
+	}
+	return s, func() {
+		s.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func size(t *testing.T, s *Storage) int {
+	n, err := s.QueueSize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestStorage_dedupe(t *testing.T) {
+	s, cleanup := newStorage(t)
+	defer cleanup()
+
+	for _, url := range []string{"http://a.example.com/", "http://b.example.com/", "http://a.example.com/"} {
+		if err := s.AddURL(url); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := size(t, s); n != 2 {
+		t.Fatalf("expected 2 queued URLs, got %d", n)
+	}
+
+	// another queue of the same file is independent
+	other := &Storage{Path: s.Path, Name: "other"}
+	if err := other.AddURL("http://a.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if n := size(t, other); n != 1 {
+		t.Errorf("expected 1 URL in the other queue, got %d", n)
+	}
+
+	url, err := s.GetURL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "http://a.example.com/" {
+		t.Errorf("expected the first URL, got %q", url)
+	}
+	// in flight, it is still deduped
+	s.AddURL(url)
+	if n := size(t, s); n != 1 {
+		t.Errorf("expected 1 visible URL, got %d", n)
+	}
+	if err := s.Done(url); err != nil {
+		t.Fatal(err)
+	}
+	s.AddURL(url)
+	if n := size(t, s); n != 2 {
+		t.Errorf("expected a done URL to be queued again, got %d", n)
+	}
+}
+
+func TestStorage_visibilityTimeout(t *testing.T) {
+	s, cleanup := newStorage(t)
+	defer cleanup()
+	s.VisibilityTimeout = 50 * time.Millisecond
+
+	s.AddURL("http://a.example.com/")
+	for attempt := 0; attempt < 2; attempt++ {
+		url, err := s.GetURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != "http://a.example.com/" {
+			t.Fatalf("attempt %d: expected the URL to be handed out, got %q", attempt, url)
+		}
+		if url, _ := s.GetURL(); url != "" {
+			t.Fatalf("attempt %d: expected the URL to be hidden, got %q", attempt, url)
+		}
+		time.Sleep(60 * time.Millisecond)
+	}
+	// MaxRetries reached
+	if url, _ := s.GetURL(); url != "" {
+		t.Errorf("expected the URL to be given up, got %q", url)
+	}
+	var item Item
+	s.db.First(&item)
+	if !item.Failed || item.Attempts != 2 {
+		t.Errorf("expected a failed item after 2 attempts, got %+v", item)
+	}
+
+	// queued again, it starts over
+	if err := s.AddURL("http://a.example.com/"); err != nil {
+		t.Fatal(err)
+	}
+	if url, _ := s.GetURL(); url != "http://a.example.com/" {
+		t.Errorf("expected a failed URL queued again to be handed out, got %q", url)
+	}
+	// while in flight, adding it again changes nothing
+	s.AddURL("http://a.example.com/")
+	s.db.First(&item)
+	if item.Failed || item.Attempts != 1 {
+		t.Errorf("expected a fresh item handed out once, got %+v", item)
+	}
+}
+
+func TestStorage_colly(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		switch r.URL.Path {
+		case "/unavailable":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			fmt.Fprint(w, "<html><title>ok</title></html>")
+		}
+	}))
+	defer server.Close()
+
+	s, cleanup := newStorage(t)
+	defer cleanup()
+
+	// the requests retried are sent again within the same run
+	c := colly.NewCollector()
+	s.Track(c)
+	q, err := queue.New(2, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/", "/missing", "/unavailable", "/"} {
+		q.AddURL(server.URL + path)
+	}
+	var retrying []bool
+	c.OnError(func(r *colly.Response, err error) {
+		if r.Request.URL.Path == "/unavailable" {
+			pending, err := s.Retrying(r.Request.URL.String())
+			if err != nil {
+				t.Error(err)
+			}
+			retrying = append(retrying, pending)
+		}
+	})
+	q.Run(c)
+
+	// only the last error of /unavailable gives it up
+	if len(retrying) != 2 || !retrying[0] || retrying[1] {
+		t.Errorf("expected /unavailable to be retried once, got %v", retrying)
+	}
+	// / once, /missing once, /unavailable up to MaxRetries
+	if hits != 4 {
+		t.Errorf("expected 4 requests, got %d", hits)
+	}
+	var items []Item
+	s.db.Find(&items)
+	if len(items) != 1 || items[0].URL != server.URL+"/unavailable" || !items[0].Failed {
+		t.Errorf("expected only /unavailable to be left, given up, got %+v", items)
+	}
+}
+
+func TestStorage_robotsUnreachable(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	s, cleanup := newStorage(t)
+	defer cleanup()
+
+	c := colly.NewCollector()
+	c.WithTransport((&politeness.Scheduler{HostDelay: -1}).Transport(nil))
+	s.Track(c)
+	q, err := queue.New(1, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.AddURL(server.URL + "/page")
+	q.Run(c)
+
+	// the request waits for robots.txt to be asked for again
+	if hits != 0 {
+		t.Errorf("expected the page not to be requested, got %d requests", hits)
+	}
+	if retrying, err := s.Retrying(server.URL + "/page"); err != nil || !retrying {
+		t.Errorf("expected the request to be retried, got %v %v", retrying, err)
+	}
+	var item Item
+	s.db.First(&item)
+	if item.Failed || !item.VisibleAt.After(time.Now()) {
+		t.Errorf("expected the request to be hidden until robots.txt is asked for again, got %+v", item)
+	}
+}
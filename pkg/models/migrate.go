@@ -0,0 +1,156 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// legacyAlexaTable is where alexa_selenium used to keep its websites,
+// before they were merged into the websites table
+const legacyAlexaTable = "alexa_websites"
+
+// legacyAlexaColumns are the columns copied over from legacyAlexaTable
+var legacyAlexaColumns = []string{
+	"link", "alive", "status_code", "name", "path", "title", "description",
+	"category_id", "wap", "analyzed", "text_extract", "article_text",
+	"robots_txt", "host", "scheme", "domain", "tld", "language",
+	"lang_confidence", "created_at", "updated_at",
+}
+
+// legacyAlexaSites is where the websites table of alexa.go goes, its own
+// website model having used the same table name with another schema
+const legacyAlexaSites = "alexa_sites"
+
+// legacyIndexes lists, per model, the indexes created before they were
+// prefixed with their table name. Index names are global to a sqlite
+// database, so the short ones collided between tables. They are dropped once,
+// when the table lacks the prefixed version of the first one.
+var legacyIndexes = []struct {
+	model   interface{}
+	indexes []string
+}{
+	{&Website{}, []string{"alive", "status_code", "name", "path", "dump"}},
+	{&Dmoz{}, []string{"priority", "dump"}},
+	{&Category{}, []string{"name", "code", "category_id"}},
+	{&CategoryLink{}, []string{"category_id", "kind", "target", "target_id"}},
+}
+
+// Migrate creates or updates the tables of every model, drops the indexes
+// superseded by prefixed ones, then moves the rows of the legacy
+// alexa_websites and alexa.go websites tables into websites and ranks.
+func Migrate(db *gorm.DB) error {
+	if db.Dialect().GetName() == "mysql" {
+		db = db.Set("gorm:table_options", "ENGINE=InnoDB CHARSET=utf8mb4")
+	}
+	if err := renameAlexaSites(db); err != nil {
+		return err
+	}
+	// the tables to clean up are found before their prefixed indexes exist
+	var stale []int
+	for i, legacy := range legacyIndexes {
+		scope := db.NewScope(legacy.model)
+		table := scope.TableName()
+		if db.HasTable(table) && !scope.Dialect().HasIndex(table, fmt.Sprintf("idx_%s_%s", table, legacy.indexes[0])) {
+			stale = append(stale, i)
+		}
+	}
+
+	err := db.AutoMigrate(
+		&Website{},
+		&Category{},
+		&CategoryLink{},
+		&Rss{},
+		&Rank{},
+		&Sitemap{},
+		&Redirect{},
+		&RobotsFile{},
+		&RobotsSummary{},
+		&RobotsGroup{},
+		&Dmoz{},
+		&ImportCheckpoint{},
+		&WorkClaim{},
+	).Error
+	if err != nil {
+		return err
+	}
+	for _, i := range stale {
+		legacy := legacyIndexes[i]
+		scope := db.NewScope(legacy.model)
+		for _, index := range legacy.indexes {
+			if scope.Dialect().HasIndex(scope.TableName(), index) {
+				if err := db.Model(legacy.model).RemoveIndex(index).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := migrateAlexaWebsites(db); err != nil {
+		return err
+	}
+	return migrateAlexaSites(db)
+}
+
+// migrateAlexaWebsites copies the websites of legacyAlexaTable that are
+// missing from websites, with the alexa source. The legacy table is left in
+// place.
+func migrateAlexaWebsites(db *gorm.DB) error {
+	if !db.HasTable(legacyAlexaTable) {
+		return nil
+	}
+	columns := strings.Join(legacyAlexaColumns, ", ")
+	websites := db.NewScope(&Website{}).TableName()
+	query := fmt.Sprintf("INSERT INTO %s (%s, source) SELECT %s, ? FROM %s a WHERE a.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM %s w WHERE w.link = a.link)",
+		websites, columns, columns, legacyAlexaTable, websites)
+	return db.Exec(query, SourceAlexa).Error
+}
+
+// renameAlexaSites moves the websites table of alexa.go, told by its site
+// column, out of the way of the Website model
+func renameAlexaSites(db *gorm.DB) error {
+	websites := db.NewScope(&Website{}).TableName()
+	dialect := db.Dialect()
+	if !db.HasTable(websites) || !dialect.HasColumn(websites, "site") || dialect.HasColumn(websites, "link") {
+		return nil
+	}
+	err := db.Exec(fmt.Sprintf("ALTER TABLE %s RENAME TO %s", dialect.Quote(websites), dialect.Quote(legacyAlexaSites))).Error
+	if err != nil {
+		return err
+	}
+	// sqlite keeps the index names, which the Website model uses
+	index := "idx_" + websites + "_deleted_at"
+	if dialect.HasIndex(legacyAlexaSites, index) {
+		return db.Table(legacyAlexaSites).RemoveIndex(index).Error
+	}
+	return nil
+}
+
+// migrateAlexaSites copies the sites of legacyAlexaSites missing from
+// websites, with the alexa source, and their traffic figures into ranks. The
+// legacy table is left in place.
+func migrateAlexaSites(db *gorm.DB) error {
+	if !db.HasTable(legacyAlexaSites) {
+		return nil
+	}
+	websites := db.NewScope(&Website{}).TableName()
+	ranks := db.NewScope(&Rank{}).TableName()
+	tx := db.Begin()
+	err := tx.Exec(fmt.Sprintf("INSERT INTO %s (link, source, path, language, lang_confidence, created_at, updated_at) "+
+		"SELECT a.site, ?, a.category_path, a.detect_lang, a.detect_lang_confidence, a.created_at, a.updated_at FROM %s a "+
+		"WHERE a.deleted_at IS NULL AND a.site != '' AND NOT EXISTS (SELECT 1 FROM %s w WHERE w.link = a.site)",
+		websites, legacyAlexaSites, websites), SourceAlexa).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = tx.Exec(fmt.Sprintf("INSERT INTO %s (website_id, daily_time_on_site, daily_pageviews_per_visitor, percent_of_traffic_from_search, total_sites_linking_in, created_at, updated_at) "+
+		"SELECT w.id, a.daily_time_on_site, a.daily_pageviews_per_visitor, a.percent_of_traffic_from_search, a.total_sites_linking_in, a.created_at, a.updated_at FROM %s a "+
+		"JOIN %s w ON w.link = a.site WHERE a.deleted_at IS NULL AND NOT EXISTS (SELECT 1 FROM %s r WHERE r.website_id = w.id)",
+		ranks, legacyAlexaSites, websites, ranks)).Error
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
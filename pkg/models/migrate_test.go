@@ -0,0 +1,177 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// alexaWebsite mimics the table alexa_selenium used to write to
+type alexaWebsite struct {
+	gorm.Model
+	Link           string
+	Alive          bool
+	StatusCode     int
+	Name           string
+	Path           string
+	Title          string
+	Description    string
+	CategoryID     uint
+	Wap            string
+	Analyzed       int
+	TextExtract    string
+	ArticleText    string
+	RobotsTxt      string
+	Host           string
+	Scheme         string
+	Domain         string
+	Tld            string
+	Language       string
+	LangConfidence float64
+}
+
+func (alexaWebsite) TableName() string {
+	return legacyAlexaTable
+}
+
+func TestMigrate_movesAlexaWebsites(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.AutoMigrate(&alexaWebsite{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	for _, link := range []string{"http://a.example.com/", "http://b.example.com/"} {
+		legacy := &alexaWebsite{Link: link, Path: "Top/Arts"}
+		if err := db.Create(legacy).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.AutoMigrate(&Website{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&Website{Link: "http://a.example.com/", Source: SourceDmoz, Path: "Arts"}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// running it twice must not duplicate the rows
+	for i := 0; i < 2; i++ {
+		if err := Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var websites []Website
+	db.Order("link").Find(&websites)
+	if len(websites) != 2 {
+		t.Fatalf("expected 2 websites, got %d", len(websites))
+	}
+	if websites[0].Source != SourceDmoz || websites[0].Path != "Arts" {
+		t.Errorf("expected the dmoz website to be kept, got %+v", websites[0])
+	}
+	if websites[1].Source != SourceAlexa || websites[1].Path != "Top/Arts" {
+		t.Errorf("expected the alexa website to be moved, got %+v", websites[1])
+	}
+}
+
+// alexaSite mimics the website model alexa.go kept in the websites table
+type alexaSite struct {
+	gorm.Model
+	Site                       string `gorm:"size:255;unique"`
+	DailyTimeOnSite            string
+	DailyPageviewsPerVisitor   string
+	PercentOfTrafficFromSearch string
+	TotalSitesLinkingIn        float64
+	DetectLang                 string
+	DetectLangConfidence       float64
+	CategoryPath               string
+}
+
+func (alexaSite) TableName() string {
+	return "websites"
+}
+
+func TestMigrate_movesAlexaSites(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err := db.AutoMigrate(&alexaSite{}).Error; err != nil {
+		t.Fatal(err)
+	}
+	sites := []*alexaSite{
+		{Site: "http://a.example.com/", DailyTimeOnSite: "3:12", TotalSitesLinkingIn: 42, DetectLang: "English", DetectLangConfidence: 0.9, CategoryPath: "Top/Arts"},
+		{Site: "http://b.example.com/", DailyTimeOnSite: "1:05", CategoryPath: "Top/Science"},
+	}
+	for _, site := range sites {
+		if err := db.Create(site).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := Migrate(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var websites []Website
+	db.Order("link").Find(&websites)
+	if len(websites) != 2 {
+		t.Fatalf("expected 2 websites, got %d", len(websites))
+	}
+	a := websites[0]
+	if a.Link != "http://a.example.com/" || a.Source != SourceAlexa || a.Path != "Top/Arts" || a.Language != "English" {
+		t.Errorf("expected the alexa site to be moved, got %+v", a)
+	}
+	var ranks []Rank
+	db.Order("website_id").Find(&ranks)
+	if len(ranks) != 2 {
+		t.Fatalf("expected 2 ranks, got %d", len(ranks))
+	}
+	if ranks[0].WebsiteID != a.ID || ranks[0].DailyTimeOnSite != "3:12" || ranks[0].TotalSitesLinkingIn != 42 {
+		t.Errorf("expected the traffic of the alexa site, got %+v", ranks[0])
+	}
+}
+
+func TestMigrate_legacyIndexesOnce(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// a table of before the prefixed indexes
+	if err := db.Exec("CREATE TABLE categories (id integer primary key autoincrement, name varchar(255))").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Exec("CREATE INDEX name ON categories(name)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if db.Dialect().HasIndex("categories", "name") {
+		t.Errorf("expected the legacy index to be dropped")
+	}
+	if !db.Dialect().HasIndex("categories", "idx_categories_name") {
+		t.Errorf("expected the prefixed index to be created")
+	}
+
+	// once migrated, the indexes are left alone
+	if err := db.Exec("CREATE INDEX name ON categories(name)").Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if !db.Dialect().HasIndex("categories", "name") {
+		t.Errorf("expected the cleanup to run once")
+	}
+}
@@ -0,0 +1,236 @@
+// Package models holds the database schema shared by every command: the
+// websites collected from dmoz, alexa, similarweb or curlie, their
+// categories, feeds, sitemaps and rankings, and the raw dmoz listings.
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/qor/validations"
+)
+
+// Sources a website can be collected from
+const (
+	SourceDmoz       = "dmoz"
+	SourceCurlie     = "curlie"
+	SourceAlexa      = "alexa"
+	SourceSimilarWeb = "similarweb"
+)
+
+// Text columns are declared with a size above what fits a varchar, which
+// gorm maps to longtext on mysql and text on sqlite and postgres.
+
+// Website is a site listed by one of the sources, along with everything the
+// crawlers learn about it. Link is unique across sources, Source is the one
+// the site was first collected from.
+type Website struct {
+	gorm.Model
+	Link           string   `gorm:"size:255;unique"`
+	Source         string   `gorm:"size:32;index:idx_websites_source"`
+	Alive          bool     `gorm:"index:idx_websites_alive"`
+	StatusCode     int      `gorm:"index:idx_websites_status_code"`
+	Name           string   `gorm:"size:65536"`
+	Path           string   `gorm:"size:65536"`
+	Title          string   `gorm:"size:65536"`
+	Description    string   `gorm:"size:65536"`
+	CategoryID     uint     `l10n:"sync"`
+	Category       Category `l10n:"sync"`
+	Wap            string   `gorm:"size:65536"`
+	Analyzed       int
+	TextExtract    string `gorm:"size:65536"`
+	ArticleText    string `gorm:"size:65536"`
+	RobotsTxt      string `gorm:"size:65536"`
+	Host           string
+	Scheme         string
+	Domain         string
+	Tld            string
+	Language       string
+	LangConfidence float64
+	Dump           string `gorm:"index:idx_websites_dump"`
+	FinalURL       string `gorm:"size:65536"`
+	DomainChanged  bool   `gorm:"index:idx_websites_domain_changed"`
+	ETag           string `gorm:"column:etag;size:255"`
+	LastModified   string `gorm:"size:64"`
+	ContentHash    string `gorm:"size:64"`
+	LastCheckedAt  *time.Time
+	LastChangedAt  *time.Time
+	NextCheckAt    *time.Time `gorm:"index:idx_websites_next_check_at"`
+	Ranking        Rank
+	Rss            []Rss
+	Sitemaps       []Sitemap
+	Redirects      []Redirect
+}
+
+// Dmoz is a listing of a dmoz or curlie content dump, as written by the
+// editors
+type Dmoz struct {
+	gorm.Model
+	Link           string `gorm:"size:255;unique"`
+	Title          string `gorm:"size:65536"`
+	Description    string `gorm:"size:65536"`
+	Path           string `gorm:"size:65536"`
+	PathFull       string `gorm:"size:65536"`
+	PathParent     string `gorm:"size:65536"`
+	Language       string
+	LangIso6391    string
+	LangIso6393    string
+	LangScript     string `gorm:"size:65536"`
+	LangConfidence float64
+	Priority       int `gorm:"index:idx_dmozs_priority"`
+	MediaDate      string
+	Ages           string
+	Dump           string `gorm:"index:idx_dmozs_dump"`
+}
+
+// ImportCheckpoint records how far the import of a dump went
+type ImportCheckpoint struct {
+	gorm.Model
+	File    string `gorm:"size:255;unique"`
+	Offset  int64
+	Records int64
+	Done    bool
+}
+
+// WorkClaim reserves a row for the scanner processes running a task until
+// it expires. Item, the task and the row id, is unique so that a row is
+// claimed by a single process at a time. Claims are deleted for good once
+// expired, hence no gorm.Model and its soft deletes.
+type WorkClaim struct {
+	ID        uint      `gorm:"primary_key"`
+	Item      string    `gorm:"size:255;unique"`
+	Task      string    `gorm:"size:64;index:idx_work_claims_task_item_id"`
+	ItemID    uint      `gorm:"index:idx_work_claims_task_item_id"`
+	Owner     string    `gorm:"size:255"`
+	ExpiresAt time.Time `gorm:"index:idx_work_claims_expires_at"`
+	CreatedAt time.Time
+}
+
+// Redirect is a hop between a website link and its final URL: an HTTP
+// redirect, a meta refresh or a script setting the location. Hops are
+// replaced on every crawl, hence no gorm.Model and its soft deletes.
+type Redirect struct {
+	ID         uint   `gorm:"primary_key"`
+	WebsiteID  uint   `gorm:"index:idx_redirects_website_id"`
+	Position   int    `gorm:"index:idx_redirects_website_id"`
+	Kind       string `gorm:"size:16"`
+	StatusCode int
+	URL        string `gorm:"size:65536"`
+	Location   string `gorm:"size:65536"`
+	CreatedAt  time.Time
+}
+
+// RobotsFile is the robots.txt file of an origin as last fetched, kept for
+// the robotstxt.Checker of the next runs
+type RobotsFile struct {
+	ID         uint   `gorm:"primary_key"`
+	Origin     string `gorm:"size:255;unique_index:uix_robots_files_origin"`
+	StatusCode int
+	Body       string `gorm:"size:65536"`
+	FetchedAt  time.Time
+}
+
+// RobotsSummary sums up the robots.txt of a website, its groups being the
+// RobotsGroup rows of the website. Both are replaced on every scan, hence
+// no gorm.Model and its soft deletes.
+type RobotsSummary struct {
+	ID         uint   `gorm:"primary_key"`
+	WebsiteID  uint   `gorm:"unique_index:uix_robots_summaries_website_id"`
+	URL        string `gorm:"size:65536"`
+	StatusCode int
+	// Unreachable is set when the file could not be fetched, every URL
+	// disallowed then
+	Unreachable bool
+	Size        int
+	Groups      int
+	Rules       int
+	Sitemaps    int
+	Host        string `gorm:"size:255"`
+	// DisallowAll is set when the home page is disallowed to the agents
+	// without a group of their own
+	DisallowAll bool `gorm:"index:idx_robots_summaries_disallow_all"`
+	// Directives lists the names of the non-standard directives, comma
+	// separated, eg. clean-param,request-rate
+	Directives string `gorm:"size:65536"`
+	CreatedAt  time.Time
+}
+
+// RobotsGroup is the group of a user-agent in the robots.txt of a website
+type RobotsGroup struct {
+	ID        uint   `gorm:"primary_key"`
+	WebsiteID uint   `gorm:"index:idx_robots_groups_website_id"`
+	UserAgent string `gorm:"size:255;index:idx_robots_groups_user_agent"`
+	Allows    int
+	Disallows int
+	// CrawlDelay is in seconds
+	CrawlDelay float64
+	// DisallowAll is set when the group disallows the home page
+	DisallowAll bool
+}
+
+type Sitemap struct {
+	gorm.Model
+	Href      string `gorm:"size:65536"`
+	Index     bool
+	Gziped    bool
+	WebsiteID uint
+}
+
+type Rss struct {
+	gorm.Model
+	Href               string `gorm:"size:65536"`
+	Language           string
+	LanguageConfidence float64
+	WebsiteID          uint
+}
+
+// Rank holds the traffic metrics of a website, as published by the
+// ranking services
+type Rank struct {
+	gorm.Model
+	WebsiteID                  uint `gorm:"index:idx_ranks_website_id"`
+	Alexa                      int
+	Quancast                   int
+	Majestic                   int
+	DailyTimeOnSite            string
+	DailyPageviewsPerVisitor   string
+	PercentOfTrafficFromSearch string
+	TotalSitesLinkingIn        float64
+}
+
+type Category struct {
+	gorm.Model
+	Name        string `gorm:"index:idx_categories_name"`
+	Code        string `gorm:"index:idx_categories_code"`
+	Title       string
+	Description string `gorm:"size:65536"`
+	Categories  []Category
+	CategoryID  uint `gorm:"index:idx_categories_category_id"`
+	Links       []CategoryLink
+}
+
+// CategoryLink is a typed edge of the DMOZ taxonomy (narrow, symbolic,
+// related, altlang or editor) as found in structure.rdf.u8
+type CategoryLink struct {
+	gorm.Model
+	CategoryID uint   `gorm:"index:idx_category_links_category_id"`
+	Kind       string `gorm:"index:idx_category_links_kind"`
+	Label      string
+	Target     string `gorm:"index:idx_category_links_target"`
+	TargetID   uint   `gorm:"index:idx_category_links_target_id"`
+}
+
+func (category Category) Validate(db *gorm.DB) {
+	if strings.TrimSpace(category.Name) == "" {
+		db.AddError(validations.NewError(category, "Name", "Name can not be empty"))
+	}
+}
+
+func (category Category) DefaultPath() string {
+	if len(category.Code) > 0 {
+		return fmt.Sprintf("/category/%s", category.Code)
+	}
+	return "/"
+}
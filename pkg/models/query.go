@@ -0,0 +1,86 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
+)
+
+// RandomOrder returns the ORDER BY expression shuffling rows on the dialect
+// of db
+func RandomOrder(db *gorm.DB) string {
+	if db.Dialect().GetName() == "mysql" {
+		return "RAND()"
+	}
+	return "RANDOM()"
+}
+
+// SaveRedirects replaces the redirects recorded for a website
+func SaveRedirects(db *gorm.DB, websiteID uint, redirects []Redirect) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("website_id = ?", websiteID).Delete(&Redirect{}).Error; err != nil {
+			return err
+		}
+		for i := range redirects {
+			redirects[i].ID = 0
+			redirects[i].WebsiteID = websiteID
+			redirects[i].Position = i
+			if err := tx.Create(&redirects[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SaveRobots replaces the robots.txt summary and groups recorded for a
+// website
+func SaveRobots(db *gorm.DB, websiteID uint, summary *RobotsSummary, groups []RobotsGroup) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("website_id = ?", websiteID).Delete(&RobotsSummary{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("website_id = ?", websiteID).Delete(&RobotsGroup{}).Error; err != nil {
+			return err
+		}
+		summary.ID = 0
+		summary.WebsiteID = websiteID
+		if err := tx.Create(summary).Error; err != nil {
+			return err
+		}
+		for i := range groups {
+			groups[i].ID = 0
+			groups[i].WebsiteID = websiteID
+			if err := tx.Create(&groups[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RobotsStore keeps the robots.txt files of a robotstxt.Checker in the
+// database
+type RobotsStore struct {
+	DB *gorm.DB
+}
+
+// Load returns the file of origin, nil when there is none
+func (s RobotsStore) Load(origin string) (*robotstxt.File, error) {
+	file := &RobotsFile{}
+	err := s.DB.Where("origin = ?", origin).First(file).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &robotstxt.File{Origin: file.Origin, StatusCode: file.StatusCode, Body: file.Body, FetchedAt: file.FetchedAt}, nil
+}
+
+// Save replaces the file of its origin
+func (s RobotsStore) Save(file *robotstxt.File) error {
+	return s.DB.Where("origin = ?", file.Origin).
+		Assign(map[string]interface{}{"status_code": file.StatusCode, "body": file.Body, "fetched_at": file.FetchedAt.UTC()}).
+		FirstOrCreate(&RobotsFile{Origin: file.Origin}).Error
+}
@@ -0,0 +1,119 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
+)
+
+func TestSaveRedirects(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	first := []Redirect{{Kind: "http", StatusCode: 301, URL: "http://a.example.com/", Location: "https://a.example.com/"}}
+	if err := SaveRedirects(db, 1, first); err != nil {
+		t.Fatal(err)
+	}
+	again := []Redirect{
+		{Kind: "http", StatusCode: 302, URL: "http://a.example.com/", Location: "http://b.example.com/"},
+		{Kind: "meta", StatusCode: 200, URL: "http://b.example.com/", Location: "http://c.example.com/"},
+	}
+	if err := SaveRedirects(db, 1, again); err != nil {
+		t.Fatal(err)
+	}
+	SaveRedirects(db, 2, first)
+
+	var redirects []Redirect
+	db.Where("website_id = ?", 1).Order("position").Find(&redirects)
+	if len(redirects) != 2 || redirects[0].StatusCode != 302 || redirects[1].Position != 1 || redirects[1].Kind != "meta" {
+		t.Errorf("expected the redirects to be replaced, got %+v", redirects)
+	}
+	var count int
+	db.Model(&Redirect{}).Count(&count)
+	if count != 3 {
+		t.Errorf("expected 3 redirects, got %d", count)
+	}
+}
+
+func TestSaveRobots(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	save := func(websiteID uint, disallowAll bool, groups ...RobotsGroup) {
+		if err := SaveRobots(db, websiteID, &RobotsSummary{StatusCode: 200, Groups: len(groups), DisallowAll: disallowAll}, groups); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(1, false, RobotsGroup{UserAgent: "*", Allows: 1})
+	save(1, false, RobotsGroup{UserAgent: "*"}, RobotsGroup{UserAgent: "gptbot", Disallows: 1, DisallowAll: true})
+	save(2, true, RobotsGroup{UserAgent: "*", Disallows: 1, DisallowAll: true})
+	save(3, false, RobotsGroup{UserAgent: "*"}, RobotsGroup{UserAgent: "gptbot"})
+
+	var summaries, groups int
+	db.Model(&RobotsSummary{}).Count(&summaries)
+	db.Model(&RobotsGroup{}).Count(&groups)
+	if summaries != 3 || groups != 5 {
+		t.Errorf("expected the summaries and groups to be replaced, got %d and %d", summaries, groups)
+	}
+
+	// the sites blocking an agent, by its own group or the default one
+	var blocking int
+	db.Table("robots_summaries s").
+		Joins("LEFT JOIN robots_groups g ON g.website_id = s.website_id AND g.user_agent = ?", "gptbot").
+		Where("COALESCE(g.disallow_all, s.disallow_all)").
+		Count(&blocking)
+	if blocking != 2 {
+		t.Errorf("expected 2 websites blocking gptbot, got %d", blocking)
+	}
+}
+
+func TestRobotsStore(t *testing.T) {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	store := RobotsStore{DB: db}
+	if file, err := store.Load("http://example.com"); file != nil || err != nil {
+		t.Errorf("expected no file, got %+v %v", file, err)
+	}
+	fetchedAt := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	store.Save(&robotstxt.File{Origin: "http://example.com", StatusCode: 200, Body: "User-agent: *\nDisallow: /\n", FetchedAt: fetchedAt})
+	// a missing file replaces the previous one
+	if err := store.Save(&robotstxt.File{Origin: "http://example.com", StatusCode: 404, FetchedAt: fetchedAt.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := store.Load("http://example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.StatusCode != 404 || file.Body != "" || !file.FetchedAt.Equal(fetchedAt.Add(time.Hour)) {
+		t.Errorf("expected the file to be replaced, got %+v", file)
+	}
+	var count int
+	db.Model(&RobotsFile{}).Count(&count)
+	if count != 1 {
+		t.Errorf("expected a file per origin, got %d", count)
+	}
+}
@@ -0,0 +1,343 @@
+// Package politeness schedules the requests of the crawlers so that every
+// host is fetched at a polite pace, whatever the number of workers.
+//
+// Requests are keyed by host, or by registered domain, each key having its
+// own concurrency and delay between requests. The delay is raised to the
+// Crawl-delay of robots.txt, URLs disallowed for our user agent are refused,
+// and a host answering 429 or 503 is paused for its Retry-After, or for an
+// exponential backoff. Global limits cap the whole process on top of that.
+package politeness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
+	"github.com/lucmichalski/dmoz-utils/pkg/tldparser"
+)
+
+// Defaults of a Scheduler
+const (
+	DefaultUserAgent       = "dmoz-utils"
+	DefaultHostConcurrency = 2
+	DefaultHostDelay       = time.Second
+	DefaultBackoff         = 30 * time.Second
+	DefaultMaxDelay        = 5 * time.Minute
+)
+
+// ErrDisallowed is the error of the requests refused by robots.txt, wrapped
+// with the rule refusing them
+var ErrDisallowed = errors.New("politeness: disallowed by robots.txt")
+
+// Scheduler paces the requests per host
+type Scheduler struct {
+	// UserAgent is the token matched against the groups of robots.txt
+	UserAgent string
+	// IgnoreRobots disables robots.txt, Crawl-delay included
+	IgnoreRobots bool
+	// PerDomain keys the hosts by registered domain
+	PerDomain bool
+	// Concurrency and RequestsPerSecond limit the whole process, 0 for no
+	// limit
+	Concurrency       int
+	RequestsPerSecond float64
+	// HostConcurrency and HostDelay limit the requests of a key
+	HostConcurrency int
+	HostDelay       time.Duration
+	// Backoff is the first pause of a host answering 429 or 503 without
+	// Retry-After, doubled while it keeps doing so
+	Backoff time.Duration
+	// MaxDelay caps Crawl-delay, Retry-After and Backoff
+	MaxDelay time.Duration
+	// Robots tells the URLs allowed by robots.txt, and may be shared with
+	// the crawlers not going through the scheduler. It matches the groups
+	// of UserAgent when it has no user agent of its own.
+	Robots *robotstxt.Checker
+
+	once    sync.Once
+	mutex   sync.Mutex
+	hosts   map[string]*host
+	global  chan struct{}
+	nextAny time.Time
+}
+
+// host is the state of a key
+type host struct {
+	active  int
+	next    time.Time
+	delay   time.Duration
+	backoff time.Duration
+	// freed is closed when a request of the key ends
+	freed chan struct{}
+}
+
+// New returns a Scheduler configured by cfg
+func New(cfg config.Politeness) *Scheduler {
+	return &Scheduler{
+		UserAgent:         cfg.UserAgent,
+		IgnoreRobots:      cfg.IgnoreRobots,
+		PerDomain:         cfg.PerDomain,
+		Concurrency:       cfg.Concurrency,
+		RequestsPerSecond: cfg.RequestsPerSecond,
+		HostConcurrency:   cfg.HostConcurrency,
+		HostDelay:         cfg.HostDelay.Duration,
+		Backoff:           cfg.Backoff.Duration,
+		MaxDelay:          cfg.MaxDelay.Duration,
+		Robots:            &robotstxt.Checker{UserAgent: cfg.UserAgent, TTL: cfg.RobotsTTL.Duration},
+	}
+}
+
+func (s *Scheduler) init() {
+	if s.UserAgent == "" {
+		s.UserAgent = DefaultUserAgent
+	}
+	if s.HostConcurrency <= 0 {
+		s.HostConcurrency = DefaultHostConcurrency
+	}
+	if s.HostDelay < 0 {
+		s.HostDelay = 0
+	} else if s.HostDelay == 0 {
+		s.HostDelay = DefaultHostDelay
+	}
+	if s.Backoff <= 0 {
+		s.Backoff = DefaultBackoff
+	}
+	if s.MaxDelay <= 0 {
+		s.MaxDelay = DefaultMaxDelay
+	}
+	if s.Robots == nil {
+		s.Robots = &robotstxt.Checker{}
+	}
+	if s.Robots.UserAgent == "" {
+		s.Robots.UserAgent = s.UserAgent
+	}
+	if s.Concurrency > 0 {
+		s.global = make(chan struct{}, s.Concurrency)
+	}
+	s.hosts = make(map[string]*host)
+}
+
+// Key returns the key u is scheduled under, its host or registered domain
+func (s *Scheduler) Key(u *url.URL) string {
+	s.once.Do(s.init)
+	if s.PerDomain {
+		return tldparser.RegisteredDomain(u.Hostname())
+	}
+	return strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+}
+
+// Acquire blocks until a request to u may be sent, and returns the function
+// to call with its response, or nil on failure, once it is done. It returns
+// ErrDisallowed when robots.txt disallows u, robots.txt itself excepted, and
+// an error wrapping robotstxt.ErrUnreachable when robots.txt could not be
+// fetched, u being worth asking again later.
+func (s *Scheduler) Acquire(ctx context.Context, u *url.URL) (func(*http.Response), error) {
+	key := s.Key(u)
+
+	delay := s.HostDelay
+	if !s.IgnoreRobots {
+		// robots.txt itself is always allowed by the checker
+		match, err := s.Robots.Evaluate(ctx, u.String())
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, robotstxt.ErrUnreachable) {
+			return nil, err
+		}
+		if err == nil && !match.Allowed {
+			return nil, fmt.Errorf("%w: %s", ErrDisallowed, match)
+		}
+		if crawlDelay := s.cap(s.Robots.CrawlDelay(ctx, u.String())); crawlDelay > delay {
+			delay = crawlDelay
+		}
+	}
+
+	for {
+		s.mutex.Lock()
+		h, ok := s.hosts[key]
+		if !ok {
+			h = &host{freed: make(chan struct{})}
+			s.hosts[key] = h
+		}
+		if delay > h.delay {
+			h.delay = delay
+		}
+		now := time.Now()
+		if h.active < s.HostConcurrency && !now.Before(h.next) {
+			h.active++
+			h.next = now.Add(h.delay)
+			s.mutex.Unlock()
+			break
+		}
+		// wait for a request to end, or for the delay when one could start
+		timer := time.NewTimer(h.next.Sub(now))
+		if h.active >= s.HostConcurrency {
+			timer.Stop()
+		}
+		freed := h.freed
+		s.mutex.Unlock()
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		case <-freed:
+			timer.Stop()
+		}
+	}
+
+	release := func(resp *http.Response) { s.release(key, resp) }
+	if err := s.acquireGlobal(ctx); err != nil {
+		release(nil)
+		return nil, err
+	}
+	return func(resp *http.Response) {
+		if s.global != nil {
+			<-s.global
+		}
+		release(resp)
+	}, nil
+}
+
+// acquireGlobal waits for the limits of the process
+func (s *Scheduler) acquireGlobal(ctx context.Context) error {
+	if s.global != nil {
+		select {
+		case s.global <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	s.mutex.Lock()
+	now := time.Now()
+	start := s.nextAny
+	if start.Before(now) {
+		start = now
+	}
+	s.nextAny = start.Add(time.Duration(float64(time.Second) / s.RequestsPerSecond))
+	s.mutex.Unlock()
+
+	timer := time.NewTimer(start.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		if s.global != nil {
+			<-s.global
+		}
+		return ctx.Err()
+	}
+}
+
+// release ends a request of key, pausing it when resp asks to slow down
+func (s *Scheduler) release(key string, resp *http.Response) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	h := s.hosts[key]
+	h.active--
+	close(h.freed)
+	h.freed = make(chan struct{})
+
+	if resp == nil {
+		return
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		h.backoff = 0
+		return
+	}
+	now := time.Now()
+	pause := RetryAfter(resp.Header, now)
+	if pause <= 0 {
+		pause = s.Backoff
+		if h.backoff > 0 {
+			pause = 2 * h.backoff
+		}
+	}
+	h.backoff = s.cap(pause)
+	if next := now.Add(h.backoff); next.After(h.next) {
+		h.next = next
+	}
+}
+
+func (s *Scheduler) cap(d time.Duration) time.Duration {
+	if d > s.MaxDelay {
+		return s.MaxDelay
+	}
+	return d
+}
+
+// RetryAfter returns the pause asked by the Retry-After header, in seconds
+// or as a date, 0 when there is none
+func RetryAfter(header http.Header, now time.Time) time.Duration {
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// Transport returns a RoundTripper sending the requests of base, or of
+// http.DefaultTransport when nil, through the scheduler. A request holds
+// its slot until its response body is closed.
+func (s *Scheduler) Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{scheduler: s, base: base}
+}
+
+type transport struct {
+	scheduler *Scheduler
+	base      http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	release, err := t.scheduler.Acquire(req.Context(), req.URL)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		release(nil)
+		return nil, err
+	}
+	resp.Body = &body{ReadCloser: resp.Body, release: func() { release(resp) }}
+	return resp, nil
+}
+
+// body releases the slot of its request once closed
+type body struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *body) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
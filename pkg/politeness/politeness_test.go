@@ -0,0 +1,199 @@
+package politeness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
+)
+
+func newServer(t *testing.T, robots string, handler http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			if robots == "" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			fmt.Fprint(w, robots)
+			return
+		}
+		handler(w, r)
+	}))
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func TestScheduler_robots(t *testing.T) {
+	server := newServer(t, "User-agent: dmoz-utils\nDisallow: /private\nCrawl-delay: 0.2\n", func(w http.ResponseWriter, r *http.Request) {})
+	defer server.Close()
+
+	s := &Scheduler{HostDelay: -1}
+	client := &http.Client{Transport: s.Transport(nil)}
+	if _, err := get(t, client, server.URL+"/private/page"); !errors.Is(err, ErrDisallowed) {
+		t.Errorf("expected /private to be disallowed, got %v", err)
+	}
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := get(t, client, server.URL+"/public"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("expected the Crawl-delay between the requests, took %s", elapsed)
+	}
+}
+
+func TestScheduler_robotsUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	s := &Scheduler{HostDelay: -1}
+	client := &http.Client{Transport: s.Transport(nil)}
+	// the refusal is told apart from a disallow, to ask again later
+	_, err := get(t, client, server.URL+"/page")
+	if !errors.Is(err, robotstxt.ErrUnreachable) || errors.Is(err, ErrDisallowed) {
+		t.Errorf("expected a server failing to serve robots.txt to refuse everything as unreachable, got %v", err)
+	}
+	if _, err := get(t, client, server.URL+"/robots.txt"); err != nil {
+		t.Errorf("expected robots.txt itself to stay allowed, got %v", err)
+	}
+}
+
+func TestScheduler_hostConcurrency(t *testing.T) {
+	var active, peak int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&active, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		atomic.AddInt32(&active, -1)
+	}
+	a := newServer(t, "", handler)
+	defer a.Close()
+	b := newServer(t, "", handler)
+	defer b.Close()
+
+	s := &Scheduler{HostConcurrency: 2, HostDelay: -1}
+	client := &http.Client{Transport: s.Transport(nil)}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			server := a
+			if i%2 == 1 {
+				server = b
+			}
+			if _, err := get(t, client, server.URL); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 127.0.0.1 is one host for both servers
+	if peak != 2 {
+		t.Errorf("expected at most 2 requests in flight, got %d", peak)
+	}
+}
+
+func TestScheduler_retryAfter(t *testing.T) {
+	var hits int32
+	server := newServer(t, "", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	})
+	defer server.Close()
+
+	s := &Scheduler{HostDelay: -1, IgnoreRobots: true}
+	client := &http.Client{Transport: s.Transport(nil)}
+	start := time.Now()
+	if status, _ := get(t, client, server.URL); status != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", status)
+	}
+	if status, _ := get(t, client, server.URL); status != http.StatusOK {
+		t.Fatalf("expected 200, got %d", status)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected to wait for Retry-After, took %s", elapsed)
+	}
+}
+
+func TestScheduler_backoff(t *testing.T) {
+	s := &Scheduler{HostDelay: -1, Backoff: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond, IgnoreRobots: true}
+	u, _ := url.Parse("http://example.com/")
+	unavailable := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	var pauses []time.Duration
+	for i := 0; i < 4; i++ {
+		release, err := s.Acquire(context.Background(), u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release(unavailable)
+		pauses = append(pauses, s.hosts["example.com"].backoff)
+	}
+	expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i := range expected {
+		if pauses[i] != expected[i] {
+			t.Fatalf("expected the backoffs %v, got %v", expected, pauses)
+		}
+	}
+}
+
+func TestScheduler_key(t *testing.T) {
+	s := &Scheduler{PerDomain: true}
+	for link, expected := range map[string]string{
+		"http://www.example.co.uk/a": "example.co.uk",
+		"https://Shop.Example.com/":  "example.com",
+		"http://127.0.0.1:8080/":     "127.0.0.1",
+	} {
+		u, _ := url.Parse(link)
+		if key := s.Key(u); key != expected {
+			t.Errorf("%s: expected %q, got %q", link, expected, key)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := map[string]time.Duration{
+		"":                              0,
+		"120":                           2 * time.Minute,
+		"-1":                            0,
+		"Wed, 01 Jan 2020 00:00:30 GMT": 30 * time.Second,
+		"Tue, 31 Dec 2019 23:00:00 GMT": 0,
+		"soon":                          0,
+	}
+	for value, expected := range tests {
+		header := http.Header{}
+		header.Set("Retry-After", value)
+		if got := RetryAfter(header, now); got != expected {
+			t.Errorf("%q: expected %s, got %s", value, expected, got)
+		}
+	}
+}
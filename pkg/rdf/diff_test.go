@@ -0,0 +1,159 @@
+package rdf
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const oldDump = `<RDF xmlns:r="http://www.w3.org/TR/RDF/" xmlns:d="http://purl.org/dc/elements/1.0/" xmlns="http://dmoz.org/rdf/">
+  <Topic r:id="Top/Arts/Animation">
+    <catid>3</catid>
+    <link r:resource="http://www.awn.com/"/>
+    <link r:resource="http://www.toonhound.com/"/>
+    <link r:resource="http://www.animationarchive.org/"/>
+  </Topic>
+  <ExternalPage about="http://www.awn.com/">
+    <d:Title>Animation World Network</d:Title>
+    <topic>Top/Arts/Animation</topic>
+  </ExternalPage>
+  <Topic r:id="Top/Arts/Movies">
+    <catid>5</catid>
+    <link r:resource="http://www.imdb.com/"/>
+    <link r:resource="http://www.bfi.org.uk/"/>
+    <link r:resource="http://www.filmsite.org/"/>
+  </Topic>
+  <Topic r:id="Top/Arts/Movies/History">
+    <catid>7</catid>
+    <link r:resource="http://www.bfi.org.uk/"/>
+    <link r:resource="http://www.filmsite.org/"/>
+  </Topic>
+</RDF>`
+
+const newDump = `<RDF xmlns:r="http://www.w3.org/TR/RDF/" xmlns:d="http://purl.org/dc/elements/1.0/" xmlns="http://dmoz.org/rdf/">
+  <Topic r:id="Top/Arts/Animation">
+    <catid>3</catid>
+    <link r:resource="http://www.awn.com/"/>
+  </Topic>
+  <Topic r:id="Top/Arts/Movies/Databases">
+    <catid>6</catid>
+    <link r:resource="http://www.imdb.com/"/>
+    <link r:resource="http://www.allmovie.com/"/>
+  </Topic>
+  <ExternalPage about="http://www.allmovie.com/">
+    <topic>Top/Arts/Movies/Databases</topic>
+  </ExternalPage>
+  <Topic r:id="Top/Arts/Movies/History">
+    <catid>7</catid>
+    <link r:resource="http://www.bfi.org.uk/"/>
+    <link r:resource="http://www.filmsite.org/"/>
+  </Topic>
+  <Topic r:id="Top/Arts/Movies">
+    <catid>5</catid>
+    <link r:resource="http://www.bfi.org.uk/"/>
+  </Topic>
+</RDF>`
+
+func TestDiff(t *testing.T) {
+	old, err := Index(strings.NewReader(oldDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old) != 6 {
+		t.Fatalf("expected 6 indexed urls, got %d", len(old))
+	}
+	if topics := old["http://www.bfi.org.uk/"]; !reflect.DeepEqual(topics, []string{"Top/Arts/Movies", "Top/Arts/Movies/History"}) {
+		t.Errorf("expected both topics of a url, got %v", topics)
+	}
+
+	var changes []Change
+	err = Diff(old, strings.NewReader(newDump), func(c Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bfi.org.uk only has its topics reordered, filmsite.org left one of them
+	expected := []Change{
+		{URL: "http://www.allmovie.com/", Kind: Added, NewTopic: "Top/Arts/Movies/Databases"},
+		{URL: "http://www.filmsite.org/", Kind: Moved, OldTopic: "Top/Arts/Movies"},
+		{URL: "http://www.imdb.com/", Kind: Moved, OldTopic: "Top/Arts/Movies", NewTopic: "Top/Arts/Movies/Databases"},
+		{URL: "http://www.animationarchive.org/", Kind: Removed, OldTopic: "Top/Arts/Animation"},
+		{URL: "http://www.toonhound.com/", Kind: Removed, OldTopic: "Top/Arts/Animation"},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, changes)
+	}
+}
+
+// maxIndexBytes bounds the memory the index takes for every url
+const maxIndexBytes = 256
+
+// largeDump writes a dump listing the urls in [from, to) by topics of 20,
+// the urls from moved on being listed one topic further
+func largeDump(w *io.PipeWriter, from, to, moved int) {
+	fmt.Fprint(w, `<RDF xmlns:r="http://www.w3.org/TR/RDF/" xmlns:d="http://purl.org/dc/elements/1.0/" xmlns="http://dmoz.org/rdf/">`)
+	for i := from; i < to; i += 20 {
+		topic := i / 20
+		if i >= moved {
+			topic++
+		}
+		fmt.Fprintf(w, "<Topic r:id=\"Top/Arts/Topic_%d\"><catid>%d</catid>", topic, topic)
+		for j := i; j < i+20 && j < to; j++ {
+			fmt.Fprintf(w, "<link r:resource=\"http://www.site-%d.example.com/\"/>", j)
+		}
+		fmt.Fprint(w, "</Topic>")
+	}
+	fmt.Fprint(w, "</RDF>")
+	w.Close()
+}
+
+func heapAlloc() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func TestDiff_large(t *testing.T) {
+	urls := 200000
+	if testing.Short() {
+		urls = 20000
+	}
+	before := heapAlloc()
+	r, w := io.Pipe()
+	go largeDump(w, 0, urls, urls)
+	old, err := Index(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(old) != urls {
+		t.Fatalf("indexed %d urls, want %d", len(old), urls)
+	}
+	perURL := (heapAlloc() - before) / uint64(urls)
+	t.Logf("indexed %d urls in %d bytes each", urls, perURL)
+	if perURL > maxIndexBytes {
+		t.Errorf("index takes %d bytes per url, want at most %d", perURL, maxIndexBytes)
+	}
+
+	r, w = io.Pipe()
+	go largeDump(w, urls/4, urls+urls/4, urls/2)
+	counts := make(map[string]int)
+	if err := Diff(old, r, func(c Change) error {
+		counts[c.Kind]++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{Added: urls / 4, Moved: urls / 2, Removed: urls / 4}
+	for kind, n := range want {
+		if counts[kind] != n {
+			t.Errorf("%d %s changes, want %d", counts[kind], kind, n)
+		}
+	}
+}
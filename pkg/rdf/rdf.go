@@ -0,0 +1,309 @@
+// Package rdf streams the DMOZ/Curlie RDF dumps (content.rdf.u8 and friends)
+// element by element, so multi-gigabyte files can be processed in constant
+// memory instead of being loaded into a single DOM.
+package rdf
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resource is an empty element pointing somewhere through its r:resource
+// attribute, eg. <link r:resource="http://www.example.com/"/>
+type Resource struct {
+	URL string `xml:"resource,attr"`
+}
+
+// Topic is a <Topic r:id="Top/..."> element. Links are only found in the
+// content dumps, the other relations only in the structure dumps.
+type Topic struct {
+	ID          string     `xml:"id,attr"`
+	CatID       string     `xml:"catid"`
+	Title       string     `xml:"Title"`
+	Description string     `xml:"Description"`
+	LastUpdate  string     `xml:"lastUpdate"`
+	Link1       []Resource `xml:"link1"`
+	Links       []Resource `xml:"link"`
+	Narrow      []Resource `xml:"narrow"`
+	Narrow1     []Resource `xml:"narrow1"`
+	Narrow2     []Resource `xml:"narrow2"`
+	Symbolic    []Resource `xml:"symbolic"`
+	Symbolic1   []Resource `xml:"symbolic1"`
+	Symbolic2   []Resource `xml:"symbolic2"`
+	Related     []Resource `xml:"related"`
+	AltLang     []Resource `xml:"altlang"`
+	AltLang1    []Resource `xml:"altlang1"`
+	Editors     []Resource `xml:"editor"`
+}
+
+// Kinds of relations between a topic and other topics or editors
+const (
+	EdgeNarrow   = "narrow"
+	EdgeSymbolic = "symbolic"
+	EdgeRelated  = "related"
+	EdgeAltLang  = "altlang"
+	EdgeEditor   = "editor"
+)
+
+// Edge is a typed relation from a topic. Target is a topic path except for
+// editor edges where it is the editor name. Label is the display name
+// carried by symbolic and altlang resources ("Label:Top/...").
+type Edge struct {
+	Kind   string
+	Label  string
+	Target string
+}
+
+func appendEdges(edges []Edge, kind string, resources []Resource) []Edge {
+	for _, r := range resources {
+		e := Edge{Kind: kind, Target: r.URL}
+		if kind == EdgeSymbolic || kind == EdgeAltLang {
+			if i := strings.LastIndex(r.URL, ":"); i > -1 {
+				e.Label, e.Target = r.URL[:i], r.URL[i+1:]
+			}
+		}
+		edges = append(edges, e)
+	}
+	return edges
+}
+
+// Edges returns every narrow, symbolic, related, altlang and editor
+// relation of the topic
+func (t *Topic) Edges() []Edge {
+	var edges []Edge
+	edges = appendEdges(edges, EdgeNarrow, t.Narrow)
+	edges = appendEdges(edges, EdgeNarrow, t.Narrow1)
+	edges = appendEdges(edges, EdgeNarrow, t.Narrow2)
+	edges = appendEdges(edges, EdgeSymbolic, t.Symbolic)
+	edges = appendEdges(edges, EdgeSymbolic, t.Symbolic1)
+	edges = appendEdges(edges, EdgeSymbolic, t.Symbolic2)
+	edges = appendEdges(edges, EdgeRelated, t.Related)
+	edges = appendEdges(edges, EdgeAltLang, t.AltLang)
+	edges = appendEdges(edges, EdgeAltLang, t.AltLang1)
+	edges = appendEdges(edges, EdgeEditor, t.Editors)
+	return edges
+}
+
+// URLs returns the links listed in the topic, link1 first
+func (t *Topic) URLs() []string {
+	urls := make([]string, 0, len(t.Link1)+len(t.Links))
+	for _, l := range t.Link1 {
+		urls = append(urls, l.URL)
+	}
+	for _, l := range t.Links {
+		urls = append(urls, l.URL)
+	}
+	return urls
+}
+
+// ExternalPage is an <ExternalPage about="..."> element describing one
+// listed site. Ages is only set in the Kids & Teens dumps.
+type ExternalPage struct {
+	About       string `xml:"about,attr"`
+	Title       string `xml:"Title"`
+	Description string `xml:"Description"`
+	Priority    string `xml:"priority"`
+	MediaDate   string `xml:"mediadate"`
+	Ages        string `xml:"ages"`
+	Topic       string `xml:"topic"`
+}
+
+// AgeGroups returns the age groups the page is suitable for, eg. kid, teen
+// or mteen
+func (p *ExternalPage) AgeGroups() []string {
+	var groups []string
+	for _, group := range strings.Split(p.Ages, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// DumpName returns the name of a dump file without its extensions, eg.
+// "kt-content" for ./shared/dataset/kt-content.rdf.u8.gz
+func DumpName(path string) string {
+	name := filepath.Base(path)
+	name = strings.TrimSuffix(name, ".gz")
+	name = strings.TrimSuffix(name, ".u8")
+	return strings.TrimSuffix(name, ".rdf")
+}
+
+// IsKidsDump reports whether the dump is one of the Kids & Teens dumps
+func IsKidsDump(path string) bool {
+	return strings.HasPrefix(DumpName(path), "kt-")
+}
+
+// TopLevel returns the category a topic is filed under for the top level
+// datasets, ie. the first level below Top, or the level below the country for
+// Regional and the level below the language for World, along with its parent
+// path. ok is false when the topic is not deep enough.
+func TopLevel(topic string) (category string, parent string, ok bool) {
+	cats := strings.Split(topic, "/")
+	if len(cats) < 2 {
+		return "", "", false
+	}
+	switch cats[1] {
+	case "Regional":
+		if len(cats) < 5 {
+			return "", "", false
+		}
+		return cats[4], strings.Join(cats[:4], "/"), true
+	case "World":
+		if len(cats) < 4 {
+			return "", "", false
+		}
+		return cats[3], strings.Join(cats[:3], "/"), true
+	default:
+		return cats[1], cats[0], true
+	}
+}
+
+// header opens the root element when decoding resumes in the middle of a dump
+const header = `<RDF xmlns:r="http://www.w3.org/TR/RDF/" xmlns:d="http://purl.org/dc/elements/1.0/" xmlns="http://dmoz.org/rdf/">`
+
+// Decoder reads records one at a time from an RDF dump
+type Decoder struct {
+	xml  *xml.Decoder
+	base int64
+}
+
+// NewDecoder returns a Decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{xml: xml.NewDecoder(bufio.NewReaderSize(r, 1<<20))}
+}
+
+// Offset returns the position in the uncompressed dump right after the last
+// record returned by Next. It can be handed back to OpenDecoder to resume.
+func (d *Decoder) Offset() int64 {
+	return d.base + d.xml.InputOffset()
+}
+
+// Next returns the next record of the dump, either a *Topic or an
+// *ExternalPage. It returns io.EOF once the dump is exhausted.
+func (d *Decoder) Next() (interface{}, error) {
+	for {
+		tok, err := d.xml.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "Topic":
+			topic := &Topic{}
+			if err := d.xml.DecodeElement(topic, &start); err != nil {
+				return nil, err
+			}
+			return topic, nil
+		case "ExternalPage":
+			page := &ExternalPage{}
+			if err := d.xml.DecodeElement(page, &start); err != nil {
+				return nil, err
+			}
+			return page, nil
+		}
+	}
+}
+
+// Handler holds the callbacks invoked by Parse, nil callbacks are skipped
+type Handler struct {
+	OnTopic        func(*Topic) error
+	OnExternalPage func(*ExternalPage) error
+}
+
+// Parse streams the dump read from r and hands every record to h. It stops
+// at the first error returned by a callback.
+func Parse(r io.Reader, h Handler) error {
+	d := NewDecoder(r)
+	for {
+		record, err := d.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch record := record.(type) {
+		case *Topic:
+			if h.OnTopic != nil {
+				if err := h.OnTopic(record); err != nil {
+					return err
+				}
+			}
+		case *ExternalPage:
+			if h.OnExternalPage != nil {
+				if err := h.OnExternalPage(record); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+type gzipFile struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (f *gzipFile) Close() error {
+	f.Reader.Close()
+	return f.file.Close()
+}
+
+// OpenDecoder opens the dump at path and returns a Decoder positioned at
+// offset, a value previously returned by Decoder.Offset for the same dump.
+// Plain dumps are seeked directly, compressed ones are decoded up to offset.
+func OpenDecoder(path string, offset int64) (*Decoder, io.Closer, error) {
+	if offset == 0 || strings.HasSuffix(path, ".gz") {
+		file, err := Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		d := NewDecoder(file)
+		for d.Offset() < offset {
+			if _, err := d.Next(); err != nil {
+				file.Close()
+				return nil, nil, err
+			}
+		}
+		return d, file, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	d := NewDecoder(io.MultiReader(strings.NewReader(header), file))
+	d.base = offset - int64(len(header))
+	return d, file, nil
+}
+
+// Open opens a dump on disk, transparently decompressing it when the file
+// name ends with .gz
+func Open(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		return file, nil
+	}
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &gzipFile{Reader: reader, file: file}, nil
+}
@@ -0,0 +1,267 @@
+package rdf
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const contentSample = `<?xml version="1.0" encoding="UTF-8"?>
+<RDF xmlns:r="http://www.w3.org/TR/RDF/" xmlns:d="http://purl.org/dc/elements/1.0/" xmlns="http://dmoz.org/rdf/">
+  <Topic r:id="Top/Arts/Animation">
+    <catid>3</catid>
+    <link1 r:resource="http://www.awn.com/"/>
+    <link r:resource="http://www.toonhound.com/"/>
+    <link r:resource="http://enteract.com/~docjohnson/"/>
+  </Topic>
+  <ExternalPage about="http://www.awn.com/">
+    <d:Title>Animation World Network</d:Title>
+    <d:Description>Provides information resources to the international animation community.</d:Description>
+    <priority>1</priority>
+    <mediadate>2005-03-01</mediadate>
+    <topic>Top/Arts/Animation</topic>
+  </ExternalPage>
+  <Topic r:id="Top/Arts/Animation/Anime">
+    <catid>4</catid>
+  </Topic>
+</RDF>`
+
+var errStop = errors.New("stop")
+
+func TestParse(t *testing.T) {
+	var topics []*Topic
+	var pages []*ExternalPage
+	err := Parse(strings.NewReader(contentSample), Handler{
+		OnTopic: func(topic *Topic) error {
+			topics = append(topics, topic)
+			return nil
+		},
+		OnExternalPage: func(page *ExternalPage) error {
+			pages = append(pages, page)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(topics) != 2 {
+		t.Fatalf("expected 2 topics, got %d", len(topics))
+	}
+	if topics[0].ID != "Top/Arts/Animation" || topics[0].CatID != "3" {
+		t.Errorf("unexpected topic %+v", topics[0])
+	}
+	expected := []string{"http://www.awn.com/", "http://www.toonhound.com/", "http://enteract.com/~docjohnson/"}
+	if urls := topics[0].URLs(); !reflect.DeepEqual(urls, expected) {
+		t.Errorf("expected urls %v, got %v", expected, urls)
+	}
+	if len(topics[1].URLs()) != 0 {
+		t.Errorf("expected no urls, got %v", topics[1].URLs())
+	}
+
+	if len(pages) != 1 {
+		t.Fatalf("expected 1 external page, got %d", len(pages))
+	}
+	page := ExternalPage{
+		About:       "http://www.awn.com/",
+		Title:       "Animation World Network",
+		Description: "Provides information resources to the international animation community.",
+		Priority:    "1",
+		MediaDate:   "2005-03-01",
+		Topic:       "Top/Arts/Animation",
+	}
+	if !reflect.DeepEqual(*pages[0], page) {
+		t.Errorf("expected %+v, got %+v", page, *pages[0])
+	}
+}
+
+func TestParse_stopsOnCallbackError(t *testing.T) {
+	calls := 0
+	err := Parse(strings.NewReader(contentSample), Handler{
+		OnTopic: func(topic *Topic) error {
+			calls++
+			return errStop
+		},
+	})
+	if err != errStop {
+		t.Errorf("expected errStop, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 call, got %d", calls)
+	}
+}
+
+const structureSample = `<?xml version="1.0" encoding="UTF-8"?>
+<RDF xmlns:r="http://www.w3.org/TR/RDF/" xmlns:d="http://purl.org/dc/elements/1.0/" xmlns="http://dmoz.org/rdf/">
+  <Topic r:id="Top/Arts">
+    <catid>2</catid>
+    <d:Title>Arts</d:Title>
+    <lastUpdate>2014-01-11 06:25:24</lastUpdate>
+    <narrow r:resource="Top/Arts/Animation"/>
+    <narrow1 r:resource="Top/Arts/Architecture"/>
+    <symbolic r:resource="Bibliography:Top/Reference/Bibliography"/>
+    <related r:resource="Top/Shopping/Crafts"/>
+    <altlang r:resource="Deutsch:Top/World/Deutsch/Kultur"/>
+    <editor r:resource="jdoe"/>
+  </Topic>
+  <Alias r:id="Top/Arts/Movies:Animation">
+    <d:Title>Animation</d:Title>
+    <Target r:resource="Top/Arts/Animation"/>
+  </Alias>
+</RDF>`
+
+func TestTopic_Edges(t *testing.T) {
+	d := NewDecoder(strings.NewReader(structureSample))
+	record, err := d.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic, ok := record.(*Topic)
+	if !ok {
+		t.Fatalf("expected a topic, got %T", record)
+	}
+	if topic.Title != "Arts" || topic.LastUpdate != "2014-01-11 06:25:24" {
+		t.Errorf("unexpected topic %+v", topic)
+	}
+
+	expected := []Edge{
+		{Kind: EdgeNarrow, Target: "Top/Arts/Animation"},
+		{Kind: EdgeNarrow, Target: "Top/Arts/Architecture"},
+		{Kind: EdgeSymbolic, Label: "Bibliography", Target: "Top/Reference/Bibliography"},
+		{Kind: EdgeRelated, Target: "Top/Shopping/Crafts"},
+		{Kind: EdgeAltLang, Label: "Deutsch", Target: "Top/World/Deutsch/Kultur"},
+		{Kind: EdgeEditor, Target: "jdoe"},
+	}
+	if edges := topic.Edges(); !reflect.DeepEqual(edges, expected) {
+		t.Errorf("expected %+v, got %+v", expected, edges)
+	}
+
+	if _, err := d.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestTopLevel(t *testing.T) {
+	tests := []struct {
+		topic    string
+		category string
+		parent   string
+		ok       bool
+	}{
+		{"Top/Arts/Animation", "Arts", "Top", true},
+		{"Top/Regional/Europe/France/Travel_and_Tourism", "Travel_and_Tourism", "Top/Regional/Europe/France", true},
+		{"Top/Regional/Europe/France", "", "", false},
+		{"Top/World/Deutsch/Kultur/Film", "Kultur", "Top/World/Deutsch", true},
+		{"Top/World/Deutsch", "", "", false},
+		{"Top", "", "", false},
+	}
+	for _, test := range tests {
+		category, parent, ok := TopLevel(test.topic)
+		if category != test.category || parent != test.parent || ok != test.ok {
+			t.Errorf("%s: expected (%q, %q, %v), got (%q, %q, %v)", test.topic, test.category, test.parent, test.ok, category, parent, ok)
+		}
+	}
+}
+
+func TestOpenDecoder_resumesAtOffset(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"content.rdf.u8", "content.rdf.u8.gz"} {
+		path := filepath.Join(dir, name)
+		writeDump(t, path, contentSample)
+
+		d, closer, err := OpenDecoder(path, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Next(); err != nil {
+			t.Fatal(err)
+		}
+		offset := d.Offset()
+		closer.Close()
+
+		d, closer, err = OpenDecoder(path, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []interface{}
+		for {
+			record, err := d.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			records = append(records, record)
+		}
+		closer.Close()
+
+		if len(records) != 2 {
+			t.Fatalf("%s: expected 2 records after the offset, got %d", name, len(records))
+		}
+		if page, ok := records[0].(*ExternalPage); !ok || page.Title != "Animation World Network" {
+			t.Errorf("%s: expected the external page first, got %+v", name, records[0])
+		}
+		if topic, ok := records[1].(*Topic); !ok || topic.ID != "Top/Arts/Animation/Anime" {
+			t.Errorf("%s: expected the last topic, got %+v", name, records[1])
+		}
+	}
+}
+
+func writeDump(t *testing.T, path string, contents string) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var w io.Writer = file
+	if strings.HasSuffix(path, ".gz") {
+		gz := gzip.NewWriter(file)
+		defer gz.Close()
+		w = gz
+	}
+	if _, err := io.WriteString(w, contents); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExternalPage_AgeGroups(t *testing.T) {
+	const sample = `<RDF xmlns:r="http://www.w3.org/TR/RDF/" xmlns:d="http://purl.org/dc/elements/1.0/" xmlns="http://dmoz.org/rdf/">
+  <ExternalPage about="http://www.example.com/">
+    <d:Title>Example</d:Title>
+    <ages>kid, teen</ages>
+    <topic>Kids_and_Teens/Arts</topic>
+  </ExternalPage>
+</RDF>`
+	record, err := NewDecoder(strings.NewReader(sample)).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := record.(*ExternalPage)
+	if groups := page.AgeGroups(); !reflect.DeepEqual(groups, []string{"kid", "teen"}) {
+		t.Errorf("expected [kid teen], got %v", groups)
+	}
+	if groups := (&ExternalPage{}).AgeGroups(); groups != nil {
+		t.Errorf("expected no age groups, got %v", groups)
+	}
+}
+
+func TestDumpName(t *testing.T) {
+	if name := DumpName("./shared/dataset/kt-content.rdf.u8.gz"); name != "kt-content" {
+		t.Errorf("expected kt-content, got %s", name)
+	}
+	if !IsKidsDump("kt-content.rdf.u8") || IsKidsDump("content.rdf.u8") {
+		t.Errorf("kids dump not detected")
+	}
+}
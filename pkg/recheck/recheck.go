@@ -0,0 +1,66 @@
+// Package recheck schedules the visits that keep the websites fresh. A page
+// is checked again after a share of the time it has not changed for, so
+// that pages changing often are visited often and stable ones rarely.
+package recheck
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"time"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+)
+
+// Defaults of a Schedule
+const (
+	DefaultFactor      = 0.5
+	DefaultMinInterval = 24 * time.Hour
+	DefaultMaxInterval = 30 * 24 * time.Hour
+)
+
+// Schedule computes when a page is checked next
+type Schedule struct {
+	// Factor of the time since the page last changed
+	Factor float64
+	// MinInterval and MaxInterval bound the time between two checks
+	MinInterval time.Duration
+	MaxInterval time.Duration
+}
+
+// New returns the Schedule configured by cfg
+func New(cfg config.Recheck) Schedule {
+	s := Schedule{Factor: cfg.Factor, MinInterval: cfg.MinInterval.Duration, MaxInterval: cfg.MaxInterval.Duration}
+	if s.Factor <= 0 {
+		s.Factor = DefaultFactor
+	}
+	if s.MinInterval <= 0 {
+		s.MinInterval = DefaultMinInterval
+	}
+	if s.MaxInterval < s.MinInterval {
+		s.MaxInterval = DefaultMaxInterval
+		if s.MaxInterval < s.MinInterval {
+			s.MaxInterval = s.MinInterval
+		}
+	}
+	return s
+}
+
+// Next returns when a page checked at now, that last changed at changed,
+// is due for its next check
+func (s Schedule) Next(now, changed time.Time) time.Time {
+	interval := time.Duration(s.Factor * float64(now.Sub(changed)))
+	if interval < s.MinInterval {
+		interval = s.MinInterval
+	}
+	if interval > s.MaxInterval {
+		interval = s.MaxInterval
+	}
+	return now.Add(interval)
+}
+
+// Fingerprint identifies the content of a page, to tell whether it changed
+// when the server does not support conditional requests
+func Fingerprint(body []byte) string {
+	sum := sha1.Sum(body)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,40 @@
+package recheck
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+)
+
+func TestSchedule_next(t *testing.T) {
+	s := New(config.Recheck{})
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		changed  time.Time
+		interval time.Duration
+	}{
+		// changed right now, checked again after the minimum
+		{now, day},
+		{now.Add(-10 * day), 5 * day},
+		// stable for a year, checked after the maximum
+		{now.Add(-365 * day), 30 * day},
+	}
+	for _, test := range tests {
+		if next := s.Next(now, test.changed); next.Sub(now) != test.interval {
+			t.Errorf("changed %s before: expected a check after %s, got %s", now.Sub(test.changed), test.interval, next.Sub(now))
+		}
+	}
+
+	s = New(config.Recheck{Factor: 1, MinInterval: config.Duration{Duration: time.Hour}, MaxInterval: config.Duration{Duration: 2 * time.Hour}})
+	if next := s.Next(now, now.Add(-90*time.Minute)); next.Sub(now) != 90*time.Minute {
+		t.Errorf("expected a check after 90m, got %s", next.Sub(now))
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	if Fingerprint([]byte("a")) == Fingerprint([]byte("b")) || Fingerprint([]byte("a")) != Fingerprint([]byte("a")) {
+		t.Error("expected the fingerprint to identify the content")
+	}
+}
@@ -0,0 +1,294 @@
+package robotstxt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a Checker keeps a robots.txt file before fetching
+// it again, as RFC 9309 asks caches not to keep them longer than 24 hours
+const DefaultTTL = 24 * time.Hour
+
+// DefaultRetryDelay is how long a Checker keeps the failure to fetch a
+// robots.txt file before trying again
+const DefaultRetryDelay = 5 * time.Minute
+
+var defaultClient = &http.Client{Timeout: 10 * time.Second}
+
+// ErrNotStored is the error of an origin missing from the Store of an
+// Offline Checker
+var ErrNotStored = errors.New("robotstxt: file not stored")
+
+// ErrUnreachable is the error of the URLs disallowed because the robots.txt
+// file of their origin could not be fetched, or was answered with 429 or a
+// 5xx, which is likely to be temporary, unlike a disallow of the file
+var ErrUnreachable = errors.New("robotstxt: file unreachable")
+
+// File is the robots.txt file of an origin, as answered
+type File struct {
+	// Origin is the scheme, host and port the file rules, eg.
+	// https://example.com:8443
+	Origin string
+	// StatusCode is the status of the response, 0 when there was none
+	StatusCode int
+	Body       string
+	FetchedAt  time.Time
+}
+
+// URL returns the URL of the file
+func (f *File) URL() string {
+	return f.Origin + "/robots.txt"
+}
+
+// Parse parses the file as ParseResponse does
+func (f *File) Parse() (*RobotsTxt, error) {
+	return ParseResponse(f.StatusCode, f.Body, f.URL())
+}
+
+// Store keeps the files of a Checker between runs
+type Store interface {
+	// Load returns the file of origin, nil when there is none
+	Load(origin string) (*File, error)
+	Save(file *File) error
+}
+
+// Checker tells the URLs allowed by the robots.txt file of their origin.
+// The file of an origin is fetched once per TTL, whatever the number of
+// goroutines asking for it, and kept in Store across runs.
+type Checker struct {
+	// UserAgent is the token matched against the groups of the files, sent
+	// as User-Agent when fetching them
+	UserAgent string
+	// TTL is how long a file is kept, DefaultTTL when 0
+	TTL time.Duration
+	// RetryDelay is how long the failure to fetch a file is kept,
+	// DefaultRetryDelay when 0
+	RetryDelay time.Duration
+	// Client fetches the files, with a 10 seconds timeout when nil
+	Client *http.Client
+	// Store keeps the files across runs, nil to only keep them in memory
+	Store Store
+	// Offline only reads the files of Store, whatever their age
+	Offline bool
+
+	mutex sync.Mutex
+	files map[string]*entry
+}
+
+// entry is the file of an origin, ready once loaded is closed
+type entry struct {
+	loaded  chan struct{}
+	file    *File
+	robots  *RobotsTxt
+	err     error
+	expires time.Time
+}
+
+// Origin returns the scheme, host and port of link, the default port left
+// out, which are ruled by the same robots.txt file
+func Origin(link string) (string, error) {
+	u, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if scheme != "http" && scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("robotstxt: %q is not an http URL", link)
+	}
+	host := strings.ToLower(u.Host)
+	host = strings.TrimSuffix(host, map[string]string{"http": ":80", "https": ":443"}[scheme])
+	return scheme + "://" + host, nil
+}
+
+// File returns the robots.txt file of the origin of link, or the error
+// fetching it
+func (c *Checker) File(ctx context.Context, link string) (*File, error) {
+	e, err := c.entry(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return e.file, e.err
+}
+
+// Robots returns the parsed robots.txt file of the origin of link, or the
+// error fetching it along with the unreachable file disallowing everything
+func (c *Checker) Robots(ctx context.Context, link string) (*RobotsTxt, error) {
+	e, err := c.entry(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	return e.robots, e.err
+}
+
+// Evaluate checks if link is allowed to UserAgent. The robots.txt file
+// itself is always allowed, and the URLs of an origin whose file could not be
+// fetched are disallowed, as an unreachable file is by RFC 9309, along with
+// ErrUnreachable. Offline, an origin missing from Store is crawled as one
+// without file.
+func (c *Checker) Evaluate(ctx context.Context, link string) (Match, error) {
+	if isRobotsTxt(link) {
+		return Match{Allowed: true}, nil
+	}
+	e, err := c.entry(ctx, link)
+	if err != nil {
+		return Match{}, err
+	}
+	if e.robots == nil {
+		return Match{Allowed: true}, nil
+	}
+	match, err := e.robots.Evaluate(c.UserAgent, link)
+	if err == nil && match.Unreachable {
+		origin, _ := Origin(link)
+		err = fmt.Errorf("%w: %s", ErrUnreachable, origin)
+		if e.err != nil {
+			err = fmt.Errorf("%w: %v", err, e.err)
+		}
+	}
+	return match, err
+}
+
+// IsAllowed checks if link is allowed to UserAgent, as Evaluate does
+func (c *Checker) IsAllowed(ctx context.Context, link string) (bool, error) {
+	match, err := c.Evaluate(ctx, link)
+	return match.Allowed, err
+}
+
+// CrawlDelay returns the crawl delay of UserAgent on the origin of link, 0
+// if there is none
+func (c *Checker) CrawlDelay(ctx context.Context, link string) time.Duration {
+	robots, err := c.Robots(ctx, link)
+	if err != nil {
+		return 0
+	}
+	return robots.CrawlDelay(c.UserAgent)
+}
+
+func isRobotsTxt(link string) bool {
+	u, err := url.Parse(link)
+	return err == nil && u.Path == "/robots.txt"
+}
+
+// entry returns the entry of the origin of link, loading it when missing or
+// expired
+func (c *Checker) entry(ctx context.Context, link string) (*entry, error) {
+	origin, err := Origin(link)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mutex.Lock()
+	if c.files == nil {
+		c.files = make(map[string]*entry)
+	}
+	e, ok := c.files[origin]
+	if ok {
+		select {
+		case <-e.loaded:
+			ok = time.Now().Before(e.expires)
+		default:
+		}
+	}
+	if !ok {
+		e = &entry{loaded: make(chan struct{})}
+		c.files[origin] = e
+	}
+	c.mutex.Unlock()
+
+	if !ok {
+		// the file outlives the context of the first request asking for it
+		go c.load(e, origin)
+	}
+	select {
+	case <-e.loaded:
+		return e, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// load reads the file of origin from Store while fresh, fetches it again
+// otherwise, and parses it
+func (c *Checker) load(e *entry, origin string) {
+	defer close(e.loaded)
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	if c.Store != nil {
+		file, err := c.Store.Load(origin)
+		if err == nil && file != nil && (c.Offline || time.Since(file.FetchedAt) < ttl) {
+			e.file = file
+		}
+	}
+	switch {
+	case e.file != nil:
+	case c.Offline:
+		e.err = fmt.Errorf("%w: %s", ErrNotStored, origin)
+	default:
+		e.file, e.err = c.fetch(origin)
+		// a file that could not be saved is still used for the run
+		if e.err == nil && c.Store != nil {
+			c.Store.Save(e.file)
+		}
+		if e.err != nil {
+			// no response at all is an unreachable file, disallowing everything
+			e.robots, _ = ParseResponse(0, "", origin+"/robots.txt")
+		}
+	}
+	if e.err == nil {
+		e.robots, e.err = e.file.Parse()
+	}
+
+	switch {
+	case c.Offline:
+		e.expires = time.Now().Add(ttl)
+	case e.file == nil:
+		// a network failure is short lived, the file is asked for again soon
+		retryDelay := c.RetryDelay
+		if retryDelay <= 0 {
+			retryDelay = DefaultRetryDelay
+		}
+		e.expires = time.Now().Add(retryDelay)
+	default:
+		e.expires = e.file.FetchedAt.Add(ttl)
+	}
+}
+
+// fetch downloads the file of origin with Client
+func (c *Checker) fetch(origin string) (*File, error) {
+	file := &File{Origin: origin}
+	req, err := http.NewRequest(http.MethodGet, file.URL(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+	client := c.Client
+	if client == nil {
+		client = defaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// one byte more tells a file cut at MaxSize
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, MaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	file.StatusCode = resp.StatusCode
+	file.Body = string(body)
+	file.FetchedAt = time.Now().UTC()
+	return file, nil
+}
@@ -0,0 +1,182 @@
+package robotstxt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// memoryStore is a Store of a map
+type memoryStore struct {
+	mutex sync.Mutex
+	files map[string]File
+}
+
+func (s *memoryStore) Load(origin string) (*File, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	file, ok := s.files[origin]
+	if !ok {
+		return nil, nil
+	}
+	return &file, nil
+}
+
+func (s *memoryStore) Save(file *File) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.files[file.Origin] = *file
+	return nil
+}
+
+func TestChecker(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			atomic.AddInt32(&fetches, 1)
+			if r.Header.Get("User-Agent") != "examplebot" {
+				t.Errorf("expected the user agent to be sent, got %q", r.Header.Get("User-Agent"))
+			}
+			fmt.Fprint(w, "User-agent: examplebot\nDisallow: /private\nCrawl-delay: 2\n")
+		}
+	}))
+	defer server.Close()
+
+	store := &memoryStore{files: make(map[string]File)}
+	c := &Checker{UserAgent: "examplebot", Store: store}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if allowed, err := c.IsAllowed(ctx, server.URL+"/private/page"); err != nil || allowed {
+				t.Errorf("expected /private to be disallowed, got %v %v", allowed, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed, _ := c.IsAllowed(ctx, server.URL+"/public?q=1"); !allowed {
+		t.Errorf("expected /public to be allowed")
+	}
+	if c.CrawlDelay(ctx, server.URL+"/") != 2*time.Second {
+		t.Errorf("expected the crawl delay of the file")
+	}
+	if fetches != 1 {
+		t.Errorf("expected the file to be fetched once, got %d fetches", fetches)
+	}
+
+	// another run reads the file kept in the store while fresh
+	again := &Checker{UserAgent: "examplebot", Store: store}
+	if allowed, _ := again.IsAllowed(ctx, server.URL+"/private"); allowed || fetches != 1 {
+		t.Errorf("expected the stored file to be used, got %d fetches", fetches)
+	}
+
+	// and fetches it again past the TTL
+	origin, _ := Origin(server.URL)
+	stale := store.files[origin]
+	stale.FetchedAt = stale.FetchedAt.Add(-DefaultTTL)
+	store.files[origin] = stale
+	expired := &Checker{UserAgent: "examplebot", Store: store}
+	if _, err := expired.File(ctx, server.URL+"/"); err != nil || fetches != 2 {
+		t.Errorf("expected an expired file to be fetched again, got %d fetches, %v", fetches, err)
+	}
+	if !store.files[origin].FetchedAt.After(stale.FetchedAt) {
+		t.Errorf("expected the new file to be stored")
+	}
+}
+
+func TestChecker_status(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	c := &Checker{UserAgent: "examplebot"}
+	ctx := context.Background()
+	match, err := c.Evaluate(ctx, server.URL+"/page")
+	if !errors.Is(err, ErrUnreachable) || match.Allowed || !match.Unreachable {
+		t.Errorf("expected an unreachable file to disallow everything, got %+v %v", match, err)
+	}
+	if allowed, _ := c.IsAllowed(ctx, server.URL+"/robots.txt"); !allowed {
+		t.Errorf("expected robots.txt itself to be allowed")
+	}
+
+	// no response at all is an unreachable file
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	match, err = c.Evaluate(ctx, unreachable.URL+"/page")
+	if !errors.Is(err, ErrUnreachable) || match.Allowed || !match.Unreachable {
+		t.Errorf("expected an origin without response to be disallowed, got %+v %v", match, err)
+	}
+	if _, err := c.Robots(ctx, unreachable.URL+"/"); err == nil {
+		t.Errorf("expected the error fetching the file")
+	}
+	if _, err := c.IsAllowed(ctx, "mailto:someone@example.com"); err == nil {
+		t.Errorf("expected an error for a link without robots.txt")
+	}
+
+	offline := &Checker{Offline: true, Store: &memoryStore{files: make(map[string]File)}}
+	if _, err := offline.File(ctx, server.URL+"/"); !errors.Is(err, ErrNotStored) {
+		t.Errorf("expected ErrNotStored offline, got %v", err)
+	}
+}
+
+// failingTransport fails the requests while failures is positive
+type failingTransport struct {
+	failures int32
+}
+
+func (t *failingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.AddInt32(&t.failures, -1) >= 0 {
+		return nil, errors.New("connection refused")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestChecker_retry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "User-agent: *\nDisallow: /private\n")
+	}))
+	defer server.Close()
+
+	transport := &failingTransport{failures: 1}
+	c := &Checker{RetryDelay: 50 * time.Millisecond, Client: &http.Client{Transport: transport}}
+	ctx := context.Background()
+	if allowed, _ := c.IsAllowed(ctx, server.URL+"/public"); allowed {
+		t.Errorf("expected a failed fetch to disallow everything")
+	}
+	if allowed, _ := c.IsAllowed(ctx, server.URL+"/public"); allowed {
+		t.Errorf("expected the failure to be kept until RetryDelay")
+	}
+
+	// the failure is forgotten after RetryDelay, well before the TTL
+	time.Sleep(60 * time.Millisecond)
+	if allowed, err := c.IsAllowed(ctx, server.URL+"/public"); err != nil || !allowed {
+		t.Errorf("expected the file to be fetched again, got %v %v", allowed, err)
+	}
+	if allowed, _ := c.IsAllowed(ctx, server.URL+"/private"); allowed {
+		t.Errorf("expected the rules of the file fetched again")
+	}
+}
+
+func TestOrigin(t *testing.T) {
+	tests := map[string]string{
+		"http://www.Example.com/a/b?c": "http://www.example.com",
+		"https://example.com:443/":     "https://example.com",
+		"https://example.com:8443/":    "https://example.com:8443",
+		"HTTP://example.com:80":        "http://example.com",
+	}
+	for link, expected := range tests {
+		if origin, err := Origin(link); err != nil || origin != expected {
+			t.Errorf("%s: expected %s, got %s %v", link, expected, origin, err)
+		}
+	}
+}
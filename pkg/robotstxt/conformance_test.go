@@ -0,0 +1,337 @@
+package robotstxt
+
+import (
+	"strings"
+	"testing"
+)
+
+// conformance is a robots.txt file and the URLs an agent may or may not
+// crawl by it, from the examples of RFC 9309 and of the Google
+// specification
+type conformance struct {
+	name       string
+	statusCode int
+	contents   string
+	userAgent  string
+	allowed    []string
+	disallowed []string
+}
+
+func (c conformance) run(t *testing.T) {
+	robots, err := ParseResponse(c.statusCode, c.contents, "http://example.com/robots.txt")
+	if err != nil {
+		t.Fatalf("%s: %s", c.name, err)
+	}
+	for _, path := range c.allowed {
+		if allowed, err := robots.IsAllowed(c.userAgent, "http://example.com"+path); err != nil || !allowed {
+			t.Errorf("%s: expected %s to be allowed for %s", c.name, path, c.userAgent)
+		}
+	}
+	for _, path := range c.disallowed {
+		if allowed, err := robots.IsAllowed(c.userAgent, "http://example.com"+path); err != nil || allowed {
+			t.Errorf("%s: expected %s to be disallowed for %s", c.name, path, c.userAgent)
+		}
+	}
+}
+
+// rfcExample is the example of RFC 9309, section 5.1
+const rfcExample = `
+User-Agent: *
+Disallow: *.gif$
+Disallow: /example/
+Allow: /publications/
+
+User-Agent: foobot
+Disallow:/
+Allow:/example/page.html
+Allow:/example/allowed.gif
+
+User-Agent: barbot
+User-Agent: bazbot
+Disallow: /example/page.html
+
+User-Agent: quxbot
+
+EOF
+`
+
+func TestConformance_rfc9309(t *testing.T) {
+	tests := []conformance{
+		{
+			name:       "foobot",
+			statusCode: 200,
+			contents:   rfcExample,
+			userAgent:  "foobot",
+			allowed:    []string{"/example/page.html", "/example/allowed.gif"},
+			disallowed: []string{"/", "/other", "/publications/"},
+		},
+		{
+			name:       "barbot and bazbot share a group",
+			statusCode: 200,
+			contents:   rfcExample,
+			userAgent:  "bazbot",
+			allowed:    []string{"/example/", "/publications/", "/image.gif"},
+			disallowed: []string{"/example/page.html"},
+		},
+		{
+			name:       "an empty group allows everything",
+			statusCode: 200,
+			contents:   rfcExample,
+			userAgent:  "quxbot",
+			allowed:    []string{"/example/page.html", "/image.gif"},
+		},
+		{
+			name:       "other agents fall back to *",
+			statusCode: 200,
+			contents:   rfcExample,
+			userAgent:  "ExampleBot/1.1",
+			allowed:    []string{"/publications/", "/page.html"},
+			disallowed: []string{"/example/", "/example/page.html"},
+		},
+		{
+			name:       "groups of the same agent are merged",
+			statusCode: 200,
+			contents:   "user-agent: ExampleBot\ndisallow: /foo\ndisallow: /bar\n\nuser-agent: ExampleBot\ndisallow: /baz\n",
+			userAgent:  "examplebot",
+			allowed:    []string{"/", "/qux"},
+			disallowed: []string{"/foo", "/bar", "/baz"},
+		},
+		{
+			name:       "the product token is matched case-insensitively",
+			statusCode: 200,
+			contents:   "User-Agent: ExampleBot\nDisallow: /\n",
+			userAgent:  "EXAMPLEBOT/2.0 (+https://example.com/bot)",
+			disallowed: []string{"/", "/page"},
+		},
+		{
+			name:       "an empty disallow rule matches nothing",
+			statusCode: 200,
+			contents:   "User-agent: *\nDisallow:\n",
+			userAgent:  "examplebot",
+			allowed:    []string{"/", "/page"},
+		},
+		{
+			name:       "comments end at the line end",
+			statusCode: 200,
+			contents:   "User-agent: * # every agent\nDisallow: /private # not for you\n# Disallow: /\n",
+			userAgent:  "examplebot",
+			allowed:    []string{"/", "/public"},
+			disallowed: []string{"/private", "/private/page"},
+		},
+		{
+			name:       "a byte order mark and CR line ends are accepted",
+			statusCode: 200,
+			contents:   "\ufeffUser-agent: *\rDisallow: /private\r",
+			userAgent:  "examplebot",
+			allowed:    []string{"/"},
+			disallowed: []string{"/private"},
+		},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestConformance_google(t *testing.T) {
+	tests := []conformance{
+		{
+			name:       "the groups of an agent are merged around others",
+			statusCode: 200,
+			contents:   "user-agent: googlebot-news\ndisallow: /fish\n\nuser-agent: *\ndisallow: /carrots\n\nuser-agent: googlebot-news\ndisallow: /shrimp\n",
+			userAgent:  "googlebot-news",
+			allowed:    []string{"/carrots"},
+			disallowed: []string{"/fish", "/shrimp"},
+		},
+		{
+			name:       "user-agent lines in a row share a group",
+			statusCode: 200,
+			contents:   "user-agent: e\nuser-agent: f\ndisallow: /g\n\nuser-agent: h\n",
+			userAgent:  "f",
+			disallowed: []string{"/g"},
+		},
+		{
+			name:       "a trailing user-agent gets an empty group",
+			statusCode: 200,
+			contents:   "user-agent: e\nuser-agent: f\ndisallow: /g\n\nuser-agent: h\n",
+			userAgent:  "h",
+			allowed:    []string{"/g"},
+		},
+		{
+			name:       "a sitemap does not end the user-agent lines",
+			statusCode: 200,
+			contents:   "user-agent: a\nsitemap: https://example.com/sitemap.xml\n\nuser-agent: b\ndisallow: /\n",
+			userAgent:  "a",
+			disallowed: []string{"/"},
+		},
+		{
+			name:       "a specific group replaces *",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /\n\nuser-agent: googlebot\nallow: /\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/", "/page"},
+		},
+		{
+			name:       "the longest path wins",
+			statusCode: 200,
+			contents:   "user-agent: *\nallow: /p\ndisallow: /\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/page"},
+			disallowed: []string{"/", "/other"},
+		},
+		{
+			name:       "paths are case-sensitive",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /fish\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/Fish", "/catfish"},
+			disallowed: []string{"/fish", "/fish.html", "/fish/salmon.html", "/fishheads"},
+		},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestConformance_precedence(t *testing.T) {
+	tests := []conformance{
+		{
+			name:       "an allow and a disallow of the same length allow",
+			statusCode: 200,
+			contents:   "user-agent: *\nallow: /folder\ndisallow: /folder\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/folder/page"},
+		},
+		{
+			name:       "a longer pattern wins over a path",
+			statusCode: 200,
+			contents:   "user-agent: *\nallow: /page\ndisallow: /*.html\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/page", "/page.htm"},
+			disallowed: []string{"/page.html", "/index.html"},
+		},
+		{
+			name:       "a longer path wins over a pattern",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /*.php\nallow: /fish/index.php\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/fish/index.php", "/fish/index.php?id=1"},
+			disallowed: []string{"/fish/other.php", "/index.php"},
+		},
+		{
+			name:       "an end anchor",
+			statusCode: 200,
+			contents:   "user-agent: *\nallow: /$\ndisallow: /\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/"},
+			disallowed: []string{"/page.htm"},
+		},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestConformance_patterns(t *testing.T) {
+	tests := []conformance{
+		{
+			name:       "a trailing wildcard is ignored",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /fish*\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/Fish.asp", "/catfish", "/?id=fish", "/desert/fish"},
+			disallowed: []string{"/fish", "/fish.html", "/fish/salmon.html", "/fishheads", "/fishheads/yummy.html", "/fish.php?id=anything"},
+		},
+		{
+			name:       "a directory",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /fish/\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/fish", "/fish.html", "/animals/fish/", "/Fish/Salmon.asp"},
+			disallowed: []string{"/fish/", "/fish/?id=anything", "/fish/salmon.htm"},
+		},
+		{
+			name:       "a wildcard",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /*.php\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/", "/windows.PHP"},
+			disallowed: []string{"/index.php", "/filename.php", "/folder/filename.php", "/folder/filename.php?parameters", "/folder/any.php.file.html", "/filename.php/"},
+		},
+		{
+			name:       "a wildcard and an end anchor",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /*.php$\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/filename.php?parameters", "/filename.php/", "/filename.php5", "/windows.PHP"},
+			disallowed: []string{"/filename.php", "/folder/filename.php"},
+		},
+		{
+			name:       "a wildcard in the middle",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /fish*.php\n",
+			userAgent:  "googlebot",
+			allowed:    []string{"/Fish.PHP", "/shop/fish.php"},
+			disallowed: []string{"/fish.php", "/fishheads/catfish.php?parameters"},
+		},
+		{
+			name:       "the query is matched",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /foo/bar?baz=quz\n",
+			userAgent:  "examplebot",
+			allowed:    []string{"/foo/bar", "/foo/bar?baz=qux"},
+			disallowed: []string{"/foo/bar?baz=quz", "/foo/bar?baz=quz&a=b"},
+		},
+		{
+			name:       "UTF-8 and percent-encoded paths are the same",
+			statusCode: 200,
+			contents:   "user-agent: *\ndisallow: /foo/bar/ツ\ndisallow: /foo/baz/%E3%83%84\n",
+			userAgent:  "examplebot",
+			disallowed: []string{"/foo/bar/%E3%83%84", "/foo/bar/ツ", "/foo/baz/ツ", "/foo/baz/%E3%83%84"},
+		},
+	}
+	for _, test := range tests {
+		test.run(t)
+	}
+}
+
+func TestConformance_statusCodes(t *testing.T) {
+	contents := "User-agent: *\nDisallow: /private\n"
+	tests := []conformance{
+		{name: "200 is parsed", statusCode: 200, contents: contents, allowed: []string{"/"}, disallowed: []string{"/private"}},
+		{name: "an unfollowed redirect is unavailable", statusCode: 301, contents: contents, allowed: []string{"/", "/private"}},
+		{name: "401 is unavailable", statusCode: 401, allowed: []string{"/", "/private"}},
+		{name: "403 is unavailable", statusCode: 403, allowed: []string{"/", "/private"}},
+		{name: "404 is unavailable", statusCode: 404, contents: "<html>not found</html>", allowed: []string{"/", "/private"}},
+		{name: "410 is unavailable", statusCode: 410, allowed: []string{"/"}},
+		{name: "429 is unreachable", statusCode: 429, disallowed: []string{"/", "/public"}},
+		{name: "500 is unreachable", statusCode: 500, contents: contents, disallowed: []string{"/", "/public"}},
+		{name: "503 is unreachable", statusCode: 503, disallowed: []string{"/"}},
+		{name: "no response is unreachable", statusCode: 0, disallowed: []string{"/"}},
+	}
+	for _, test := range tests {
+		test.userAgent = "examplebot"
+		test.run(t)
+	}
+}
+
+func TestConformance_maxSize(t *testing.T) {
+	padding := "# " + strings.Repeat("x", MaxSize) + "\n"
+	conformance{
+		name:       "the rules within MaxSize are parsed",
+		statusCode: 200,
+		contents:   "User-agent: *\nDisallow: /first\n" + padding + "Disallow: /last\n",
+		userAgent:  "examplebot",
+		allowed:    []string{"/last"},
+		disallowed: []string{"/first"},
+	}.run(t)
+
+	// a rule cut at MaxSize is dropped rather than shortened
+	cut := "User-agent: *\n" + strings.Repeat("#", MaxSize-len("User-agent: *\n")-len("Disallow: /a")) + "\nDisallow: /ab\n"
+	conformance{
+		name:       "a rule cut at MaxSize is dropped",
+		statusCode: 200,
+		contents:   cut,
+		userAgent:  "examplebot",
+		allowed:    []string{"/a", "/ab"},
+	}.run(t)
+}
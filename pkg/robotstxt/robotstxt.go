@@ -1,11 +1,14 @@
 // Package robotstxt parses robots.txt files
 //
-// Aims to follow the Google robots.txt specification, see:
+// Aims to follow RFC 9309 and the Google robots.txt specification, see:
+// https://www.rfc-editor.org/rfc/rfc9309.html
 // https://developers.google.com/search/reference/robots_txt
 // for more information.
 package robotstxt
 
 import (
+	"fmt"
+	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
@@ -15,23 +18,92 @@ import (
 	"golang.org/x/net/idna"
 )
 
+// Rule is an allow or disallow line of a group
+type Rule struct {
+	// Line is the line number of the rule in the file, from 1
+	Line int
+	// Directive is allow or disallow
+	Directive string
+	// Pattern is the path of the rule, as written in the file
+	Pattern string
+}
+
 type rule struct {
+	Rule
 	isAllowed bool
 	path      string
 	pattern   *regexp.Regexp
 }
 
+// Match explains the result of IsAllowed for a URL
+type Match struct {
+	Allowed bool
+	// Group is the user-agent of the group applied, * for the default one,
+	// empty when no group applies
+	Group string
+	// Rule is the rule deciding, the one with the longest path matching
+	// the URL, nil when no rule matches
+	Rule *Rule
+	// Length is the length of the path of Rule, its precedence
+	Length int
+	// Unreachable is set when every URL is disallowed because the file
+	// could not be fetched
+	Unreachable bool
+}
+
+// String describes the match, eg. "disallow: /private (line 3, user-agent *)"
+func (m Match) String() string {
+	switch {
+	case m.Unreachable:
+		return "robots.txt unreachable"
+	case m.Rule != nil:
+		return fmt.Sprintf("%s: %s (line %d, user-agent %s)", m.Rule.Directive, m.Rule.Pattern, m.Rule.Line, m.Group)
+	case m.Group != "":
+		return fmt.Sprintf("no rule matching (user-agent %s)", m.Group)
+	}
+	return "no group"
+}
+
+// MaxSize is the part of a robots.txt file parsed by ParseResponse, as
+// crawlers must parse at least 500KiB
+const MaxSize = 500 << 10
+
 type group struct {
 	rules      []*rule
 	crawlDelay time.Duration
 }
 
+// Group lists the rules of a user-agent, the groups of the file naming it
+// merged
+type Group struct {
+	// UserAgent is the normalised user-agent, eg. googlebot or *
+	UserAgent  string
+	Rules      []Rule
+	CrawlDelay time.Duration
+}
+
+// Directive is a line of the file the parser does not act upon, a
+// non-standard directive as Clean-param or Request-rate
+type Directive struct {
+	// Line is the line number of the directive in the file, from 1
+	Line int
+	// Name is the lower case name of the directive, Value its value as
+	// written
+	Name  string
+	Value string
+}
+
 // RobotsTxt represents a parsed robots.txt file
 type RobotsTxt struct {
 	url      *url.URL
 	groups   map[string]*group
 	sitemaps []string
 	host     string
+	// agents are the keys of groups in the order of the file
+	agents     []string
+	directives []Directive
+	// disallowAll is set when the file could not be fetched
+	disallowAll bool
 }
 
 // InvalidHostError is the error when a URL is tested with IsAllowed that
@@ -42,6 +114,16 @@ func (e InvalidHostError) Error() string {
 	return "URL is not valid for this robots.txt file"
 }
 
+// URL returns the URL of the robots.txt file ruling link, the one at the
+// root of its scheme, host and port
+func URL(link string) (string, error) {
+	origin, err := Origin(link)
+	if err != nil {
+		return "", err
+	}
+	return origin + "/robots.txt", nil
+}
+
 func parseAndNormalizeURL(urlStr string) (u *url.URL, err error) {
 	u, err = url.Parse(urlStr)
 	if err == nil {
@@ -64,7 +146,8 @@ func isPattern(path string) bool {
 }
 
 func compilePattern(pattern string) (*regexp.Regexp, error) {
-	pattern = regexp.QuoteMeta(pattern)
+	// patterns match from the start of the path
+	pattern = "^" + regexp.QuoteMeta(pattern)
 	pattern = strings.Replace(pattern, "\\*", "(?:.*)", -1)
 
 	pattern = replaceSuffix(pattern, "\\$", "$")
@@ -85,30 +168,27 @@ func normaliseUserAgent(userAgent string) string {
 	return strings.ToLower(strings.TrimSpace(userAgent))
 }
 
-func (r *group) isAllowed(userAgent string, path string) bool {
-	var result = true
-	var resultPathLength = 0
-
+// evaluate returns the rule deciding for path and the length of its path.
+// The longest path matching takes precedence, patterns included, and allow
+// wins over disallow between paths of the same length.
+func (r *group) evaluate(path string) (result *rule, resultPathLength int) {
 	for _, rule := range r.rules {
 		if rule.pattern != nil {
-			// The first matching pattern takes precedence
-			if rule.pattern.MatchString(path) {
-				return rule.isAllowed
-			}
-		} else {
-			// The longest matching path takes precedence
-			if resultPathLength > len(rule.path) {
+			if !rule.pattern.MatchString(path) {
 				continue
 			}
+		} else if !strings.HasPrefix(path, rule.path) {
+			continue
+		}
 
-			if strings.HasPrefix(path, rule.path) {
-				result = rule.isAllowed
-				resultPathLength = len(rule.path)
-			}
+		length := len(rule.path)
+		if result == nil || length > resultPathLength || length == resultPathLength && rule.isAllowed && !result.isAllowed {
+			result = rule
+			resultPathLength = length
 		}
 	}
 
-	return result
+	return
 }
 
 // Parse parses the contents or a robots.txt file and returns a
@@ -128,8 +208,14 @@ func Parse(contents string, urlStr string) (robotsTxt *RobotsTxt, err error) {
 	var userAgents []string
 	isNoneUserAgentState := false
 
+	contents = strings.TrimPrefix(contents, "\ufeff")
+	contents = strings.Replace(contents, "\r\n", "\n", -1)
+	contents = strings.Replace(contents, "\r", "\n", -1)
 	lines := strings.Split(contents, "\n")
-	for _, line := range lines {
+	for number, line := range lines {
+		if index := strings.IndexRune(line, '#'); index > -1 {
+			line = line[:index]
+		}
 		parts := strings.SplitN(line, ":", 2)
 		if len(parts) > 1 {
 			rule, val := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
@@ -140,15 +226,17 @@ func Parse(contents string, urlStr string) (robotsTxt *RobotsTxt, err error) {
 					userAgents = nil
 				}
 				userAgents = append(userAgents, normaliseUserAgent(val))
+				// an agent without rules still has a group, an empty one
+				robotsTxt.group(normaliseUserAgent(val))
 				break
 			case "allow":
 				for _, ua := range userAgents {
-					robotsTxt.addPathRule(ua, val, true)
+					robotsTxt.addPathRule(ua, val, true, number+1)
 				}
 				break
 			case "disallow":
 				for _, ua := range userAgents {
-					robotsTxt.addPathRule(ua, val, false)
+					robotsTxt.addPathRule(ua, val, false, number+1)
 				}
 				break
 			case "crawl-delay":
@@ -166,20 +254,75 @@ func Parse(contents string, urlStr string) (robotsTxt *RobotsTxt, err error) {
 					robotsTxt.host = val
 				}
 				break
+			default:
+				if rule != "" {
+					robotsTxt.directives = append(robotsTxt.directives, Directive{Line: number + 1, Name: strings.ToLower(rule), Value: val})
+				}
 			}
 
-			isNoneUserAgentState = strings.ToLower(rule) != "user-agent"
+			// the records outside of the groups, as sitemap, do not end
+			// the user-agent lines of a group
+			switch strings.ToLower(rule) {
+			case "user-agent":
+				isNoneUserAgentState = false
+			case "allow", "disallow", "crawl-delay":
+				isNoneUserAgentState = true
+			}
 		}
 	}
 
 	return
 }
 
-func (r *RobotsTxt) addPathRule(userAgent string, path string, isAllowed bool) error {
+// ParseResponse parses the robots.txt file answered with statusCode, a
+// statusCode of 0 standing for a file that could not be fetched. As RFC 9309
+// has it, only the first MaxSize bytes of a successful response are parsed,
+// an unavailable file (a redirect left unfollowed or 4xx) allows every URL,
+// and an unreachable one (5xx, 429 as Google does, or no response)
+// disallows them all.
+func ParseResponse(statusCode int, body string, urlStr string) (robotsTxt *RobotsTxt, err error) {
+	switch {
+	case statusCode >= 200 && statusCode < 300:
+		if len(body) > MaxSize {
+			body = body[:MaxSize]
+			// drop the line cut in the middle
+			if index := strings.LastIndexAny(body, "\r\n"); index > -1 {
+				body = body[:index]
+			}
+		}
+		return Parse(body, urlStr)
+	case statusCode >= 300 && statusCode < 500 && statusCode != http.StatusTooManyRequests:
+		return Parse("", urlStr)
+	}
+
+	robotsTxt, err = Parse("", urlStr)
+	if err == nil {
+		robotsTxt.disallowAll = true
+	}
+	return
+}
+
+// group returns the group of userAgent, adding it when missing
+func (r *RobotsTxt) group(userAgent string) *group {
 	g, ok := r.groups[userAgent]
 	if !ok {
 		g = &group{}
 		r.groups[userAgent] = g
+		r.agents = append(r.agents, userAgent)
+	}
+	return g
+}
+
+func (r *RobotsTxt) addPathRule(userAgent string, path string, isAllowed bool, line int) error {
+	g := r.group(userAgent)
+	info := Rule{Line: line, Directive: "disallow", Pattern: path}
+	if isAllowed {
+		info.Directive = "allow"
+	}
+
+	// an empty rule matches nothing, the group still is the one of the agent
+	if path == "" {
+		return nil
 	}
 
 	isPattern := isPattern(path)
@@ -202,11 +345,14 @@ func (r *RobotsTxt) addPathRule(userAgent string, path string, isAllowed bool) e
 		}
 
 		g.rules = append(g.rules, &rule{
+			Rule:      info,
+			path:      path,
 			pattern:   regexPattern,
 			isAllowed: isAllowed,
 		})
 	} else {
 		g.rules = append(g.rules, &rule{
+			Rule:      info,
 			path:      path,
 			isAllowed: isAllowed,
 		})
@@ -216,11 +362,7 @@ func (r *RobotsTxt) addPathRule(userAgent string, path string, isAllowed bool) e
 }
 
 func (r *RobotsTxt) addCrawlDelay(userAgent string, crawlDelay string) (err error) {
-	g, ok := r.groups[userAgent]
-	if !ok {
-		g = &group{}
-		r.groups[userAgent] = g
-	}
+	g := r.group(userAgent)
 
 	if delay, err := strconv.ParseFloat(crawlDelay, 64); err == nil {
 		g.crawlDelay = time.Duration(delay * float64(time.Second))
@@ -253,8 +395,42 @@ func (r *RobotsTxt) Sitemaps() []string {
 	return r.sitemaps
 }
 
+// Groups returns the groups of the file, in the order their user-agent
+// first appears
+func (r *RobotsTxt) Groups() []Group {
+	groups := make([]Group, 0, len(r.agents))
+	for _, userAgent := range r.agents {
+		g := r.groups[userAgent]
+		rules := make([]Rule, len(g.rules))
+		for i, rule := range g.rules {
+			rules[i] = rule.Rule
+		}
+		groups = append(groups, Group{UserAgent: userAgent, Rules: rules, CrawlDelay: g.crawlDelay})
+	}
+	return groups
+}
+
+// Directives returns the lines of the file the parser does not know, as
+// Clean-param or Request-rate
+func (r *RobotsTxt) Directives() []Directive {
+	return r.directives
+}
+
+// Unreachable tells if the file could not be fetched, every URL
+// disallowed
+func (r *RobotsTxt) Unreachable() bool {
+	return r.disallowAll
+}
+
 // IsAllowed checks if the specified path is allowed by the robots.txt file
 func (r *RobotsTxt) IsAllowed(userAgent string, urlStr string) (result bool, err error) {
+	match, err := r.Evaluate(userAgent, urlStr)
+	return match.Allowed, err
+}
+
+// Evaluate checks if the specified path is allowed by the robots.txt file,
+// and tells the group and the rule deciding so
+func (r *RobotsTxt) Evaluate(userAgent string, urlStr string) (match Match, err error) {
 	u, err := parseAndNormalizeURL(urlStr)
 	if err != nil {
 		return
@@ -265,12 +441,34 @@ func (r *RobotsTxt) IsAllowed(userAgent string, urlStr string) (result bool, err
 		return
 	}
 
-	result = true
+	if r.disallowAll {
+		match.Unreachable = true
+		return
+	}
 
-	if group, ok := r.groups[normaliseUserAgent(userAgent)]; ok {
-		result = group.isAllowed(userAgent, u.Path)
-	} else if group, ok := r.groups["*"]; ok {
-		result = group.isAllowed(userAgent, u.Path)
+	match.Allowed = true
+
+	name := normaliseUserAgent(userAgent)
+	group, ok := r.groups[name]
+	if !ok {
+		name = "*"
+		group, ok = r.groups[name]
+	}
+	if !ok {
+		return
+	}
+	match.Group = name
+
+	// the query is part of what the rules match
+	path := u.Path
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	if rule, length := group.evaluate(path); rule != nil {
+		info := rule.Rule
+		match.Allowed = rule.isAllowed
+		match.Rule = &info
+		match.Length = length
 	}
 
 	return
@@ -83,15 +83,16 @@ func TestRobotsTxt_correctOrderPresidenceForAllowAndDisallow(t *testing.T) {
 		Allow: /test/
 	`
 
+	// the longest path wins, /fish/index.php over /fish*.php
 	allowed := []string{
 		"http://www.example.com/test/index.html",
 		"http://www.example.com/test/",
+		"http://www.example.com/fish/index.php",
 	}
 
 	disallowed := []string{
 		"http://www.example.com/fish.php",
 		"http://www.example.com/fishheads/catfish.php?parameters",
-		"http://www.example.com/fish/index.php",
 		"http://www.example.com/test",
 	}
 
@@ -641,3 +642,109 @@ func TestRobotsTxt_handleUrlEncodingsWithPatterns(t *testing.T) {
 
 	testRobots(t, contents, url, allowed, disallowed)
 }
+
+func TestRobotsTxt_evaluate(t *testing.T) {
+	url := "http://www.example.com/robots.txt"
+	contents := `User-agent: *
+Disallow: /
+
+User-agent: examplebot
+Disallow: /*.php
+Allow: /shop/
+# checkout
+Disallow: /shop/checkout
+`
+
+	robots, _ := Parse(contents, url)
+
+	tests := []struct {
+		userAgent, url string
+		expected       Match
+	}{
+		{"examplebot/1.0", "http://www.example.com/shop/cart.php", Match{true, "examplebot", &Rule{6, "allow", "/shop/"}, 6, false}},
+		{"examplebot/1.0", "http://www.example.com/index.php", Match{false, "examplebot", &Rule{5, "disallow", "/*.php"}, 6, false}},
+		{"examplebot/1.0", "http://www.example.com/shop/checkout/pay", Match{false, "examplebot", &Rule{8, "disallow", "/shop/checkout"}, 14, false}},
+		{"examplebot/1.0", "http://www.example.com/about", Match{true, "examplebot", nil, 0, false}},
+		{"otherbot", "http://www.example.com/about", Match{false, "*", &Rule{2, "disallow", "/"}, 1, false}},
+	}
+	for _, test := range tests {
+		match, err := robots.Evaluate(test.userAgent, test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(match, test.expected) {
+			t.Errorf("%s %s: expected %+v %+v, got %+v %+v", test.userAgent, test.url, test.expected, test.expected.Rule, match, match.Rule)
+		}
+	}
+
+	match, _ := robots.Evaluate("otherbot", "http://www.example.com/about")
+	if match.String() != "disallow: / (line 2, user-agent *)" {
+		t.Errorf("unexpected description %q", match)
+	}
+
+	unreachable, _ := ParseResponse(503, "", url)
+	if match, _ := unreachable.Evaluate("examplebot", "http://www.example.com/"); match.Allowed || !match.Unreachable {
+		t.Errorf("expected an unreachable robots.txt to disallow everything, got %+v", match)
+	}
+}
+
+func TestRobotsTxt_groups(t *testing.T) {
+	url := "http://www.example.com/robots.txt"
+	contents := `User-agent: GPTBot
+User-agent: CCBot/2.0
+Disallow: /
+
+User-agent: *
+Allow: /
+Crawl-delay: 2
+Clean-param: ref /articles/
+Request-rate: 1/10
+
+User-agent: gptbot
+Allow: /public/
+
+Sitemap: http://www.example.com/sitemap.xml
+Host: www.example.com
+`
+
+	robots, _ := Parse(contents, url)
+
+	expected := []Group{
+		{UserAgent: "gptbot", Rules: []Rule{{3, "disallow", "/"}, {12, "allow", "/public/"}}},
+		{UserAgent: "ccbot", Rules: []Rule{{3, "disallow", "/"}}},
+		{UserAgent: "*", Rules: []Rule{{6, "allow", "/"}}, CrawlDelay: 2 * time.Second},
+	}
+	if groups := robots.Groups(); !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected groups %+v, got %+v", expected, groups)
+	}
+
+	directives := []Directive{{8, "clean-param", "ref /articles/"}, {9, "request-rate", "1/10"}}
+	if !reflect.DeepEqual(robots.Directives(), directives) {
+		t.Errorf("expected directives %+v, got %+v", directives, robots.Directives())
+	}
+
+	if !reflect.DeepEqual(robots.Sitemaps(), []string{"http://www.example.com/sitemap.xml"}) || robots.Host() != "www.example.com" {
+		t.Errorf("unexpected sitemaps %v or host %q", robots.Sitemaps(), robots.Host())
+	}
+	if robots.Unreachable() {
+		t.Errorf("expected a parsed file to be reachable")
+	}
+}
+
+func TestURL(t *testing.T) {
+	tests := map[string]string{
+		"http://www.example.com":                  "http://www.example.com/robots.txt",
+		"https://example.com:8443/shop/?page=2#a": "https://example.com:8443/robots.txt",
+		"http://user@example.com/a/b/":            "http://example.com/robots.txt",
+	}
+	for link, expected := range tests {
+		if robotsURL, err := URL(link); err != nil || robotsURL != expected {
+			t.Errorf("%s: expected %s, got %s %v", link, expected, robotsURL, err)
+		}
+	}
+	for _, link := range []string{"ftp://example.com/", "example.com", "http://"} {
+		if _, err := URL(link); err == nil {
+			t.Errorf("%s: expected an error", link)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package sitemap streams sitemaps, XML sitemap indexes and urlsets as well
+// as plain text sitemaps, gzipped or not, entry by entry, so the largest
+// ones are read in constant memory. An Iterator follows the indexes down to
+// the URLs they list.
+package sitemap
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Defaults of an Iterator
+const (
+	// DefaultMaxDepth is the number of index levels followed, an index of
+	// indexes needing 2
+	DefaultMaxDepth = 3
+	// DefaultResponseHeaderTimeout is how long a client of NewClient waits
+	// for a sitemap to be answered
+	DefaultResponseHeaderTimeout = 40 * time.Second
+)
+
+// Entry is a <url> of a urlset, a <sitemap> of an index, or a line of a
+// plain text sitemap. Its fields are as written, spaces trimmed.
+type Entry struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+	// Index is set for the sitemaps listed by an index
+	Index bool `xml:"-"`
+	// Sitemap is the URL of the sitemap listing the entry, set by Iterator
+	Sitemap string `xml:"-"`
+}
+
+// Decoder reads the entries of a sitemap one at a time
+type Decoder struct {
+	xml  *xml.Decoder
+	text *bufio.Scanner
+}
+
+// NewDecoder returns a Decoder reading the sitemap from r, gunzipping it
+// when gzipped, and reading it line by line when it is not XML
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	reader := bufio.NewReaderSize(r, 64<<10)
+	if magic, _ := reader.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		reader = bufio.NewReaderSize(gz, 64<<10)
+	}
+
+	// the first character tells XML from text, past a BOM and spaces
+	for {
+		c, _, err := reader.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if c == '\ufeff' || c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+		reader.UnreadRune()
+		if c == '<' {
+			return &Decoder{xml: xml.NewDecoder(reader)}, nil
+		}
+		break
+	}
+	text := bufio.NewScanner(reader)
+	// the protocol allows 2048 characters, some write longer ones
+	text.Buffer(make([]byte, 0, 4096), 64<<10)
+	return &Decoder{text: text}, nil
+}
+
+// Next returns the next entry of the sitemap. It returns io.EOF once the
+// sitemap is exhausted.
+func (d *Decoder) Next() (*Entry, error) {
+	if d.text != nil {
+		for d.text.Scan() {
+			if line := strings.TrimSpace(d.text.Text()); line != "" {
+				return &Entry{Loc: line}, nil
+			}
+		}
+		if err := d.text.Err(); err != nil {
+			return nil, err
+		}
+		return nil, io.EOF
+	}
+
+	for {
+		tok, err := d.xml.Token()
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "url" && start.Name.Local != "sitemap" {
+			continue
+		}
+		entry := &Entry{}
+		if err := d.xml.DecodeElement(entry, &start); err != nil {
+			return nil, err
+		}
+		entry.Loc = strings.TrimSpace(entry.Loc)
+		if entry.Loc == "" {
+			continue
+		}
+		entry.LastMod = strings.TrimSpace(entry.LastMod)
+		entry.ChangeFreq = strings.TrimSpace(entry.ChangeFreq)
+		entry.Priority = strings.TrimSpace(entry.Priority)
+		entry.Index = start.Name.Local == "sitemap"
+		return entry, nil
+	}
+}
+
+// NewClient returns a client for an Iterator, going through the proxy at
+// proxyURL, eg. socks5://127.0.0.1:9050, or direct when empty. The largest
+// sitemaps are read for longer than a timeout of the whole request would
+// allow, so only the wait for the response is limited.
+func NewClient(proxyURL string) (*http.Client, error) {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		// the gzipped sitemaps are unzipped by the decoder
+		DisableCompression:    true,
+		ResponseHeaderTimeout: DefaultResponseHeaderTimeout,
+	}
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		dialer, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = nil
+		transport.Dial = dialer.Dial
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// pending is a sitemap left to read, at depth index levels from the first
+// ones
+type pending struct {
+	url   string
+	depth int
+}
+
+// Iterator walks sitemaps, following the indexes, and returns the URLs they
+// list one at a time. A sitemap failing ends with its error, the next call
+// to Next going on with the other ones.
+type Iterator struct {
+	// Client fetches the sitemaps, http.DefaultClient when nil
+	Client *http.Client
+	// MaxDepth is the number of index levels followed, DefaultMaxDepth when
+	// 0; the sitemaps listed deeper are skipped
+	MaxDepth int
+	// MaxURLs stops the iteration after as many URLs, 0 for no limit
+	MaxURLs int
+	// Rewrite maps the sitemaps listed by the indexes before they are
+	// fetched, eg. to a mirror, nil to fetch them as listed
+	Rewrite func(loc string) string
+
+	ctx     context.Context
+	queue   []pending
+	seen    map[string]bool
+	current *pending
+	body    io.Closer
+	decoder *Decoder
+	count   int
+}
+
+// New returns an Iterator over the URLs listed by the sitemaps at urls
+func New(ctx context.Context, urls ...string) *Iterator {
+	it := &Iterator{ctx: ctx, seen: make(map[string]bool)}
+	for _, u := range urls {
+		it.push(u, 0)
+	}
+	return it
+}
+
+func (it *Iterator) push(u string, depth int) {
+	if it.seen[u] {
+		return
+	}
+	it.seen[u] = true
+	it.queue = append(it.queue, pending{url: u, depth: depth})
+}
+
+// Next returns the next URL listed by the sitemaps, its Sitemap set. It
+// returns io.EOF once they are exhausted or MaxURLs is reached.
+func (it *Iterator) Next() (*Entry, error) {
+	maxDepth := it.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+	for {
+		if it.MaxURLs > 0 && it.count >= it.MaxURLs {
+			it.Close()
+			return nil, io.EOF
+		}
+		if it.decoder == nil {
+			if len(it.queue) == 0 {
+				return nil, io.EOF
+			}
+			next := it.queue[0]
+			it.queue = it.queue[1:]
+			if err := it.open(next); err != nil {
+				return nil, fmt.Errorf("sitemap: %s: %w", next.url, err)
+			}
+			continue
+		}
+
+		entry, err := it.decoder.Next()
+		if err != nil {
+			sitemap := it.current.url
+			it.Close()
+			if err == io.EOF {
+				continue
+			}
+			return nil, fmt.Errorf("sitemap: %s: %w", sitemap, err)
+		}
+		entry.Sitemap = it.current.url
+		if entry.Index {
+			// indexes are read through before the sitemaps they list
+			if it.current.depth < maxDepth {
+				loc := entry.Loc
+				if it.Rewrite != nil {
+					loc = it.Rewrite(loc)
+				}
+				it.push(loc, it.current.depth+1)
+			}
+			continue
+		}
+		it.count++
+		return entry, nil
+	}
+}
+
+// open starts reading a sitemap
+func (it *Iterator) open(p pending) error {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return err
+	}
+	client := it.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(it.ctx))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	decoder, err := NewDecoder(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return err
+	}
+	it.current, it.body, it.decoder = &p, resp.Body, decoder
+	return nil
+}
+
+// Close stops reading the current sitemap, the next call to Next going on
+// with the other ones
+func (it *Iterator) Close() error {
+	if it.body == nil {
+		return nil
+	}
+	err := it.body.Close()
+	it.current, it.body, it.decoder = nil, nil, nil
+	return err
+}
@@ -0,0 +1,166 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func gzipped(t *testing.T, content string) string {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	return buf.String()
+}
+
+func decodeAll(t *testing.T, content string) []Entry {
+	d, err := NewDecoder(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	for {
+		entry, err := d.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, *entry)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	urlset := "\ufeff" + `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">
+  <url>
+    <loc> http://www.example.com/ </loc>
+    <lastmod>2005-01-01</lastmod>
+    <changefreq>monthly</changefreq>
+    <priority>0.8</priority>
+    <image:image><image:loc>http://www.example.com/image.jpg</image:loc></image:image>
+  </url>
+  <url><loc>http://www.example.com/catalog?item=12&amp;desc=vacation_hawaii</loc></url>
+  <url><loc></loc></url>
+</urlset>`
+	expected := []Entry{
+		{Loc: "http://www.example.com/", LastMod: "2005-01-01", ChangeFreq: "monthly", Priority: "0.8"},
+		{Loc: "http://www.example.com/catalog?item=12&desc=vacation_hawaii"},
+	}
+	if entries := decodeAll(t, urlset); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+	// gzipped the same
+	if entries := decodeAll(t, gzipped(t, urlset)); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v from the gzipped urlset, got %+v", expected, entries)
+	}
+
+	index := `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://www.example.com/sitemap1.xml.gz</loc><lastmod>2004-10-01T18:23:17+00:00</lastmod></sitemap>
+</sitemapindex>`
+	expected = []Entry{{Loc: "http://www.example.com/sitemap1.xml.gz", LastMod: "2004-10-01T18:23:17+00:00", Index: true}}
+	if entries := decodeAll(t, index); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+
+	text := "http://www.example.com/\r\n\r\n  http://www.example.com/about  \n"
+	expected = []Entry{{Loc: "http://www.example.com/"}, {Loc: "http://www.example.com/about"}}
+	if entries := decodeAll(t, text); !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %+v, got %+v", expected, entries)
+	}
+	if entries := decodeAll(t, ""); len(entries) != 0 {
+		t.Errorf("expected no entry, got %+v", entries)
+	}
+}
+
+func TestIterator(t *testing.T) {
+	var server *httptest.Server
+	files := map[string]string{}
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		content = strings.Replace(content, "$server", server.URL, -1)
+		if strings.HasSuffix(r.URL.Path, ".gz") {
+			content = gzipped(t, content)
+		}
+		fmt.Fprint(w, content)
+	}))
+	defer server.Close()
+
+	files["/index.xml"] = `<sitemapindex>
+  <sitemap><loc>$server/pages.xml.gz</loc></sitemap>
+  <sitemap><loc>$server/nested.xml</loc></sitemap>
+  <sitemap><loc>$server/missing.xml</loc></sitemap>
+  <sitemap><loc>$server/index.xml</loc></sitemap>
+  <sitemap><loc>http://mirror.invalid/pages.txt</loc></sitemap>
+</sitemapindex>`
+	files["/pages.xml.gz"] = `<urlset><url><loc>$server/a</loc><priority>1.0</priority></url><url><loc>$server/b</loc></url></urlset>`
+	files["/nested.xml"] = `<sitemapindex><sitemap><loc>$server/deep.xml</loc></sitemap></sitemapindex>`
+	files["/deep.xml"] = `<urlset><url><loc>$server/deep</loc></url></urlset>`
+	files["/pages.txt"] = "$server/c\n"
+
+	walk := func(it *Iterator) (locs []string, errs int) {
+		for {
+			entry, err := it.Next()
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				errs++
+				continue
+			}
+			locs = append(locs, strings.TrimPrefix(entry.Loc, server.URL))
+		}
+	}
+
+	client, err := NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := New(context.Background(), server.URL+"/index.xml")
+	it.Client = client
+	it.Rewrite = func(loc string) string {
+		return strings.Replace(loc, "http://mirror.invalid", server.URL, 1)
+	}
+	locs, errs := walk(it)
+	if expected := []string{"/a", "/b", "/c", "/deep"}; !reflect.DeepEqual(locs, expected) || errs != 1 {
+		t.Errorf("expected %v and the missing sitemap failing, got %v and %d errors", expected, locs, errs)
+	}
+
+	it = New(context.Background(), server.URL+"/index.xml")
+	it.MaxDepth = 1
+	if locs, _ := walk(it); !reflect.DeepEqual(locs, []string{"/a", "/b"}) {
+		t.Errorf("expected the nested index not to be followed, got %v", locs)
+	}
+
+	it = New(context.Background(), server.URL+"/index.xml")
+	it.MaxURLs = 1
+	if locs, _ := walk(it); !reflect.DeepEqual(locs, []string{"/a"}) {
+		t.Errorf("expected to stop after MaxURLs, got %v", locs)
+	}
+
+	it = New(context.Background(), server.URL+"/pages.xml.gz")
+	entry, err := it.Next()
+	if err != nil || entry.Sitemap != server.URL+"/pages.xml.gz" || entry.Priority != "1.0" {
+		t.Errorf("expected the entry of its sitemap, got %+v %v", entry, err)
+	}
+	it.Close()
+
+	if _, err := NewClient("ftp://127.0.0.1:21"); err == nil {
+		t.Errorf("expected an error for a proxy of unknown scheme")
+	}
+}
@@ -0,0 +1,224 @@
+// Package split divides the exported dmoz listings into train, validation
+// and test sets, stratified by category and language. The listings of a
+// registered domain always land in the same set, so a site never leaks from
+// one set to another.
+package split
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/export"
+	"github.com/lucmichalski/dmoz-utils/pkg/tldparser"
+)
+
+// Sets, in the order of Result.Sets
+const (
+	Train = iota
+	Validation
+	Test
+)
+
+// Names of the sets, in the order of Result.Sets
+var Names = []string{"train", "validation", "test"}
+
+// Options of a split
+type Options struct {
+	// Level is the number of categories below Top forming a class, 1 for
+	// the top-level ones, 0 for the full path
+	Level int
+	// Language also stratifies the classes by language
+	Language bool
+	// Validation and Test are the share of every class going to these sets,
+	// the rest goes to Train
+	Validation float64
+	Test       float64
+	// Seed shuffles the domains, the same seed and listings always give the
+	// same sets
+	Seed int64
+	// MaxPerClass caps the listings kept per class, 0 for no limit
+	MaxPerClass int
+	// MinPerClass drops the classes with fewer listings, 0 keeps them all
+	MinPerClass int
+}
+
+// Result holds the sets, along with what was left out
+type Result struct {
+	Sets [3][]export.Row
+	// Classes counts the classes kept
+	Classes int
+	// Dropped counts the classes with less than MinPerClass listings
+	Dropped int
+	// Capped counts the listings over MaxPerClass
+	Capped int
+}
+
+// Class returns the class of a category path, made of its first level
+// categories below Top
+func Class(pathFull string, level int) string {
+	categories := strings.Split(strings.Trim(pathFull, "/"), "/")
+	if len(categories) > 1 && categories[0] == "Top" {
+		categories = categories[1:]
+	}
+	if level > 0 && len(categories) > level {
+		categories = categories[:level]
+	}
+	return strings.Join(categories, "/")
+}
+
+// Domain returns the registered domain of a link, eg. example.co.uk for
+// http://www.example.co.uk/about, or its host when it has no public suffix
+func Domain(link string) string {
+	host := link
+	if u, err := url.Parse(link); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	return tldparser.RegisteredDomain(host)
+}
+
+// listing is a row of a class
+type listing struct {
+	row    int
+	domain string
+	order  uint64
+}
+
+// Split assigns the rows to the sets. Classes are processed from the
+// rarest to the largest and, within a class, domains in the order of the
+// seeded hash of their name. A domain already assigned by another class
+// keeps its set, others go to the set of the class still missing the most
+// listings. When a class has at least a domain per set, every set gets one.
+func Split(rows []export.Row, o Options) (*Result, error) {
+	if o.Validation < 0 || o.Test < 0 || o.Validation+o.Test >= 1 {
+		return nil, errors.New("split: validation and test shares must be positive and leave some listings to train")
+	}
+	ratios := [3]float64{1 - o.Validation - o.Test, o.Validation, o.Test}
+
+	classes := make(map[string][]listing)
+	for i := range rows {
+		key := Class(rows[i].PathFull, o.Level)
+		if o.Language {
+			key += "\t" + rows[i].Language
+		}
+		domain := Domain(rows[i].Link)
+		classes[key] = append(classes[key], listing{row: i, domain: domain, order: hash(o.Seed, domain)})
+	}
+
+	keys := make([]string, 0, len(classes))
+	for key := range classes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(classes[keys[i]]) != len(classes[keys[j]]) {
+			return len(classes[keys[i]]) < len(classes[keys[j]])
+		}
+		return keys[i] < keys[j]
+	})
+
+	result := &Result{}
+	assigned := make(map[string]int)
+	var sets [3][]int
+	for _, key := range keys {
+		listings := classes[key]
+		if len(listings) < o.MinPerClass {
+			result.Dropped++
+			continue
+		}
+		result.Classes++
+		sort.Slice(listings, func(i, j int) bool {
+			if listings[i].order != listings[j].order {
+				return listings[i].order < listings[j].order
+			}
+			if listings[i].domain != listings[j].domain {
+				return listings[i].domain < listings[j].domain
+			}
+			return listings[i].row < listings[j].row
+		})
+		if o.MaxPerClass > 0 && len(listings) > o.MaxPerClass {
+			result.Capped += len(listings) - o.MaxPerClass
+			listings = listings[:o.MaxPerClass]
+		}
+
+		var domains [][]listing
+		for i, l := range listings {
+			if i == 0 || l.domain != listings[i-1].domain {
+				domains = append(domains, nil)
+			}
+			domains[len(domains)-1] = append(domains[len(domains)-1], l)
+		}
+		sharing := 0
+		for _, ratio := range ratios {
+			if ratio > 0 {
+				sharing++
+			}
+		}
+
+		var counts [3]int
+		for _, domain := range domains {
+			set, ok := assigned[domain[0].domain]
+			if !ok {
+				set = pick(ratios, counts, len(listings), len(domains) >= sharing)
+				assigned[domain[0].domain] = set
+			}
+			for _, l := range domain {
+				sets[set] = append(sets[set], l.row)
+			}
+			counts[set] += len(domain)
+		}
+	}
+
+	for i := range sets {
+		sort.Ints(sets[i])
+		result.Sets[i] = make([]export.Row, len(sets[i]))
+		for j, row := range sets[i] {
+			result.Sets[i][j] = rows[row]
+		}
+	}
+	return result, nil
+}
+
+// pick returns the set the next domain of a class goes to: the first empty
+// one when fill is set, otherwise the one missing the most listings
+func pick(ratios [3]float64, counts [3]int, total int, fill bool) int {
+	if fill {
+		for set, ratio := range ratios {
+			if ratio > 0 && counts[set] == 0 {
+				return set
+			}
+		}
+	}
+	best, missing := Train, 0.0
+	for set, ratio := range ratios {
+		if m := ratio*float64(total) - float64(counts[set]); ratio > 0 && m > missing {
+			best, missing = set, m
+		}
+	}
+	return best
+}
+
+func hash(seed int64, s string) uint64 {
+	h := fnv.New64a()
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], uint64(seed))
+	h.Write(b[:])
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// Rows collects the exported rows in memory, as an export.Writer
+type Rows []export.Row
+
+// Write appends a copy of r
+func (rows *Rows) Write(r *export.Row) error {
+	*rows = append(*rows, *r)
+	return nil
+}
+
+// Close does nothing
+func (rows *Rows) Close() error {
+	return nil
+}
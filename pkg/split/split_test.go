@@ -0,0 +1,165 @@
+package split
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/export"
+)
+
+func TestClass(t *testing.T) {
+	tests := []struct {
+		path     string
+		level    int
+		expected string
+	}{
+		{"Top/Arts/Visual_Arts/Painting", 1, "Arts"},
+		{"Top/Arts/Visual_Arts/Painting", 2, "Arts/Visual_Arts"},
+		{"Top/Arts/Visual_Arts/Painting", 0, "Arts/Visual_Arts/Painting"},
+		{"Top/Arts", 3, "Arts"},
+		{"Regional/Europe", 1, "Regional"},
+	}
+	for _, test := range tests {
+		if got := Class(test.path, test.level); got != test.expected {
+			t.Errorf("%s level %d: expected %q, got %q", test.path, test.level, test.expected, got)
+		}
+	}
+}
+
+func TestDomain(t *testing.T) {
+	tests := map[string]string{
+		"http://www.example.co.uk/about": "example.co.uk",
+		"https://Shop.Example.com:8080/": "example.com",
+		"http://localhost/":              "localhost",
+		"example.org":                    "example.org",
+	}
+	for link, expected := range tests {
+		if got := Domain(link); got != expected {
+			t.Errorf("%s: expected %q, got %q", link, expected, got)
+		}
+	}
+}
+
+// listings returns n listings of a category, one domain each unless shared
+// is set
+func listings(path, language string, n int, shared bool) []export.Row {
+	var rows []export.Row
+	for i := 0; i < n; i++ {
+		link := fmt.Sprintf("http://site%d.%s.example.com/", i, language)
+		if !shared {
+			link = fmt.Sprintf("http://%s-%s-%d.com/", language, strings.Replace(Class(path, 0), "/", "-", -1), i)
+		}
+		rows = append(rows, export.Row{Link: link, PathFull: path, Language: language})
+	}
+	return rows
+}
+
+func setOf(result *Result) map[string]int {
+	sets := make(map[string]int)
+	for set, rows := range result.Sets {
+		for _, row := range rows {
+			sets[row.Link+" "+row.PathFull] = set
+		}
+	}
+	return sets
+}
+
+func TestSplit_stratified(t *testing.T) {
+	var rows []export.Row
+	rows = append(rows, listings("Top/Arts/Painting", "English", 100, false)...)
+	rows = append(rows, listings("Top/Regional/Europe", "English", 3, false)...)
+
+	result, err := Split(rows, Options{Level: 1, Validation: 0.1, Test: 0.1, Seed: 42})
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(set int, class string) int {
+		n := 0
+		for _, row := range result.Sets[set] {
+			if Class(row.PathFull, 1) == class {
+				n++
+			}
+		}
+		return n
+	}
+	for set := range Names {
+		if count(set, "Regional") != 1 {
+			t.Errorf("expected one Regional listing in %s, got %d", Names[set], count(set, "Regional"))
+		}
+	}
+	if count(Train, "Arts") != 80 || count(Validation, "Arts") != 10 || count(Test, "Arts") != 10 {
+		t.Errorf("expected 80/10/10 Arts listings, got %d/%d/%d", count(Train, "Arts"), count(Validation, "Arts"), count(Test, "Arts"))
+	}
+}
+
+func TestSplit_seeded(t *testing.T) {
+	rows := listings("Top/Arts", "English", 50, false)
+	split := func(seed int64) map[string]int {
+		result, err := Split(rows, Options{Level: 1, Validation: 0.2, Test: 0.2, Seed: seed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return setOf(result)
+	}
+	if !reflect.DeepEqual(split(1), split(1)) {
+		t.Error("expected the same seed to give the same sets")
+	}
+	if reflect.DeepEqual(split(1), split(2)) {
+		t.Error("expected another seed to give other sets")
+	}
+}
+
+func TestSplit_domains(t *testing.T) {
+	// the sites of example.com are listed in both languages
+	var rows []export.Row
+	rows = append(rows, listings("Top/Arts", "English", 10, true)...)
+	rows = append(rows, listings("Top/Arts", "French", 10, true)...)
+	rows = append(rows, listings("Top/Arts", "German", 30, false)...)
+
+	result, err := Split(rows, Options{Level: 1, Language: true, Validation: 0.1, Test: 0.1, Seed: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	domains := make(map[string]int)
+	for set, rows := range result.Sets {
+		for _, row := range rows {
+			domain := Domain(row.Link)
+			if previous, ok := domains[domain]; ok && previous != set {
+				t.Fatalf("%s is in %s and %s", domain, Names[previous], Names[set])
+			}
+			domains[domain] = set
+		}
+	}
+}
+
+func TestSplit_limits(t *testing.T) {
+	var rows []export.Row
+	rows = append(rows, listings("Top/Arts", "English", 20, false)...)
+	rows = append(rows, listings("Top/Games", "English", 2, false)...)
+
+	result, err := Split(rows, Options{Level: 1, Validation: 0.1, Test: 0.1, MinPerClass: 5, MaxPerClass: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Classes != 1 || result.Dropped != 1 || result.Capped != 10 {
+		t.Errorf("expected 1 class kept, 1 dropped and 10 capped listings, got %+v", result)
+	}
+	total := 0
+	for _, rows := range result.Sets {
+		for _, row := range rows {
+			if Class(row.PathFull, 1) != "Arts" {
+				t.Errorf("expected %s to be dropped", row.Link)
+			}
+		}
+		total += len(rows)
+	}
+	if total != 10 {
+		t.Errorf("expected 10 listings, got %d", total)
+	}
+
+	if _, err := Split(rows, Options{Validation: 0.5, Test: 0.5}); err == nil {
+		t.Error("expected an error when nothing is left to train")
+	}
+}
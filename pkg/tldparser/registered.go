@@ -0,0 +1,14 @@
+package tldparser
+
+import "strings"
+
+// RegisteredDomain returns the domain registered under the public suffix of
+// host, eg. example.co.uk for www.example.co.uk, or host itself when it has
+// no known suffix, as an ip or localhost
+func RegisteredDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if fld, _, _ := ParseDomainFldSld(ParseDomain(host)); fld != "" {
+		return fld
+	}
+	return host
+}
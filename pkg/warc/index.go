@@ -0,0 +1,85 @@
+package warc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
+
+// IndexFile is the name of the index in the directory of the WARC files
+const IndexFile = "index.db"
+
+// ErrNotFound is the error of a URL missing from the archive
+var ErrNotFound = errors.New("warc: not in the archive")
+
+// Entry locates the response record of a URL
+type Entry struct {
+	ID uint `gorm:"primary_key"`
+	// URL is the URL of the response, Link the one the fetch started from
+	URL  string `gorm:"index:idx_warc_entries_url"`
+	Link string `gorm:"index:idx_warc_entries_link"`
+	// File is the name of the WARC file, Offset and Length the position
+	// of the gzip member of the record in it
+	File       string `gorm:"size:255"`
+	Offset     int64
+	Length     int64
+	StatusCode int
+	Date       time.Time
+}
+
+// TableName of the index entries
+func (Entry) TableName() string {
+	return "warc_entries"
+}
+
+// Index maps the URLs to their records, in a sqlite file shared by the
+// writers and the readers of a directory
+type Index struct {
+	db *gorm.DB
+}
+
+// OpenIndex opens the index of dir, creating it when missing
+func OpenIndex(dir string) (*Index, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	// let the concurrent crawlers wait for each other's writes
+	db, err := gorm.Open("sqlite3", filepath.Join(dir, IndexFile)+"?_busy_timeout=10000&_journal_mode=WAL")
+	if err != nil {
+		return nil, err
+	}
+	db.DB().SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&Entry{}).Error; err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Index{db: db}, nil
+}
+
+// Close closes the index
+func (i *Index) Close() error {
+	return i.db.Close()
+}
+
+// Add indexes a record
+func (i *Index) Add(entry *Entry) error {
+	return i.db.Create(entry).Error
+}
+
+// Lookup returns the newest record of link, requested or answering after
+// redirects, or ErrNotFound
+func (i *Index) Lookup(link string) (*Entry, error) {
+	entry := &Entry{}
+	err := i.db.Where("link = ? OR url = ?", link, link).Order("date DESC, id DESC").First(entry).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
@@ -0,0 +1,119 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Record is a WARC record
+type Record struct {
+	Header textproto.MIMEHeader
+	Block  []byte
+}
+
+// Type returns the WARC-Type of the record
+func (r *Record) Type() string {
+	return r.Header.Get("WARC-Type")
+}
+
+// Response parses the HTTP response of a response record, its body read
+// from the block. Its Request holds the target URL.
+func (r *Record) Response() (*http.Response, error) {
+	if r.Type() != "response" {
+		return nil, fmt.Errorf("warc: %s record is not a response", r.Type())
+	}
+	req, err := http.NewRequest(http.MethodGet, r.Header.Get("WARC-Target-URI"), nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(r.Block)), req)
+}
+
+// ReadRecord reads the record at offset in a WARC file
+func ReadRecord(path string, offset int64) (*Record, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, err
+	}
+	// the record is the first gzip member from offset
+	gz.Multistream(false)
+	defer gz.Close()
+
+	reader := textproto.NewReader(bufio.NewReader(gz))
+	version, err := reader.ReadLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(version, "WARC/") {
+		return nil, fmt.Errorf("warc: no record at %s:%d", path, offset)
+	}
+	header, err := reader.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("warc: bad Content-Length at %s:%d", path, offset)
+	}
+	block, err := ioutil.ReadAll(io.LimitReader(reader.R, length))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(block)) < length {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return &Record{Header: header, Block: block}, nil
+}
+
+// Archive reads back the pages of a directory of WARC files
+type Archive struct {
+	Dir   string
+	index *Index
+}
+
+// Open opens the archive of dir
+func Open(dir string) (*Archive, error) {
+	index, err := OpenIndex(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Archive{Dir: dir, index: index}, nil
+}
+
+// Close closes the index of the archive
+func (a *Archive) Close() error {
+	return a.index.Close()
+}
+
+// Get returns the newest response archived for link, requested or
+// answering after redirects, or ErrNotFound. The URL of its Request is the
+// one that answered.
+func (a *Archive) Get(link string) (*http.Response, error) {
+	entry, err := a.index.Lookup(link)
+	if err != nil {
+		return nil, err
+	}
+	record, err := ReadRecord(filepath.Join(a.Dir, entry.File), entry.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return record.Response()
+}
@@ -0,0 +1,299 @@
+// Package warc archives the fetched pages in rotating gzip WARC files, with
+// an index from URL to record, so that the extractors can replay them
+// offline instead of fetching the sites again.
+//
+// Every fetch is written as a request record and a response record, each
+// compressed in its own gzip member as the WARC specification recommends,
+// so that a record is read back by seeking to its offset in the file.
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+)
+
+// Defaults of a Writer
+const (
+	DefaultPrefix  = "dmoz"
+	DefaultMaxSize = 1 << 30
+)
+
+// Exchange is a request and its response
+type Exchange struct {
+	// Link is the URL the fetch started from, URL the one answering after
+	// the redirects
+	Link          string
+	Method        string
+	URL           string
+	RequestHeader http.Header
+	StatusCode    int
+	Header        http.Header
+	// Body is the payload as received, before any decoding
+	Body []byte
+	// Truncated is set when Body was cut to the maximum size of a body
+	Truncated bool
+	Date      time.Time
+}
+
+// Writer writes exchanges to WARC files of at most MaxSize bytes, started
+// with a warcinfo record, and indexes their responses. It is safe for
+// concurrent use.
+type Writer struct {
+	// Dir holds the WARC files and the index
+	Dir string
+	// Prefix starts the names of the WARC files, DefaultPrefix when empty
+	Prefix string
+	// MaxSize is the size after which a new file is started,
+	// DefaultMaxSize when 0
+	MaxSize int64
+
+	mutex sync.Mutex
+	index *Index
+	file  *os.File
+	name  string
+	size  int64
+	seq   int
+}
+
+// New returns the Writer configured by cfg, nil when cfg.Dir is empty
+func New(cfg config.Archive) *Writer {
+	if cfg.Dir == "" {
+		return nil
+	}
+	return &Writer{Dir: cfg.Dir, Prefix: cfg.Prefix, MaxSize: cfg.MaxSize}
+}
+
+// Write archives an exchange
+func (w *Writer) Write(e *Exchange) error {
+	if e.Date.IsZero() {
+		e.Date = time.Now()
+	}
+	if e.Method == "" {
+		e.Method = http.MethodGet
+	}
+	if e.Link == "" {
+		e.Link = e.URL
+	}
+	request, err := requestBlock(e)
+	if err != nil {
+		return err
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err := w.rotate(); err != nil {
+		return err
+	}
+	responseID := recordID()
+	requestFields := fields{
+		{"WARC-Type", "request"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Date", date(e.Date)},
+		{"WARC-Target-URI", e.URL},
+		{"WARC-Concurrent-To", responseID},
+		{"Content-Type", "application/http; msgtype=request"},
+	}
+	if _, _, err := w.writeRecord(requestFields, request); err != nil {
+		return err
+	}
+	digest := sha1.Sum(e.Body)
+	responseFields := fields{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", responseID},
+		{"WARC-Date", date(e.Date)},
+		{"WARC-Target-URI", e.URL},
+		{"WARC-Payload-Digest", "sha1:" + base32.StdEncoding.EncodeToString(digest[:])},
+		{"Content-Type", "application/http; msgtype=response"},
+	}
+	if e.Truncated {
+		responseFields = append(responseFields, field{"WARC-Truncated", "length"})
+	}
+	offset, length, err := w.writeRecord(responseFields, responseBlock(e))
+	if err != nil {
+		return err
+	}
+	return w.index.Add(&Entry{
+		URL:        e.URL,
+		Link:       e.Link,
+		File:       w.name,
+		Offset:     offset,
+		Length:     length,
+		StatusCode: e.StatusCode,
+		Date:       e.Date.UTC(),
+	})
+}
+
+// Close closes the current file and the index
+func (w *Writer) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	if w.index != nil {
+		if closeErr := w.index.Close(); err == nil {
+			err = closeErr
+		}
+		w.index = nil
+	}
+	return err
+}
+
+// rotate opens the index, and a new file when there is none or the current
+// one is full
+func (w *Writer) rotate() error {
+	if w.index == nil {
+		index, err := OpenIndex(w.Dir)
+		if err != nil {
+			return err
+		}
+		w.index = index
+	}
+	maxSize := w.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if w.file != nil && w.size < maxSize {
+		return nil
+	}
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+		w.file = nil
+	}
+
+	prefix := w.Prefix
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	w.seq++
+	w.name = fmt.Sprintf("%s-%s-%05d.warc.gz", prefix, time.Now().UTC().Format("20060102150405"), w.seq)
+	file, err := os.OpenFile(filepath.Join(w.Dir, w.name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.size = 0
+
+	info := "software: dmoz-utils\r\nformat: WARC File Format 1.0\r\n"
+	_, _, err = w.writeRecord(fields{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", recordID()},
+		{"WARC-Date", date(time.Now())},
+		{"WARC-Filename", w.name},
+		{"Content-Type", "application/warc-fields"},
+	}, []byte(info))
+	return err
+}
+
+// writeRecord appends a record as a gzip member, and returns its offset and
+// its compressed length
+func (w *Writer) writeRecord(header fields, block []byte) (int64, int64, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	fmt.Fprint(gz, "WARC/1.0\r\n")
+	for _, f := range header {
+		fmt.Fprintf(gz, "%s: %s\r\n", f.name, f.value)
+	}
+	fmt.Fprintf(gz, "Content-Length: %d\r\n\r\n", len(block))
+	gz.Write(block)
+	fmt.Fprint(gz, "\r\n\r\n")
+	if err := gz.Close(); err != nil {
+		return 0, 0, err
+	}
+
+	offset := w.size
+	n, err := w.file.Write(buf.Bytes())
+	w.size += int64(n)
+	if err != nil {
+		return 0, 0, err
+	}
+	return offset, int64(n), nil
+}
+
+// field is a named field of a record header, kept in order
+type field struct {
+	name, value string
+}
+
+type fields []field
+
+// requestBlock returns the HTTP request of an exchange
+func requestBlock(e *Exchange) ([]byte, error) {
+	u, err := url.Parse(e.URL)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %s HTTP/1.1\r\nHost: %s\r\n", e.Method, u.RequestURI(), u.Host)
+	writeHeader(&buf, e.RequestHeader)
+	buf.WriteString("\r\n")
+	return buf.Bytes(), nil
+}
+
+// responseBlock returns the HTTP response of an exchange. Its body having
+// been read whole, and decompressed by net/http, the headers are fixed so
+// that the response is read back as it was received.
+func responseBlock(e *Exchange) []byte {
+	header := http.Header{}
+	for key, values := range e.Header {
+		header[key] = values
+	}
+	header.Del("Transfer-Encoding")
+	header.Set("Content-Length", strconv.Itoa(len(e.Body)))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "HTTP/1.1 %d %s\r\n", e.StatusCode, http.StatusText(e.StatusCode))
+	writeHeader(&buf, header)
+	buf.WriteString("\r\n")
+	buf.Write(e.Body)
+	return buf.Bytes()
+}
+
+// writeHeader writes header sorted by key, as http.Header.Write does
+// without the Host of a request
+func writeHeader(w io.Writer, header http.Header) {
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		if key != "Host" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, value := range header[key] {
+			fmt.Fprintf(w, "%s: %s\r\n", key, value)
+		}
+	}
+}
+
+// recordID returns a random urn:uuid
+func recordID() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+	uuid[6] = uuid[6]&0x0f | 0x40
+	uuid[8] = uuid[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+func date(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
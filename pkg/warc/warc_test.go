@@ -0,0 +1,102 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "warc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := &Writer{Dir: dir, Prefix: "test", MaxSize: 1}
+	pages := []*Exchange{
+		{Link: "http://example.com/", URL: "https://www.example.com/", StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/html"}}, Body: []byte("<title>v1</title>")},
+		{Link: "http://example.com/", URL: "https://www.example.com/", StatusCode: http.StatusOK, Header: http.Header{"Content-Type": {"text/html"}, "Transfer-Encoding": {"chunked"}}, Body: []byte("<title>v2</title>"), Truncated: true},
+		{URL: "http://example.org/missing", StatusCode: http.StatusNotFound, RequestHeader: http.Header{"User-Agent": {"test"}}},
+	}
+	for _, page := range pages {
+		if err := w.Write(page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "test-*.warc.gz"))
+	if len(files) != 3 {
+		t.Fatalf("expected a file per exchange past MaxSize, got %v", files)
+	}
+
+	// the files are a valid multi-member gzip stream of WARC records
+	file, err := os.Open(files[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"WARC-Type: warcinfo", "WARC-Type: request", "GET /missing HTTP/1.1\r\nHost: example.org\r\nUser-Agent: test\r\n", "WARC-Type: response", "HTTP/1.1 404 Not Found"} {
+		if !bytes.Contains(content, []byte(expected)) {
+			t.Errorf("expected %q in %s", expected, content)
+		}
+	}
+
+	a, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	for _, link := range []string{"http://example.com/", "https://www.example.com/"} {
+		resp, err := a.Get(link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		if string(body) != "<title>v2</title>" || resp.Request.URL.String() != "https://www.example.com/" || resp.Header.Get("Content-Type") != "text/html" {
+			t.Errorf("%s: expected the newest page, got %s from %s", link, body, resp.Request.URL)
+		}
+	}
+	if resp, err := a.Get("http://example.org/missing"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the 404 to be archived, got %v", err)
+	}
+	if _, err := a.Get("http://example.net/"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	entry, err := a.index.Lookup("http://example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	record, err := ReadRecord(filepath.Join(dir, entry.File), entry.Offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Header.Get("WARC-Truncated") != "length" || !strings.HasPrefix(record.Header.Get("WARC-Payload-Digest"), "sha1:") {
+		t.Errorf("unexpected record header %v", record.Header)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(record.Block)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Transfer-Encoding") != "" || resp.ContentLength != int64(len("<title>v2</title>")) {
+		t.Errorf("expected the body length to replace the transfer encoding, got %v", resp.Header)
+	}
+}
@@ -0,0 +1,261 @@
+// Package work hands out the rows left to process by a scanner, in batches
+// claimed atomically in the work_claims table so that several processes can
+// scan the same table without doing the same rows twice.
+//
+// Rows are paged by primary key, which only reads the index, then shuffled
+// within a page by a seeded hash of their id so that consecutive listings of
+// a category, and thus often of a host, are spread over time.
+//
+// A batch is released once processed, its rows left unprocessed being free
+// for the other processes right away. The claims of a process that stopped
+// short of it last until they expire.
+package work
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/bulk"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+)
+
+// Defaults of a Selector
+const (
+	DefaultBatchSize = 100
+	DefaultPageSize  = 10000
+	DefaultTTL       = time.Hour
+)
+
+// Selector claims the rows of Table matching Where for a task
+type Selector struct {
+	// Task names the scan, the claims of different tasks are independent
+	Task string
+	// Table and Where select the rows left to process, eg. websites and
+	// text_extract IS NULL, Args being the values of the placeholders
+	Table string
+	Where string
+	Args  []interface{}
+	// BatchSize is the number of rows claimed at once
+	BatchSize int
+	// PageSize is the number of ids read and shuffled at once
+	PageSize int
+	// TTL is how long a claim lasts, after which the rows not processed yet
+	// can be claimed again
+	TTL time.Duration
+	// Seed shuffles the rows of a page
+	Seed int64
+	// Limit is the maximum number of rows handed out, 0 for all
+	Limit int
+	// Owner identifies the process in the claims, the host name and pid
+	// by default
+	Owner string
+
+	cursor  uint
+	page    []uint
+	last    []uint
+	handed  int
+	started bool
+	done    bool
+}
+
+// New returns a Selector of the rows of table matching where, with the
+// default settings
+func New(task, table, where string, args ...interface{}) *Selector {
+	return &Selector{Task: task, Table: table, Where: where, Args: args}
+}
+
+func (s *Selector) init() {
+	if s.BatchSize <= 0 {
+		s.BatchSize = DefaultBatchSize
+	}
+	if s.PageSize < s.BatchSize {
+		s.PageSize = DefaultPageSize
+		if s.PageSize < s.BatchSize {
+			s.PageSize = s.BatchSize
+		}
+	}
+	if s.TTL <= 0 {
+		s.TTL = DefaultTTL
+	}
+	if s.Owner == "" {
+		host, _ := os.Hostname()
+		s.Owner = fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	}
+	s.started = true
+}
+
+// Next claims and returns the ids of the next batch. It returns no ids once
+// the table is exhausted or Limit is reached.
+func (s *Selector) Next(db *gorm.DB) ([]uint, error) {
+	if !s.started {
+		s.init()
+	}
+	for !s.done {
+		if s.Limit > 0 && s.handed >= s.Limit {
+			s.done = true
+			break
+		}
+		if len(s.page) == 0 {
+			if err := s.read(db); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		size := s.BatchSize
+		if s.Limit > 0 && s.Limit-s.handed < size {
+			size = s.Limit - s.handed
+		}
+		if size > len(s.page) {
+			size = len(s.page)
+		}
+		batch := s.page[:size]
+		s.page = s.page[size:]
+
+		claimed, err := s.claim(db, batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(claimed) > 0 {
+			s.handed += len(claimed)
+			s.last = claimed
+			return claimed, nil
+		}
+	}
+	return nil, nil
+}
+
+// Release removes the claims of ids held by the Selector, once processed
+func (s *Selector) Release(db *gorm.DB, ids []uint) error {
+	if !s.started || len(ids) == 0 {
+		return nil
+	}
+	items := make([]string, len(ids))
+	for i, id := range ids {
+		items[i] = Item(s.Task, id)
+	}
+	table := db.NewScope(&models.WorkClaim{}).TableName()
+	return db.Exec(fmt.Sprintf("DELETE FROM %s WHERE item IN (?) AND owner = ?", table), items, s.Owner).Error
+}
+
+// Done releases the last batch returned by Next
+func (s *Selector) Done(db *gorm.DB) error {
+	last := s.last
+	s.last = nil
+	return s.Release(db, last)
+}
+
+// read fetches the next page of ids not claimed by a live claim and
+// shuffles it
+func (s *Selector) read(db *gorm.DB) error {
+	query := fmt.Sprintf("SELECT t.id FROM %s t WHERE t.id > ? AND NOT EXISTS (SELECT 1 FROM %s c WHERE c.task = ? AND c.item_id = t.id AND c.expires_at > ?)",
+		s.Table, db.NewScope(&models.WorkClaim{}).TableName())
+	args := []interface{}{s.cursor, s.Task, now()}
+	if s.Where != "" {
+		query += " AND (" + s.Where + ")"
+		args = append(args, s.Args...)
+	}
+	query += fmt.Sprintf(" ORDER BY t.id LIMIT %d", s.PageSize)
+
+	rows, err := db.Raw(query, args...).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var ids []uint
+	for rows.Next() {
+		var id uint
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if len(ids) == 0 {
+		s.done = true
+		return nil
+	}
+	s.cursor = ids[len(ids)-1]
+	order := make(map[uint]uint64, len(ids))
+	for _, id := range ids {
+		order[id] = hash(s.Seed, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if order[ids[i]] != order[ids[j]] {
+			return order[ids[i]] < order[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	s.page = ids
+	return nil
+}
+
+// claim inserts the claims of ids, leaving alone the ones another process
+// holds, and returns the ids claimed
+func (s *Selector) claim(db *gorm.DB, ids []uint) ([]uint, error) {
+	table := db.NewScope(&models.WorkClaim{}).TableName()
+	items := make([]string, len(ids))
+	for i, id := range ids {
+		items[i] = Item(s.Task, id)
+	}
+
+	created := now()
+	if err := db.Exec(fmt.Sprintf("DELETE FROM %s WHERE item IN (?) AND expires_at <= ?", table), items, created).Error; err != nil {
+		return nil, err
+	}
+	expires := created.Add(s.TTL)
+	rows := make([][]interface{}, len(ids))
+	for i, id := range ids {
+		rows[i] = []interface{}{items[i], s.Task, id, s.Owner, expires, created}
+	}
+	err := bulk.Upsert(db, table, "item", []string{"item", "task", "item_id", "owner", "expires_at", "created_at"}, nil, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var owned []uint
+	err = db.Table(table).Where("item IN (?) AND owner = ?", items, s.Owner).Pluck("item_id", &owned).Error
+	if err != nil {
+		return nil, err
+	}
+	mine := make(map[uint]bool, len(owned))
+	for _, id := range owned {
+		mine[id] = true
+	}
+	var claimed []uint
+	for _, id := range ids {
+		if mine[id] {
+			claimed = append(claimed, id)
+		}
+	}
+	return claimed, nil
+}
+
+// Item returns the unique key of the claim of a task on a row
+func Item(task string, id uint) string {
+	return fmt.Sprintf("%s/%d", task, id)
+}
+
+// now is truncated to the second and in UTC, so that sqlite compares the
+// text it stores times as in chronological order
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Second)
+}
+
+func hash(seed int64, id uint) uint64 {
+	h := fnv.New64a()
+	var b [16]byte
+	binary.LittleEndian.PutUint64(b[:8], uint64(seed))
+	binary.LittleEndian.PutUint64(b[8:], uint64(id))
+	h.Write(b[:])
+	return h.Sum64()
+}
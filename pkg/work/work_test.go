@@ -0,0 +1,197 @@
+package work
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+)
+
+func openTestDB(t *testing.T, websites int) *gorm.DB {
+	db, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every connection would open its own in-memory database
+	db.DB().SetMaxOpenConns(1)
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < websites; i++ {
+		website := &models.Website{Link: fmt.Sprintf("http://%d.example.com/", i), Analyzed: i % 2}
+		if err := db.Create(website).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func drain(t *testing.T, db *gorm.DB, s *Selector) []uint {
+	var all []uint
+	for {
+		ids, err := s.Next(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ids) == 0 {
+			return all
+		}
+		all = append(all, ids...)
+	}
+}
+
+func TestSelector_parallel(t *testing.T) {
+	db := openTestDB(t, 100)
+	defer db.Close()
+
+	// two processes taking turns
+	a := &Selector{Task: "scan", Table: "websites", Where: "analyzed = ?", Args: []interface{}{0}, BatchSize: 7, PageSize: 20}
+	b := &Selector{Task: "scan", Table: "websites", Where: "analyzed = ?", Args: []interface{}{0}, BatchSize: 7, PageSize: 20}
+	seen := make(map[uint]string)
+	for done := 0; done < 2; {
+		done = 0
+		for name, s := range map[string]*Selector{"a": a, "b": b} {
+			ids, err := s.Next(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) == 0 {
+				done++
+			}
+			for _, id := range ids {
+				if other, ok := seen[id]; ok {
+					t.Fatalf("%d handed to %s and %s", id, other, name)
+				}
+				seen[id] = name
+			}
+		}
+	}
+	if len(seen) != 50 {
+		t.Errorf("expected the 50 websites not analyzed, got %d", len(seen))
+	}
+	for id := range seen {
+		if id%2 != 1 {
+			t.Errorf("%d is analyzed", id)
+		}
+	}
+
+	// another task claims the rows again
+	other := New("other", "websites", "analyzed = 0")
+	if ids := drain(t, db, other); len(ids) != 50 {
+		t.Errorf("expected another task to get 50 websites, got %d", len(ids))
+	}
+}
+
+func TestSelector_shuffle(t *testing.T) {
+	db := openTestDB(t, 50)
+	defer db.Close()
+
+	first := drain(t, db, &Selector{Task: "a", Table: "websites", Seed: 1})
+	again := drain(t, db, &Selector{Task: "b", Table: "websites", Seed: 1})
+	seeded := drain(t, db, &Selector{Task: "c", Table: "websites", Seed: 2})
+	if !reflect.DeepEqual(first, again) {
+		t.Error("expected the same seed to give the same order")
+	}
+	if reflect.DeepEqual(first, seeded) {
+		t.Error("expected another seed to give another order")
+	}
+	sorted := true
+	for i := 1; i < len(first); i++ {
+		sorted = sorted && first[i-1] < first[i]
+	}
+	if sorted {
+		t.Error("expected the ids to be shuffled")
+	}
+}
+
+func TestSelector_expiredClaims(t *testing.T) {
+	db := openTestDB(t, 10)
+	defer db.Close()
+
+	if ids := drain(t, db, &Selector{Task: "scan", Table: "websites", Limit: 4}); len(ids) != 4 {
+		t.Fatalf("expected the limit to hand out 4 websites, got %d", len(ids))
+	}
+	if ids := drain(t, db, New("scan", "websites", "")); len(ids) != 6 {
+		t.Fatalf("expected 6 unclaimed websites, got %d", len(ids))
+	}
+
+	db.Model(&models.WorkClaim{}).Where("item_id <= ?", 3).Update("expires_at", time.Now().UTC().Add(-time.Minute))
+	if ids := drain(t, db, New("scan", "websites", "")); len(ids) != 3 {
+		t.Errorf("expected the 3 expired claims to be claimed again, got %v", ids)
+	}
+}
+
+func TestSelector_release(t *testing.T) {
+	db := openTestDB(t, 10)
+	defer db.Close()
+
+	s := New("scan", "websites", "")
+	s.BatchSize = 4
+	ids, err := s.Next(db)
+	if err != nil || len(ids) != 4 {
+		t.Fatalf("expected a batch of 4, got %v %v", ids, err)
+	}
+	other := New("scan", "websites", "")
+	rest := drain(t, db, other)
+	if len(rest) != 6 {
+		t.Fatalf("expected the batch to be held, got %v", rest)
+	}
+
+	// released, the rows are claimed again right away
+	if err := s.Done(db); err != nil {
+		t.Fatal(err)
+	}
+	again := drain(t, db, New("scan", "websites", ""))
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	sort.Slice(again, func(i, j int) bool { return again[i] < again[j] })
+	if !reflect.DeepEqual(again, ids) {
+		t.Errorf("expected the released batch %v to be claimed again, got %v", ids, again)
+	}
+
+	// and their claims are gone
+	if err := other.Release(db, rest); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	db.Model(&models.WorkClaim{}).Where("owner = ?", other.Owner).Count(&count)
+	if count != 0 {
+		t.Errorf("expected the released claims to be deleted, got %d", count)
+	}
+}
+
+func TestSelector_repeatedRun(t *testing.T) {
+	db := openTestDB(t, 10)
+	defer db.Close()
+
+	// a run over every row, eg. --from-cache, releasing its batches as a
+	// scanner does
+	run := func() (all []uint) {
+		s := New("scan-cache", "websites", "")
+		s.BatchSize = 3
+		for {
+			if err := s.Done(db); err != nil {
+				t.Fatal(err)
+			}
+			ids, err := s.Next(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ids) == 0 {
+				return all
+			}
+			all = append(all, ids...)
+		}
+	}
+	if ids := run(); len(ids) != 10 {
+		t.Fatalf("expected the first run to get 10 websites, got %d", len(ids))
+	}
+	if ids := run(); len(ids) != 10 {
+		t.Errorf("expected the run repeated right away to get 10 websites, got %d", len(ids))
+	}
+}
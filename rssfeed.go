@@ -7,18 +7,14 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/beevik/etree"
 	"github.com/gin-gonic/gin"
-	"github.com/go-sql-driver/mysql"
 	"github.com/gocolly/colly/v2"
 	"github.com/gocolly/colly/v2/proxy"
 	"github.com/gocolly/colly/v2/queue"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
-	_ "github.com/mattn/go-sqlite3"
 	"github.com/nozzle/throttler"
 	"github.com/qor/admin"
 	"github.com/qor/assetfs"
@@ -26,11 +22,16 @@ import (
 	"github.com/qor/validations"
 	"github.com/spf13/pflag"
 
+	"github.com/lucmichalski/dmoz-utils/pkg/bulk"
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
 	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+	"github.com/lucmichalski/dmoz-utils/pkg/jobqueue"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
 )
 
 var (
 	isHelp       bool
+	configFile   string
 	isVerbose    bool
 	isAdmin      bool
 	isDataset    bool
@@ -38,8 +39,8 @@ var (
 	isImport     bool
 	isDump       bool
 	parallelJobs int
-	queueMaxSize = 100000000
 	cachePath    = "./data/cache"
+	cfg          *config.Config
 	DB           *gorm.DB
 )
 
@@ -51,6 +52,7 @@ func main() {
 	pflag.BoolVarP(&isImport, "import", "i", false, "import rdf file to database.")
 	pflag.BoolVarP(&isAdmin, "admin", "a", false, "launch web admin.")
 	pflag.BoolVarP(&isVerbose, "verbose", "v", false, "verbose mode.")
+	pflag.StringVarP(&configFile, "config", "", "config.json", "configuration file, overridden by the ND_* environment variables.")
 	pflag.BoolVarP(&isHelp, "help", "h", false, "help info.")
 	pflag.Parse()
 	if isHelp {
@@ -58,13 +60,19 @@ func main() {
 		os.Exit(1)
 	}
 
-	var err error
 	if !isDump {
-		DB, err = gorm.Open("mysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?parseTime=True", "root", "megaweb", "localhost", "3308", "dataset_dmoz"))
+		var err error
+		cfg, err = config.Load(configFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		DB.AutoMigrate(&Website{}, &Category{}, &Rss{}, &Rank{})
+		DB, err = cfg.Database.Open()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := models.Migrate(DB); err != nil {
+			log.Fatal(err)
+		}
 		validations.RegisterCallbacks(DB)
 	}
 
@@ -86,10 +94,10 @@ func main() {
 		})
 
 		// Allow to use Admin to manage User, Product
-		website := Admin.AddResource(&Website{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -1})
-		website.IndexAttrs("ID", "Link", "Path")
+		website := Admin.AddResource(&models.Website{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -1})
+		website.IndexAttrs("ID", "Link", "Source", "Path")
 
-		category := Admin.AddResource(&Category{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -3})
+		category := Admin.AddResource(&models.Category{}, &admin.Config{Menu: []string{"Website Management"}, Priority: -3})
 		category.Meta(&admin.Meta{Name: "Categories", Type: "select_many"})
 
 		// initalize an HTTP request multiplexer
@@ -127,18 +135,28 @@ func main() {
 
 }
 
-// LOAD DATA INFILE '/root/dmoz_dataset.csv' INTO TABLE websites FIELDS TERMINATED BY '\t' ENCLOSED BY '"' LINES TERMINATED BY '\r\n' IGNORE 1 LINES (link,path);
 func loadData(csvFile string, DB *gorm.DB) {
 	fmt.Println("loading data from file...")
 
-	mysql.RegisterLocalFile(csvFile)
-	query := `LOAD DATA LOCAL INFILE '` + csvFile + `' INTO TABLE websites CHARACTER SET 'utf8mb4' FIELDS TERMINATED BY '\t' ENCLOSED BY '"' LINES TERMINATED BY '\n' IGNORE 1 LINES (link,path) SET created_at = NOW(), updated_at = NOW();`
-
-	fmt.Println(query)
-	err := DB.Exec(query).Error
+	file, err := os.Open(csvFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	now := time.Now()
+	loader := &bulk.Loader{
+		Table:      DB.NewScope(&models.Website{}).TableName(),
+		Columns:    []string{"link", "path"},
+		Key:        "link",
+		Values:     map[string]interface{}{"source": models.SourceDmoz, "created_at": now, "updated_at": now},
+		SkipHeader: true,
+	}
+	stats, err := loader.Load(DB, file)
 	if err != nil {
 		log.Fatal(err)
 	}
+	fmt.Println("loaded", csvFile, stats)
 }
 
 func importRdf(rdfFile string, DB *gorm.DB) {
@@ -177,7 +195,7 @@ func importRdf(rdfFile string, DB *gorm.DB) {
 		go func(entry *etree.Element) error {
 			defer t.Done(nil)
 
-			c := &Category{}
+			c := &models.Category{}
 			//catid
 			catId := entry.SelectElement("catid")
 			if catId != nil {
@@ -196,7 +214,7 @@ func importRdf(rdfFile string, DB *gorm.DB) {
 			// topicParts := strings.Split(strings.Replace(topic, "_", " ", -1), "/")
 			/*
 				var err error
-				var cc *Category
+				var cc *models.Category
 				for _, topicPart := range topicParts {
 					c.Name = topicPart
 					cc, err = createOrUpdateCategory(DB, c)
@@ -207,7 +225,7 @@ func importRdf(rdfFile string, DB *gorm.DB) {
 			c.Name = topic
 
 			var err error
-			cc := &Category{}
+			cc := &models.Category{}
 			if !isDump {
 				cc, err = createOrUpdateCategory(DB, c)
 				if err != nil {
@@ -220,9 +238,10 @@ func importRdf(rdfFile string, DB *gorm.DB) {
 			if link1 != nil {
 				url := link1.SelectAttr("r:resource").Value
 				log.Println("url is:", url)
-				website := &Website{}
+				website := &models.Website{}
 				website.Category = *cc
 				website.Link = url
+				website.Source = models.SourceDmoz
 				website.Path = topic
 
 				if !isDump {
@@ -242,9 +261,10 @@ func importRdf(rdfFile string, DB *gorm.DB) {
 				for _, url := range links {
 					urltxt := url.SelectAttr("r:resource").Value
 					log.Println("url is:", urltxt)
-					website := &Website{}
+					website := &models.Website{}
 					website.Category = *cc
 					website.Link = urltxt
+					website.Source = models.SourceDmoz
 					website.Path = topic
 					if !isDump {
 						_, err := createOrUpdateWebsite(DB, website)
@@ -290,16 +310,19 @@ func scanFeeds(DB *gorm.DB) {
 	c.SetProxyFunc(rp)
 
 	// create a request queue with 1 consumer thread
-	q, _ := queue.New(
+	storage := jobqueue.New(cfg.Queue, "rssfeed")
+	q, err := queue.New(
 		parallelJobs, // Number of consumer threads set to 1 to avoid dead lock on database
-		&queue.InMemoryQueueStorage{
-			MaxSize: queueMaxSize,
-		}, // Use default queue storage
+		storage,      // persisted in data/queue.db, resumed after a restart
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer storage.Close()
+	storage.Track(c)
 
 	c.OnError(func(r *colly.Response, err error) {
 		fmt.Println("error:", err, r.Request.URL, r.StatusCode)
-		q.AddURL(r.Request.URL.String())
 	})
 
 	c.OnResponse(func(r *colly.Response) {
@@ -318,7 +341,7 @@ func scanFeeds(DB *gorm.DB) {
 	})
 
 	c.OnHTML(`html`, func(e *colly.HTMLElement) {
-		var websiteExists Website
+		var websiteExists models.Website
 		if !DB.Where("link = ?", e.Request.Ctx.Get("url")).First(&websiteExists).RecordNotFound() {
 			fmt.Printf("skipping url=%s as already exists\n", e.Request.Ctx.Get("url"))
 			return
@@ -327,7 +350,7 @@ func scanFeeds(DB *gorm.DB) {
 		e.ForEach(`type="application/rss+xml"`, func(_ int, el *colly.HTMLElement) {
 			rss := el.Attr("href")
 			if rss != "" {
-				websiteExists.Rss = append(websiteExists.Rss, Rss{Href: rss})
+				websiteExists.Rss = append(websiteExists.Rss, models.Rss{Href: rss})
 			}
 		})
 
@@ -355,8 +378,8 @@ func scanFeeds(DB *gorm.DB) {
 
 }
 
-func createOrUpdateWebsite(db *gorm.DB, website *Website) (*Website, error) {
-	var existingWebsite Website
+func createOrUpdateWebsite(db *gorm.DB, website *models.Website) (*models.Website, error) {
+	var existingWebsite models.Website
 	if db.Where("link = ?", website.Link).First(&existingWebsite).RecordNotFound() {
 		err := db.Create(website).Error
 		return website, err
@@ -366,8 +389,8 @@ func createOrUpdateWebsite(db *gorm.DB, website *Website) (*Website, error) {
 	return website, nil
 }
 
-func createOrUpdateCategory(db *gorm.DB, cat *Category) (*Category, error) {
-	var existingCategory Category
+func createOrUpdateCategory(db *gorm.DB, cat *models.Category) (*models.Category, error) {
+	var existingCategory models.Category
 	if db.Where("name = ?", cat.Name).First(&existingCategory).RecordNotFound() {
 		err := db.Create(cat).Error
 		return cat, err
@@ -382,48 +405,3 @@ func checkErr(err error) {
 		log.Fatal(err)
 	}
 }
-
-type Website struct {
-	gorm.Model
-	Link        string   `gorm:"size:255;unique"`
-	Alive       bool     `gorm:"index:alive"`
-	Name        string   `gorm:"index:name"`
-	Path        string   `gorm:"index:path"`
-	Description string   `sql:"type:text"`
-	CategoryID  uint     `l10n:"sync"`
-	Category    Category `l10n:"sync"`
-	Ranking     Rank
-	Rss         []Rss
-}
-
-type Rss struct {
-	gorm.Model
-	Href string
-}
-
-type Rank struct {
-	gorm.Model
-	Alexa    int
-	Quancast int
-}
-
-type Category struct {
-	gorm.Model
-	Name       string `gorm:"index:name"`
-	Code       string `gorm:"index:code"`
-	Categories []Category
-	CategoryID uint `gorm:"index:category_id"`
-}
-
-func (category Category) Validate(db *gorm.DB) {
-	if strings.TrimSpace(category.Name) == "" {
-		db.AddError(validations.NewError(category, "Name", "Name can not be empty"))
-	}
-}
-
-func (category Category) DefaultPath() string {
-	if len(category.Code) > 0 {
-		return fmt.Sprintf("/category/%s", category.Code)
-	}
-	return "/"
-}
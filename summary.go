@@ -1,12 +1,8 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
@@ -15,20 +11,19 @@ import (
 	"github.com/gocolly/colly/v2/proxy"
 	"github.com/gocolly/colly/v2/queue"
 	"github.com/jinzhu/gorm"
-	_ "github.com/jinzhu/gorm/dialects/mysql"
-	_ "github.com/mattn/go-sqlite3"
-	"github.com/nozzle/throttler"
 	"github.com/qor/validations"
-	"github.com/samclarke/robotstxt"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 
-	"github.com/lucmichalski/dmoz-utils/pkg/articletext"
-	"github.com/lucmichalski/dmoz-utils/pkg/robotstxt"
+	"github.com/lucmichalski/dmoz-utils/pkg/config"
+	"github.com/lucmichalski/dmoz-utils/pkg/jobqueue"
+	"github.com/lucmichalski/dmoz-utils/pkg/models"
+	"github.com/lucmichalski/dmoz-utils/pkg/textextract"
 )
 
 var (
 	isHelp       bool
+	configFile   string
 	isVerbose    bool
 	isAdmin      bool
 	isDataset    bool
@@ -41,8 +36,8 @@ var (
 	isOffset     int
 	isLimit      int
 	parallelJobs int
-	queueMaxSize = 100000000
 	cachePath    = "./data/cache"
+	cfg          *config.Config
 	DB           *gorm.DB
 )
 
@@ -59,6 +54,7 @@ func main() {
 	pflag.BoolVarP(&isImport, "import", "i", false, "import rdf file to database.")
 	pflag.BoolVarP(&isAdmin, "admin", "a", false, "launch web admin.")
 	pflag.BoolVarP(&isVerbose, "verbose", "v", false, "verbose mode.")
+	pflag.StringVarP(&configFile, "config", "", "config.json", "configuration file, overridden by the ND_* environment variables.")
 	pflag.BoolVarP(&isHelp, "help", "h", false, "help info.")
 	pflag.Parse()
 	if isHelp {
@@ -66,18 +62,20 @@ func main() {
 		os.Exit(1)
 	}
 
-	var err error
 	if !isDump {
-		DB, err = gorm.Open("mysql", fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?charset=utf8mb4&collation=utf8mb4_unicode_ci&parseTime=True&loc=Local", "root", "megaweb", "localhost", "3308", "dataset_dmoz"))
+		var err error
+		cfg, err = config.Load(configFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		DB, err = cfg.Database.Open()
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		DB.Set("gorm:table_options", "ENGINE=InnoDB CHARSET=utf8mb4")
-		DB.AutoMigrate(&Website{})
-		DB.AutoMigrate(&Category{})
-		DB.AutoMigrate(&Rss{})
-		DB.AutoMigrate(&Rank{})
+		if err := models.Migrate(DB); err != nil {
+			log.Fatal(err)
+		}
 		validations.RegisterCallbacks(DB)
 	}
 
@@ -95,12 +93,16 @@ func main() {
 	}
 
 	// create a request queue with 1 consumer thread
-	q, _ := queue.New(
+	storage := jobqueue.New(cfg.Queue, "summary")
+	q, err := queue.New(
 		parallelJobs, // Number of consumer threads set to 1 to avoid dead lock on database
-		&queue.InMemoryQueueStorage{
-			MaxSize: queueMaxSize,
-		}, // Use default queue storage
+		storage,      // persisted in data/queue.db, resumed after a restart
 	)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer storage.Close()
+	storage.Track(c)
 
 	c.OnError(func(r *colly.Response, err error) {
 		fmt.Println("error:", err, r.Request.URL, r.StatusCode)
@@ -137,7 +139,7 @@ func main() {
 	var results []res
 	offset := isOffset * isLimit
 
-	query := fmt.Sprintf("select link FROM websites WHERE analyzed=1 ORDER BY RAND() LIMIT %d,%d", offset, isLimit)
+	query := fmt.Sprintf("select link FROM websites WHERE analyzed=1 ORDER BY %s LIMIT %d OFFSET %d", models.RandomOrder(DB), isLimit, offset)
 	fmt.Println("query:", query)
 
 	DB.Raw(query).Scan(&results)
@@ -153,8 +155,8 @@ func main() {
 
 }
 
-func createOrUpdateWebsite(db *gorm.DB, website *Website) (*Website, error) {
-	var existingWebsite Website
+func createOrUpdateWebsite(db *gorm.DB, website *models.Website) (*models.Website, error) {
+	var existingWebsite models.Website
 	if db.Where("link = ?", website.Link).First(&existingWebsite).RecordNotFound() {
 		err := db.Create(website).Error
 		return website, err
@@ -164,8 +166,8 @@ func createOrUpdateWebsite(db *gorm.DB, website *Website) (*Website, error) {
 	return website, nil
 }
 
-func createOrUpdateCategory(db *gorm.DB, cat *Category) (*Category, error) {
-	var existingCategory Category
+func createOrUpdateCategory(db *gorm.DB, cat *models.Category) (*models.Category, error) {
+	var existingCategory models.Category
 	if db.Where("name = ?", cat.Name).First(&existingCategory).RecordNotFound() {
 		err := db.Create(cat).Error
 		return cat, err
@@ -180,56 +182,3 @@ func checkErr(err error) {
 		log.Fatal(err)
 	}
 }
-
-type Website struct {
-	gorm.Model
-	Link        string   `gorm:"size:255;unique"`
-	Alive       bool     `gorm:"index:alive"`
-	StatusCode  int      `gorm:"index:status_code"`
-	Name        string   `gorm:"index:name; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Path        string   `gorm:"index:path; type:longtext; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci"`
-	Title       string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Description string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	CategoryID  uint     `l10n:"sync"`
-	Category    Category `l10n:"sync"`
-	Wap         string   `gorm:"type:longblob; CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci" sql:"type:longblob"`
-	Analyzed    int      `gorm:"type:tinyint" sql:"type:tinyint`
-	Ranking     Rank
-	Rss         []Rss
-}
-
-type Rss struct {
-	gorm.Model
-	Href               string `sql:"type:longtext"`
-	Language           string
-	LanguageConfidence float64
-	WebsiteID          uint
-}
-
-type Rank struct {
-	gorm.Model
-	Alexa    int
-	Quancast int
-	Majestic int
-}
-
-type Category struct {
-	gorm.Model
-	Name       string `gorm:"index:name"`
-	Code       string `gorm:"index:code"`
-	Categories []Category
-	CategoryID uint `gorm:"index:category_id"`
-}
-
-func (category Category) Validate(db *gorm.DB) {
-	if strings.TrimSpace(category.Name) == "" {
-		db.AddError(validations.NewError(category, "Name", "Name can not be empty"))
-	}
-}
-
-func (category Category) DefaultPath() string {
-	if len(category.Code) > 0 {
-		return fmt.Sprintf("/category/%s", category.Code)
-	}
-	return "/"
-}
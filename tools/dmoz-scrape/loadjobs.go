@@ -8,16 +8,15 @@ import (
     "encoding/csv"
     "encoding/json"
     "strings"
-    "github.com/vmihailenco/redis"
+    "github.com/lucmichalski/dmoz-utils/pkg/jobqueue"
 )
 
-type RedisConfig struct {Host string; Password string; DB int64; ConnPoolSize int;}
+type QueueConfig struct {Path string}
 type PostgresConfig struct {DBName string; User string; Password string; Host string; Port int64; SSLMode string}
-type Config struct {Redis RedisConfig; Postgres PostgresConfig}
+type Config struct {Queue QueueConfig; Postgres PostgresConfig}
 
 var (
-    redisConfig = RedisConfig {Host: "localhost:6379", Password: "", DB: -1, ConnPoolSize: 10}
-    redisConn *redis.Client
+    queueConfig = QueueConfig {Path: "../../data/queue.db"}
     domainBlacklist = make(map[string] bool)
 )
 
@@ -37,19 +36,19 @@ func readConfig() {
             if err != nil {
                 log.Println("settings.json is invalid. Using defaults.")
             } else {
-                redisConfig = configObj.Redis
+                queueConfig = configObj.Queue
             }
         }
     }
 }
 
-func getRedisConn()  *redis.Client {
-    if redisConn == nil {
-        redisConn := redis.NewTCPClient(redisConfig.Host, redisConfig.Password, redisConfig.DB)
-        defer redisConn.Close()
-        return redisConn
+// getQueue opens the urljobs queue, shared with the scraper
+func getQueue() *jobqueue.Storage {
+    queue := &jobqueue.Storage{Path: queueConfig.Path, Name: "urljobs"}
+    if err := queue.Init(); err != nil {
+        log.Fatal(err)
     }
-    return redisConn
+    return queue
 }
 
 func main() {
@@ -68,9 +67,8 @@ func main() {
 
     csvStream := csv.NewReader(file)
 
-    conn := getRedisConn()
-    multi, err := conn.MultiClient()
-    defer multi.Close()
+    queue := getQueue()
+    defer queue.Close()
 
     for line, err := csvStream.Read(); err == nil; line, err = csvStream.Read() {
         url := line[0]
@@ -79,15 +77,9 @@ func main() {
         catSegments := strings.Split(cats, "/")
 
         if !domainBlacklist[catSegments[1]] {
-            reqs, transErr := multi.Exec(func() {
-                if !multi.SIsMember("urlset", url).Val() {
-                    multi.RPush("urljobs", url)
-                    multi.SAdd("urlset", url)
-                }
-            })
-            if transErr == redis.Nil {
-                fmt.Println("TRANSACTION_ERR ", err)
-                fmt.Println("REQS ", reqs)
+            // urls already queued are skipped
+            if err := queue.AddURL(url); err != nil {
+                fmt.Println("QUEUE_ERR ", url, err)
             }
         }
     }
@@ -3,51 +3,43 @@ package main
 import (
     "os"
     "math"
-    "bytes"
-    "io"
+    "context"
     "unicode/utf8"
     "strings"
     "encoding/json"
-    "net"
-    "net/http"
     "io/ioutil"
     "fmt"
     "log"
     "regexp"
     "runtime"
     "time"
-    urllib "net/url"
     "database/sql"
-    "github.com/vmihailenco/redis"
+    "github.com/lucmichalski/dmoz-utils/pkg/fetch"
+    "github.com/lucmichalski/dmoz-utils/pkg/jobqueue"
     _ "github.com/bmizerany/pq"
-    "code.google.com/p/go-charset/charset"
-    _ "code.google.com/p/go-charset/data"
-    "github.com/saintfish/chardet"
 )
 
-type RedisConfig struct {Host string; Password string; DB int64; ConnPoolSize int;}
+type QueueConfig struct {Path string}
 type PostgresConfig struct {DBName string; User string; Password string; Host string; Port int64; SSLMode string}
 type ScraperConfig struct {QueueTimeout int64; UserAgent string}
-type Config struct {Redis RedisConfig; Postgres PostgresConfig; Scraper ScraperConfig}
+type Config struct {Queue QueueConfig; Postgres PostgresConfig; Scraper ScraperConfig}
 
 var (
     // regex is case-insensitive and counts newlines in `.`
     titleRegex = regexp.MustCompile("(?is)<title\\s*(?:[A-Za-z]+=[\"'][A-Za-z0-9_ -]+[\"'])?>([^<]+)</title>")
-    metaEquiv = regexp.MustCompile("(?is)<meta\\s+http-equiv=[\"']?refresh[\"']?\\s+.+url=\\s*([^\"']+)")
-    charsetRegex = regexp.MustCompile("(?i)charset=([A-Za-z0-9-]+)")
 
     numCPU = int(math.Max(float64(runtime.NumCPU()), 4))
     workPool = make(chan bool, int(math.Max(float64(numCPU*4), 20)))
 
     scraperConfig = ScraperConfig {QueueTimeout: 10, UserAgent: "titleScraper/1.0"}
 
-    redisConfig = RedisConfig {Host: "localhost:6379", Password: "", DB: -1, ConnPoolSize: 10}
-    redisConn *redis.Client
+    queueConfig = QueueConfig {Path: "../../data/queue.db"}
+    queue *jobqueue.Storage
 
     pgConfig = PostgresConfig {DBName: "titlescraper", Password: "", User: "", Port: 5432, SSLMode: "disable"}
     pgConn *sql.DB
 
-    chartypeSet = make(map[string]bool)
+    fetcher *fetch.Fetcher
 )
 
 func readConfig() {
@@ -66,7 +58,7 @@ func readConfig() {
             if err != nil {
                 log.Println("settings.json is invalid. Using defaults.")
             } else {
-                redisConfig = configObj.Redis
+                queueConfig = configObj.Queue
                 pgConfig = configObj.Postgres
                 scraperConfig = configObj.Scraper
             }
@@ -74,89 +66,19 @@ func readConfig() {
     }
 }
 
-func timeoutDialler(timeout time.Duration) func(net, addr string) (client net.Conn, err error) {
-    return func(netw, addr string) (net.Conn, error) {
-        client, err := net.DialTimeout(netw, addr, time.Duration(30*time.Second))
-        if err != nil {
-            return nil, err
-        }
-        client.SetDeadline(time.Now().Add(timeout))
-        return client, nil
-    }
-}
-
 func fetchPage(url string) string {
-    req, err := http.NewRequest("GET", url, nil)
-    req.Header.Set("User-Agent", scraperConfig.UserAgent)
-
-    httpClient := http.Client{
-        Transport: &http.Transport{
-            Dial: timeoutDialler(time.Duration(10*time.Second)),
-            DisableKeepAlives: true,
-        },
-    }
-
-    resp, err := httpClient.Do(req)
+    resp, err := fetcher.Get(context.Background(), url)
     if err != nil {
         log.Println("HTTP_ERROR:", err)
         return ""
     }
-    defer resp.Body.Close()
 
     if resp.StatusCode == 200 {
-        var dataStream io.Reader
-
-        switch charType := fetchCharset(resp.Header.Get("Content-Type")); {
-
-        case charType == "utf-8":
-            dataStream = resp.Body
-
-        case chartypeSet[charType]:
-            // charset in available list for conversion
-            charsetStream, err := charset.NewReader(charType, resp.Body)
-            if err != nil {
-                log.Println("ENCODING_ERROR:", err)
-            } else {
-                dataStream = charsetStream
-            }
-
-        default:
-            //need to guess chartype
-            bodyBytes, err := ioutil.ReadAll(resp.Body)
-            if err != nil {
-                log.Println("IO_ERROR:", err)
-            }
-
-            detector := chardet.NewHtmlDetector()
-            result, err := detector.DetectBest(bodyBytes)
-            if err != nil {
-                log.Println("ENCODING_ERROR no_known_encoding", url)
-                return ""
-            }
-
-            charType = strings.ToLower(result.Charset)
-            if chartypeSet[charType] {
-                dataStream = bytes.NewReader(bodyBytes)
-                charsetStream, err := charset.NewReader(charType, dataStream)
-                if err != nil {
-                    log.Println("ENCODING_ERROR:", err)
-                } else {
-                    dataStream = charsetStream
-                }
-            }
-        }
-
-        if dataStream != nil {
-            var bodyBytes []byte
-            bodyBytes, err := ioutil.ReadAll(dataStream)
-            if err != nil {
-                log.Println("ERROR:", err)
-            }
-
-            return string(bodyBytes)
-        } else {
+        if resp.Charset == "" {
             log.Println("ENCODING_ERROR: no suitable encoding found for", url)
+            return ""
         }
+        return resp.Text()
     }
     return ""
 }
@@ -171,49 +93,6 @@ func fetchTitle(bodyText string) string {
     return ""
 }
 
-func fetchMetaRedirect(bodyText string, url string) string {
-    if bodyText != "" {
-        matches := metaEquiv.FindStringSubmatch(bodyText)
-        if matches != nil {
-            uri,err := urllib.Parse(matches[1])
-            if err != nil {
-                log.Println("URL_ERROR cannot parse", matches[1])
-                return ""
-            }
-
-            if uri.Host != "" {
-                // redirect url is fully qualified
-                return matches[1]
-            } else {
-                // needs stitching
-                origUri,err := urllib.Parse(url)
-                if err != nil {
-                    log.Println("URL_ERROR cannot parse", matches[1])
-                    return ""
-                }
-
-                uri.Host = origUri.Host
-                uri.Scheme = origUri.Scheme
-                if !strings.HasPrefix(uri.Path, "/") {
-                    uri.Path = "/" + uri.Path
-                }
-                return uri.String()
-            }
-        }
-    }
-    return ""
-}
-
-func fetchCharset(header string) string {
-    if header != "" {
-        matches := charsetRegex.FindStringSubmatch(header)
-        if matches != nil {
-            return strings.ToLower(matches[1])
-        }
-    }
-    return ""
-}
-
 func fetchTitleJob(url string) {
     bodyText := fetchPage(url)
     if bodyText != "" {
@@ -242,7 +121,7 @@ func fetchTitleJob(url string) {
                 }
             }
         } else {
-            metaRefreshUrl := fetchMetaRedirect(bodyText, url)
+            metaRefreshUrl, _ := fetch.ClientRedirect([]byte(bodyText), url)
             if metaRefreshUrl == "" {
                 log.Println("TITLE_ERROR title_not_found", url)
             } else {
@@ -250,18 +129,27 @@ func fetchTitleJob(url string) {
             }
         }
     }
+}
 
+// scrapeJob fetches the title of a url popped off the queue, then removes
+// it from the queue. Urls of a crashed scraper are handed out again once
+// their visibility timeout expires.
+func scrapeJob(url string) {
+    fetchTitleJob(url)
+    if err := queue.Done(url); err != nil {
+        log.Println("QUEUE_ERROR url:", url, err)
+    }
     <-workPool
 }
 
-func getRedisConn() *redis.Client {
-    if redisConn == nil {
-        redisConn := redis.NewTCPClient(redisConfig.Host, redisConfig.Password, redisConfig.DB)
-        redisConn.ConnPool.(*redis.MultiConnPool).MaxCap = redisConfig.ConnPoolSize
-        defer redisConn.Close()
-        return redisConn
+func getQueue() *jobqueue.Storage {
+    if queue == nil {
+        queue = &jobqueue.Storage{Path: queueConfig.Path, Name: "urljobs"}
+        if err := queue.Init(); err != nil {
+            log.Fatal("QUEUE ERROR:", err)
+        }
     }
-    return redisConn
+    return queue
 }
 
 func getPGConn() *sql.DB {
@@ -279,30 +167,36 @@ func getPGConn() *sql.DB {
 func main() {
     readConfig()
 
-    // load up available charsets
-    for _,name := range charset.Names() {
-        chartypeSet[name] = true
-    }
+    // pages are decoded to utf-8 from their declared or sniffed charset
+    fetcher = &fetch.Fetcher{UserAgent: scraperConfig.UserAgent, Timeout: 10 * time.Second}
 
     log.Printf("Starting with %d processes\n", numCPU)
     runtime.GOMAXPROCS(numCPU)
 
-    conn := getRedisConn()
+    queue := getQueue()
+    defer queue.Close()
 
+    idle := time.Now()
     for {
-        obj := conn.BLPop(scraperConfig.QueueTimeout, "urljobs")
-        err := obj.Err()
+        url, err := queue.GetURL()
         if err != nil {
-            if err == redis.Nil {
+            log.Fatalf("ERROR: %s\n", err)
+        }
+        if url == "" {
+            if time.Since(idle) > time.Duration(scraperConfig.QueueTimeout) * time.Second {
                 log.Printf("No job seen for %d seconds. Exiting.\n", scraperConfig.QueueTimeout)
                 break
-            } else {
-                log.Fatalf("ERROR: %s\n", err)
             }
-        } else {
-            url := obj.Val()[1]
-            workPool <- true
-            go fetchTitleJob(url)
+            time.Sleep(time.Second)
+            continue
         }
+        idle = time.Now()
+        workPool <- true
+        go scrapeJob(url)
+    }
+
+    // let the last jobs finish
+    for i := 0; i < cap(workPool); i++ {
+        workPool <- true
     }
 }
@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"os"
 	"strconv"
-	"strings"
 
 	"github.com/abadojack/whatlanggo"
 
 	ccsv "github.com/lucmichalski/dmoz-utils/pkg/csv"
+	"github.com/lucmichalski/dmoz-utils/pkg/rdf"
 )
 
 func main() {
@@ -34,30 +34,12 @@ func main() {
 
 		// var refined
 
-		cats := strings.Split(entry[3], "/")
-		if len(cats) < 2 {
-			continue
-		}
-
 		fullPath := entry[3]
-		var parentPath string
-		switch cats[1] {
-		case "Regional":
-			if len(cats) < 5 {
-				continue
-			}
-			entry[3] = cats[4]
-			parentPath = strings.Join(cats[:4], "/")
-		case "World":
-			if len(cats) < 4 {
-				continue
-			}
-			entry[3] = cats[3]
-			parentPath = strings.Join(cats[:3], "/")
-		default:
-			parentPath = cats[0]
-			entry[3] = cats[1]
+		category, parentPath, ok := rdf.TopLevel(fullPath)
+		if !ok {
+			continue
 		}
+		entry[3] = category
 
 		info := whatlanggo.Detect(entry[2])
 		entry = append(entry, info.Lang.String())